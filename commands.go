@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/thealamu/linkedinsignin/config"
+	"github.com/thealamu/linkedinsignin/csvimport"
+	"github.com/thealamu/linkedinsignin/export"
+	"github.com/thealamu/linkedinsignin/repository"
+)
+
+// runCommand runs the subcommand name with args instead of starting the
+// server.
+func runCommand(logger zerolog.Logger, env config.Environment, name string, args []string) error {
+	switch name {
+	case "reconcile":
+		return reconcile(logger, env, args)
+	case "export-user":
+		return exportUser(logger, env, args)
+	case "export-users":
+		return exportUsers(logger, env, args)
+	case "migrate":
+		return migrate(logger, env, args)
+	case "merge-users":
+		return mergeUsers(logger, env, args)
+	case "import":
+		return importUsers(logger, env, args)
+	case "out-of-sync":
+		return outOfSync(logger, env, args)
+	default:
+		return fmt.Errorf("unknown command '%s'", name)
+	}
+}
+
+func reconcile(logger zerolog.Logger, env config.Environment, args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "copy the winning document over the losing one")
+	policy := fs.String("policy", string(repository.PrimaryWins), "winner for conflicting documents: primary-wins or newest")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if !repository.ValidWinnerPolicy(repository.WinnerPolicy(*policy)) {
+		return fmt.Errorf("unknown policy '%s'", *policy)
+	}
+	if env[config.UserStore] != config.UserStoreFirestore {
+		return fmt.Errorf("reconcile needs the firestore user store")
+	}
+
+	replicas, err := config.Replicas(env)
+	if err != nil {
+		return err
+	}
+	users := repository.NewUserRepository(logger, repository.EmailPolicyFrom(env), replicas)
+	defer users.Close()
+
+	reports, err := users.Reconcile(context.Background(), *repair, repository.WinnerPolicy(*policy))
+	for _, report := range reports {
+		printReconcileReport(os.Stdout, report)
+	}
+	return err
+}
+
+// outOfSync lists the replica writes still waiting in the outbox, optionally
+// retrying them first.
+func outOfSync(logger zerolog.Logger, env config.Environment, args []string) error {
+	fs := flag.NewFlagSet("out-of-sync", flag.ExitOnError)
+	sync := fs.Bool("sync", false, "retry every outstanding write before listing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if env[config.UserStore] != config.UserStoreFirestore {
+		return fmt.Errorf("out-of-sync needs the firestore user store")
+	}
+
+	replicas, err := config.Replicas(env)
+	if err != nil {
+		return err
+	}
+	users := repository.NewUserRepository(logger, repository.EmailPolicyFrom(env), replicas)
+	defer users.Close()
+
+	ctx := context.Background()
+	if *sync {
+		if err := users.SyncReplicas(ctx); err != nil {
+			return err
+		}
+	}
+
+	writes, err := users.OutOfSyncUsers(ctx)
+	if err != nil {
+		return err
+	}
+	printOutOfSync(os.Stdout, writes)
+	return nil
+}
+
+// migrate upgrades the user documents of every replica, or the sql
+// database, to the current schema version. Users are looked up by email
+// key, so Firestore documents still stored under another ID are then moved
+// under their key, as merge-users does; migrate has to run before a build
+// doing so serves anyone. Last, Firestore users sharing a LinkedIn profile
+// are flagged, which the sql migration does as it goes.
+func migrate(logger zerolog.Logger, env config.Environment, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only count the documents that would be migrated")
+	batchSize := fs.Int("batch", 200, "documents read and checkpointed at a time")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch env[config.UserStore] {
+	case config.UserStoreFirestore:
+	case config.UserStoreSQL:
+		return migrateSQL(env, *dryRun)
+	default:
+		return fmt.Errorf("the %s user store has no schema to migrate", env[config.UserStore])
+	}
+
+	replicas, err := config.Replicas(env)
+	if err != nil {
+		return err
+	}
+	users := repository.NewUserRepository(logger, repository.EmailPolicyFrom(env), replicas)
+	defer users.Close()
+
+	reports, err := users.MigrateUsers(context.Background(), repository.MigrateOptions{
+		DryRun:    *dryRun,
+		BatchSize: *batchSize,
+	})
+	for _, report := range reports {
+		printMigrationReport(os.Stdout, report, *dryRun)
+	}
+	if err != nil {
+		return err
+	}
+
+	merges, err := users.MergeDuplicateUsers(context.Background(), *dryRun)
+	printUserMerges(os.Stdout, merges)
+	if err != nil {
+		return err
+	}
+
+	flagged, err := users.FlagSharedLinkedIn(context.Background(), *dryRun)
+	for _, user := range flagged {
+		fmt.Fprintf(os.Stdout, "%s: shares the LinkedIn profile %s\n", user.Email, user.LinkedInURL)
+	}
+	fmt.Fprintf(os.Stdout, "%d users to flag as sharing a LinkedIn profile\n", len(flagged))
+	return err
+}
+
+// migrateSQL applies the pending migrations of the sql database, which
+// otherwise runs them as the server starts.
+func migrateSQL(env config.Environment, dryRun bool) error {
+	db, err := sql.Open(env[config.DatabaseDriver], env[config.DatabaseURL])
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrations, err := repository.MigrateSQL(context.Background(), db, dryRun)
+	for _, m := range migrations {
+		fmt.Fprintf(os.Stdout, "%d: %s\n", m.Version, m.Name)
+	}
+	if dryRun {
+		fmt.Fprintf(os.Stdout, "%d migrations to apply\n", len(migrations))
+	} else {
+		fmt.Fprintf(os.Stdout, "%d migrations applied\n", len(migrations))
+	}
+	return err
+}
+
+// mergeUsers stores every user under its email key, folding duplicates.
+func mergeUsers(logger zerolog.Logger, env config.Environment, args []string) error {
+	fs := flag.NewFlagSet("merge-users", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only list the users that would be merged")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	rc := repository.NewContainer(logger, env)
+	defer rc.Close()
+	merger, ok := rc.UserRepository.(repository.UserMerger)
+	if !ok {
+		return fmt.Errorf("the %s user store has nothing to merge", env[config.UserStore])
+	}
+
+	merges, err := merger.MergeDuplicateUsers(context.Background(), *dryRun)
+	printUserMerges(os.Stdout, merges)
+	return err
+}
+
+// importUsers creates users from the rows of a CSV file.
+func importUsers(logger zerolog.Logger, env config.Environment, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	file := fs.String("file", "", "CSV file to import")
+	dryRun := fs.Bool("dry-run", false, "validate the file without creating anybody")
+	batchSize := fs.Int("batch", csvimport.DefaultBatchSize, "users written at a time, lowered to what the store takes at once")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *file == "" {
+		return fmt.Errorf("-file is required")
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, enrollment, err := loadForm(env)
+	if err != nil {
+		return err
+	}
+
+	rc := repository.NewContainer(logger, env)
+	defer rc.Close()
+	report, err := csvimport.Import(context.Background(), rc.UserRepository, f, csvimport.Options{
+		DryRun:    *dryRun,
+		BatchSize: *batchSize,
+		Keys:      repository.EmailPolicyFrom(env),
+		Form:      enrollment,
+	})
+	if report != nil {
+		printImportReport(os.Stdout, report)
+	}
+	return err
+}
+
+// exportUser writes the subject access bundle of one applicant.
+func exportUser(logger zerolog.Logger, env config.Environment, args []string) error {
+	fs := flag.NewFlagSet("export-user", flag.ExitOnError)
+	userEmail := fs.String("email", "", "email of the applicant")
+	format := fs.String("format", "json", "bundle format: json or html")
+	out := fs.String("out", "", "file to write to, stdout when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *userEmail == "" {
+		return fmt.Errorf("-email is required")
+	}
+	if *format != "json" && *format != "html" {
+		return fmt.Errorf("unknown format '%s'", *format)
+	}
+
+	rc := repository.NewContainer(logger, env)
+	defer rc.Close()
+	bundle, err := export.NewSubjectBundle(context.Background(), rc.UserRepository, *userEmail)
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "html" {
+		return bundle.WriteHTML(w)
+	}
+	return bundle.WriteJSON(w)
+}
+
+// exportUsers writes the users matching the filters to a spreadsheet.
+func exportUsers(logger zerolog.Logger, env config.Environment, args []string) error {
+	fs := flag.NewFlagSet("export-users", flag.ExitOnError)
+	format := fs.String("format", export.FormatCSV, "spreadsheet format: csv or xlsx")
+	out := fs.String("out", "", "file to write to, stdout when empty")
+	enrolled := fs.String("enrolled", "", "only users who have (true) or have not (false) enrolled")
+	track := fs.String("learning-track", "", "only users on this learning track")
+	createdAfter := fs.String("created-after", "", "only users who signed up on or after this date, YYYY-MM-DD")
+	createdBefore := fs.String("created-before", "", "only users who signed up before this date, YYYY-MM-DD")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	filter := repository.UserFilter{LearningTrack: *track}
+	if *enrolled != "" {
+		v, err := strconv.ParseBool(*enrolled)
+		if err != nil {
+			return fmt.Errorf("-enrolled must be true or false")
+		}
+		filter.Enrolled = &v
+	}
+	var err error
+	if filter.CreatedAfter, err = parseFlagDate(*createdAfter); err != nil {
+		return fmt.Errorf("-created-after: %w", err)
+	}
+	if filter.CreatedBefore, err = parseFlagDate(*createdBefore); err != nil {
+		return fmt.Errorf("-created-before: %w", err)
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	sheet, err := export.NewSheet(*format, w)
+	if err != nil {
+		return err
+	}
+
+	rc := repository.NewContainer(logger, env)
+	defer rc.Close()
+	n, err := export.WriteUsers(context.Background(), rc.UserRepository, filter, sheet)
+	if err != nil {
+		return err
+	}
+	if err := sheet.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d users\n", n)
+	return nil
+}
+
+func parseFlagDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func printReconcileReport(w io.Writer, r *repository.ReconcileReport) {
+	fmt.Fprintf(w, "Replica %s: scanned %d users\n", r.Replica, r.Scanned)
+
+	fmt.Fprintf(w, "\nMissing in primary (%d):\n", len(r.MissingInPrimary))
+	for _, email := range r.MissingInPrimary {
+		fmt.Fprintf(w, "  %s\n", email)
+	}
+
+	fmt.Fprintf(w, "\nMissing in secondary (%d):\n", len(r.MissingInSecondary))
+	for _, email := range r.MissingInSecondary {
+		fmt.Fprintf(w, "  %s\n", email)
+	}
+
+	fmt.Fprintf(w, "\nErased but still in secondary (%d):\n", len(r.Erased))
+	for _, email := range r.Erased {
+		fmt.Fprintf(w, "  %s\n", email)
+	}
+
+	fmt.Fprintf(w, "\nConflicting users (%d):\n", len(r.Conflicts))
+	for _, c := range r.Conflicts {
+		fmt.Fprintf(w, "  %s\n", c.Email)
+		for _, f := range c.Fields {
+			fmt.Fprintf(w, "    %s: primary=%q secondary=%q\n", f.Field, fmt.Sprint(f.Primary), fmt.Sprint(f.Secondary))
+		}
+	}
+
+	fmt.Fprintf(w, "\nRepaired %d documents\n\n", r.Repaired)
+}
+
+func printOutOfSync(w io.Writer, writes []repository.ReplicaWrite) {
+	for _, rw := range writes {
+		fmt.Fprintf(w, "%s on %s: %s %s, %d attempts", rw.Email, rw.Replica, rw.Op, rw.Status, rw.Attempts)
+		if rw.LastError != "" {
+			fmt.Fprintf(w, ", last error: %s", rw.LastError)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%d writes out of sync\n", len(writes))
+}
+
+func printUserMerges(w io.Writer, merges []repository.UserMerge) {
+	for _, m := range merges {
+		if len(m.Merged) == 0 {
+			fmt.Fprintf(w, "%s: keyed %s\n", m.Key, m.Kept)
+			continue
+		}
+		fmt.Fprintf(w, "%s: kept %s, merged %s\n", m.Key, m.Kept, strings.Join(m.Merged, ", "))
+	}
+	fmt.Fprintf(w, "%d users to key or merge\n", len(merges))
+}
+
+func printMigrationReport(w io.Writer, report *repository.MigrationReport, dryRun bool) {
+	verb := "migrated"
+	if dryRun {
+		verb = "would migrate"
+	}
+	fmt.Fprintf(w, "%s: %s %d of %d users to schema version %d\n", report.Replica, verb, report.Migrated, report.Scanned, report.Version)
+	if report.ResumedAfter != "" {
+		fmt.Fprintf(w, "  resumed after %s\n", report.ResumedAfter)
+	}
+}
+
+func printImportReport(w io.Writer, r *csvimport.Report) {
+	verb := "Created"
+	if r.DryRun {
+		verb = "Would create"
+	}
+	fmt.Fprintf(w, "Rows: %d\n%s: %d\nAlready existing: %d\n", r.Rows, verb, len(r.Created), len(r.Existing))
+
+	fmt.Fprintf(w, "\nRejected rows (%d):\n", len(r.Errors))
+	for _, e := range r.Errors {
+		fmt.Fprintf(w, "  line %d %s: %s\n", e.Line, e.Email, e.Error)
+	}
+}
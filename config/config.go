@@ -1,8 +1,11 @@
 package config
 
 import (
+	"database/sql"
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 )
 
 const (
@@ -12,25 +15,151 @@ const (
 	ServiceAccount1 = "SERVICE_ACCOUNT_1"
 	ServiceAccount2 = "SERVICE_ACCOUNT_2"
 	MailChimpAPIKey = "MAILCHIMP_API_KEY"
+	UserStore       = "USER_STORE"
+	// DatabaseDriver is the database/sql driver of the sql user store:
+	// postgres, or sqlite3 in builds tagged sqlite.
+	DatabaseDriver = "DATABASE_DRIVER"
+	DatabaseURL    = "DATABASE_URL"
+
+	// AdminAPIKey guards the admin endpoints. They are disabled when it is
+	// empty.
+	AdminAPIKey = "ADMIN_API_KEY"
+	// UsersPageSizeMax caps the page size of the users listing.
+	UsersPageSizeMax = "USERS_PAGE_SIZE_MAX"
+
+	// EmailFoldGmail makes Gmail addresses that only differ in dots or a
+	// +suffix count as the same applicant.
+	EmailFoldGmail = "EMAIL_FOLD_GMAIL"
+
+	// TombstoneSecret keys the email hashes left behind when a user is
+	// erased. It is required by the stores that persist users, and can't
+	// change without losing track of who was erased.
+	TombstoneSecret = "TOMBSTONE_SECRET"
+
+	// FirestoreReplicas is a JSON list of Replica definitions. When it is
+	// not set, ServiceAccount1 and ServiceAccount2 are used instead.
+	FirestoreReplicas = "FIRESTORE_REPLICAS"
+
+	// ReplicaSyncInterval is how often pending writes to the secondary
+	// Firestore replicas are retried.
+	ReplicaSyncInterval = "REPLICA_SYNC_INTERVAL"
+
+	// StatsCacheTTL is how long the enrollment stats are served before they
+	// are aggregated again.
+	StatsCacheTTL = "STATS_CACHE_TTL"
+
+	// OptionsFile is the JSON option catalog of the enrollment form. It is
+	// required unless the form schema lists the choices of every choice
+	// question itself.
+	OptionsFile = "OPTIONS_FILE"
+
+	// FormSchemaFile is the JSON schema of the enrollment form questions.
+	// The schema built into the binary is used when it is empty.
+	FormSchemaFile = "FORM_SCHEMA_FILE"
+)
+
+// Supported values for UserStore.
+const (
+	UserStoreFirestore = "firestore"
+	UserStoreMemory    = "memory"
+	UserStoreSQL       = "sql"
 )
 
 type Environment map[string]string
 
+// defaults holds the keys that may be left out of the environment, along
+// with the value used in their place.
+var defaults = map[string]string{
+	UserStore:           UserStoreFirestore,
+	DatabaseDriver:      "postgres",
+	ReplicaSyncInterval: "1m",
+	AdminAPIKey:         "",
+	UsersPageSizeMax:    "100",
+	EmailFoldGmail:      "false",
+	TombstoneSecret:     "",
+	StatsCacheTTL:       "5m",
+	OptionsFile:         "",
+	FormSchemaFile:      "",
+}
+
 func New() (Environment, error) {
 	env := make(Environment)
-	for _, key := range []string{
+	if err := lookup(env,
 		Port,
 		ClientID,
 		ClientSecret,
-		ServiceAccount1,
-		ServiceAccount2,
 		MailChimpAPIKey,
-	} {
+	); err != nil {
+		return nil, err
+	}
+
+	for key, value := range defaults {
+		if v, ok := os.LookupEnv(key); ok && v != "" {
+			value = v
+		}
+		env[key] = value
+	}
+
+	if n, err := strconv.Atoi(env[UsersPageSizeMax]); err != nil || n < 1 {
+		return nil, fmt.Errorf("'%s' must be a positive number", UsersPageSizeMax)
+	}
+	if _, err := strconv.ParseBool(env[EmailFoldGmail]); err != nil {
+		return nil, fmt.Errorf("'%s' must be true or false", EmailFoldGmail)
+	}
+
+	if _, err := time.ParseDuration(env[StatsCacheTTL]); err != nil {
+		return nil, fmt.Errorf("invalid '%s': %w", StatsCacheTTL, err)
+	}
+
+	switch env[UserStore] {
+	case UserStoreFirestore:
+		if v, ok := os.LookupEnv(FirestoreReplicas); ok && v != "" {
+			env[FirestoreReplicas] = v
+		} else if err := lookup(env, ServiceAccount1, ServiceAccount2); err != nil {
+			return nil, err
+		}
+		if _, err := Replicas(env); err != nil {
+			return nil, err
+		}
+		if _, err := time.ParseDuration(env[ReplicaSyncInterval]); err != nil {
+			return nil, fmt.Errorf("invalid '%s': %w", ReplicaSyncInterval, err)
+		}
+	case UserStoreSQL:
+		if err := lookup(env, DatabaseURL); err != nil {
+			return nil, err
+		}
+		if !hasDriver(env[DatabaseDriver]) {
+			return nil, fmt.Errorf("database driver '%s' is not built in, sqlite3 needs the sqlite build tag", env[DatabaseDriver])
+		}
+	case UserStoreMemory:
+	default:
+		return nil, fmt.Errorf("unknown user store '%s'", env[UserStore])
+	}
+
+	if env[UserStore] != UserStoreMemory && env[TombstoneSecret] == "" {
+		return nil, fmt.Errorf("'%s' is required by the %s user store", TombstoneSecret, env[UserStore])
+	}
+
+	return env, nil
+}
+
+// hasDriver reports whether the database/sql driver name is registered.
+func hasDriver(name string) bool {
+	for _, d := range sql.Drivers() {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+func lookup(env Environment, keys ...string) error {
+	for _, key := range keys {
 		v, ok := os.LookupEnv(key)
 		if !ok {
-			return nil, fmt.Errorf("can't find '%s' in environment", key)
+			return fmt.Errorf("can't find '%s' in environment", key)
 		}
 		env[key] = v
 	}
-	return env, nil
+	return nil
 }
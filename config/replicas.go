@@ -0,0 +1,87 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// Roles a Firestore replica can take. Exactly one replica is primary; it
+// takes every write first and is read from first.
+const (
+	ReplicaPrimary   = "primary"
+	ReplicaSecondary = "secondary"
+)
+
+// replicaName is what a replica name may contain. The name ends up in the
+// path of the replica's credentials file, so it can't be allowed to leave
+// the working directory.
+var replicaName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// Replica describes one Firestore project holding a copy of the users.
+type Replica struct {
+	Name        string `json:"name"`
+	Credentials string `json:"credentials"`
+	ProjectID   string `json:"project_id"`
+	Role        string `json:"role"`
+	Collection  string `json:"collection"`
+}
+
+// CredentialsFile is where the replica's service account is written before
+// the Firestore client is created.
+func (r Replica) CredentialsFile() string {
+	return fmt.Sprintf("service-account-%s.json", r.Name)
+}
+
+// Replicas returns the Firestore replicas configured in env. FirestoreReplicas
+// holds them as a JSON list; without it the legacy ServiceAccount1 and
+// ServiceAccount2 pair is used as primary and secondary.
+func Replicas(env Environment) ([]Replica, error) {
+	var replicas []Replica
+	if raw := env[FirestoreReplicas]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &replicas); err != nil {
+			return nil, fmt.Errorf("invalid '%s': %w", FirestoreReplicas, err)
+		}
+	} else {
+		replicas = []Replica{
+			{Name: "client1", Credentials: env[ServiceAccount1], Role: ReplicaPrimary},
+			{Name: "client2", Credentials: env[ServiceAccount2], Role: ReplicaSecondary},
+		}
+	}
+
+	primaries := 0
+	names := make(map[string]bool)
+	for i := range replicas {
+		r := &replicas[i]
+		if r.Name == "" {
+			return nil, fmt.Errorf("replica %d has no name", i)
+		}
+		if !replicaName.MatchString(r.Name) {
+			return nil, fmt.Errorf("replica name '%s' may only contain lowercase letters, digits and dashes", r.Name)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("replica name '%s' is used more than once", r.Name)
+		}
+		names[r.Name] = true
+
+		if r.Credentials == "" {
+			return nil, fmt.Errorf("replica '%s' has no credentials", r.Name)
+		}
+		if r.Collection == "" {
+			r.Collection = "users"
+		}
+
+		switch r.Role {
+		case ReplicaPrimary:
+			primaries++
+		case ReplicaSecondary:
+		default:
+			return nil, fmt.Errorf("replica '%s' has unknown role '%s'", r.Name, r.Role)
+		}
+	}
+
+	if primaries != 1 {
+		return nil, fmt.Errorf("expected exactly one primary replica, found %d", primaries)
+	}
+	return replicas, nil
+}
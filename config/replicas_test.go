@@ -0,0 +1,39 @@
+package config
+
+import "testing"
+
+func TestReplicas(t *testing.T) {
+	legacy := Environment{ServiceAccount1: "{}", ServiceAccount2: "{}"}
+	replicas, err := Replicas(legacy)
+	if err != nil {
+		t.Fatalf("unexpected error for legacy service accounts: %v", err)
+	}
+	if len(replicas) != 2 || replicas[0].Role != ReplicaPrimary || replicas[1].Collection != "users" {
+		t.Errorf("unexpected legacy replicas %+v", replicas)
+	}
+
+	testCases := []struct {
+		raw   string
+		valid bool
+	}{
+		{`[{"name":"a","credentials":"{}","role":"primary"},{"name":"b","credentials":"{}","role":"secondary"},{"name":"c","credentials":"{}","role":"secondary"}]`, true},
+		{`[{"name":"a","credentials":"{}","role":"primary"}]`, true},
+		{`[{"name":"a","credentials":"{}","role":"secondary"}]`, false},
+		{`[{"name":"a","credentials":"{}","role":"primary"},{"name":"b","credentials":"{}","role":"primary"}]`, false},
+		{`[{"name":"a","credentials":"{}","role":"primary"},{"name":"a","credentials":"{}","role":"secondary"}]`, false},
+		{`[{"name":"a","role":"primary"}]`, false},
+		{`[{"name":"a","credentials":"{}","role":"leader"}]`, false},
+		{`[{"name":"eu-west-1","credentials":"{}","role":"primary"}]`, true},
+		{`[{"name":"../x","credentials":"{}","role":"primary"}]`, false},
+		{`[{"name":"a/b","credentials":"{}","role":"primary"}]`, false},
+		{`[{"name":"Primary","credentials":"{}","role":"primary"}]`, false},
+		{`not json`, false},
+	}
+
+	for _, tc := range testCases {
+		_, err := Replicas(Environment{FirestoreReplicas: tc.raw})
+		if (err == nil) != tc.valid {
+			t.Errorf("Replicas(%s) returned error %v, want valid=%t", tc.raw, err, tc.valid)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+	"github.com/thealamu/linkedinsignin/repository"
+	"github.com/thealamu/linkedinsignin/requests"
+)
+
+type CohortController struct {
+	logger zerolog.Logger
+}
+
+func NewCohortController(logger zerolog.Logger) *CohortController {
+	return &CohortController{logger}
+}
+
+func (cc *CohortController) HandleError(c echo.Context, err error, code int) error {
+	return handleError(cc.logger, c, err, code)
+}
+
+func (cc *CohortController) SaveCohort(cohortSaver repository.CohortSaver) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		var requestBody requests.SaveCohortRequest
+		if err := json.NewDecoder(c.Request().Body).Decode(&requestBody); err != nil {
+			return cc.HandleError(c, errors.New("Invalid JSON Request Body", 400), http.StatusBadRequest)
+		}
+
+		if strings.TrimSpace(requestBody.ID) == "" {
+			return cc.HandleError(c, errors.New("Missing Fields! Cohort ID is required", 400), http.StatusBadRequest)
+		}
+		if strings.Contains(requestBody.ID, "/") {
+			return cc.HandleError(c, errors.New("Invalid Cohort ID", 400), http.StatusBadRequest)
+		}
+		if strings.TrimSpace(requestBody.Name) == "" {
+			return cc.HandleError(c, errors.New("Missing Fields! Cohort Name is required", 400), http.StatusBadRequest)
+		}
+		if requestBody.StartDate.IsZero() {
+			return cc.HandleError(c, errors.New("Missing Fields! Start Date is required", 400), http.StatusBadRequest)
+		}
+		if requestBody.EnrollmentOpen.IsZero() || requestBody.EnrollmentClose.IsZero() {
+			return cc.HandleError(c, errors.New("Missing Fields! Enrollment Open and Close are required", 400), http.StatusBadRequest)
+		}
+		if !requestBody.EnrollmentOpen.Before(requestBody.EnrollmentClose) {
+			return cc.HandleError(c, errors.New("Enrollment Must Open Before it Closes", 400), http.StatusBadRequest)
+		}
+		if requestBody.Capacity < 0 {
+			return cc.HandleError(c, errors.New("Invalid Capacity", 400), http.StatusBadRequest)
+		}
+		offered := model.Cohort{Tracks: requestBody.Tracks}
+		for track, capacity := range requestBody.TrackCapacity {
+			if capacity < 0 {
+				return cc.HandleError(c, errors.New("Invalid Capacity for Track "+track, 400), http.StatusBadRequest)
+			}
+			if !offered.OffersTrack(track) {
+				return cc.HandleError(c, errors.New("Capacity Given for Track "+track+" Which is Not Offered", 400), http.StatusBadRequest)
+			}
+		}
+
+		cohort, err := cohortSaver.SaveCohort(ctx, model.Cohort{
+			ID:              requestBody.ID,
+			Name:            requestBody.Name,
+			StartDate:       requestBody.StartDate.UTC(),
+			EnrollmentOpen:  requestBody.EnrollmentOpen.UTC(),
+			EnrollmentClose: requestBody.EnrollmentClose.UTC(),
+			Capacity:        requestBody.Capacity,
+			Tracks:          requestBody.Tracks,
+			TrackCapacity:   requestBody.TrackCapacity,
+		})
+		if err != nil {
+			return cc.HandleError(c, err, errors.CodeFrom(err))
+		}
+
+		return HandleSuccess(c, cohort, http.StatusOK)
+	}
+}
+
+func (cc *CohortController) ListCohorts(cohortLister repository.CohortLister) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cohorts, err := cohortLister.ListCohorts(c.Request().Context())
+		if err != nil {
+			return cc.HandleError(c, err, errors.CodeFrom(err))
+		}
+
+		return HandleSuccess(c, cohorts, http.StatusOK)
+	}
+}
+
+func (cc *CohortController) GetCohort(cohortGetter repository.CohortGetter) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cohort, err := cohortGetter.GetCohort(c.Request().Context(), c.Param("id"))
+		if err != nil {
+			return cc.HandleError(c, err, errors.CodeFrom(err))
+		}
+
+		return HandleSuccess(c, cohort, http.StatusOK)
+	}
+}
+
+// ReconcileSeats recounts the seats taken in the cohort from its enrolled
+// users and sends the result.
+func (cc *CohortController) ReconcileSeats(seats repository.CohortSeatCounter) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		taken, err := seats.ReconcileSeats(c.Request().Context(), c.Param("id"))
+		if err != nil {
+			return cc.HandleError(c, err, errors.CodeFrom(err))
+		}
+
+		cc.logger.Info().Msgf("reconciled seats of cohort %s: %d taken", c.Param("id"), taken.Total)
+		return HandleSuccess(c, taken, http.StatusOK)
+	}
+}
+
+// GetSeats sends the seats taken in the cohort, overall and per track.
+func (cc *CohortController) GetSeats(seats repository.CohortSeatCounter) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		taken, err := seats.GetSeats(c.Request().Context(), c.Param("id"))
+		if err != nil {
+			return cc.HandleError(c, err, errors.CodeFrom(err))
+		}
+
+		return HandleSuccess(c, taken, http.StatusOK)
+	}
+}
@@ -3,11 +3,19 @@ package controllers
 import "github.com/rs/zerolog"
 
 type Container struct {
-	UserController *UserController
+	UserController    *UserController
+	CohortController  *CohortController
+	StatsController   *StatsController
+	OptionsController *OptionsController
+	PlacesController  *PlacesController
 }
 
 func NewContainer(logger zerolog.Logger) *Container {
 	return &Container{
-		UserController: NewUserController(logger),
+		UserController:    NewUserController(logger),
+		CohortController:  NewCohortController(logger),
+		StatsController:   NewStatsController(logger),
+		OptionsController: NewOptionsController(logger),
+		PlacesController:  NewPlacesController(logger),
 	}
 }
@@ -2,19 +2,37 @@ package controllers
 
 import (
 	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
 	"github.com/thealamu/linkedinsignin/errors"
 )
 
 func (u *UserController) HandleError(c echo.Context, err error, code int) error {
+	return handleError(u.logger, c, err, code)
+}
+
+func handleError(logger zerolog.Logger, c echo.Context, err error, code int) error {
+	code, body := errorBody(logger, err, code)
+	return c.JSON(code, body)
+}
+
+// handlePartialError reports err like handleError, along with the work done
+// before it as the payload.
+func handlePartialError(logger zerolog.Logger, c echo.Context, err error, code int, done interface{}) error {
+	code, body := errorBody(logger, err, code)
+	body["payload"] = done
+	return c.JSON(code, body)
+}
+
+func errorBody(logger zerolog.Logger, err error, code int) (int, map[string]interface{}) {
 	if code < 100 {
 		code = 500
 	}
 
 	if code >= 500 {
-		u.logger.Err(err).Msg("internal error")
-		return c.JSON(code, map[string]interface{}{
+		logger.Err(err).Msg("internal error")
+		return code, map[string]interface{}{
 			"error": "Internal Server Error. Something Bad Happened!",
-		})
+		}
 	}
 
 	msg := err.Error()
@@ -23,9 +41,13 @@ func (u *UserController) HandleError(c echo.Context, err error, code int) error
 		msg = zErr.Message()
 	}
 
-	return c.JSON(code, map[string]interface{}{
+	body := map[string]interface{}{
 		"error": msg,
-	})
+	}
+	if ok && len(zErr.Fields) > 0 {
+		body["fields"] = zErr.Fields
+	}
+	return code, body
 }
 
 func HandleSuccess(c echo.Context, data interface{}, code int) error {
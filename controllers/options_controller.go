@@ -0,0 +1,35 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+
+	"github.com/thealamu/linkedinsignin/options"
+	"github.com/thealamu/linkedinsignin/requests"
+)
+
+type OptionsController struct {
+	logger zerolog.Logger
+}
+
+func NewOptionsController(logger zerolog.Logger) *OptionsController {
+	return &OptionsController{logger}
+}
+
+// GetOptions sends the option catalog for the frontend to render the
+// enrollment form with.
+func (oc *OptionsController) GetOptions(catalog *options.Catalog) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return HandleSuccess(c, catalog, http.StatusOK)
+	}
+}
+
+// GetForm sends the questions of the enrollment form, with the choices of
+// the option catalog filled in.
+func (oc *OptionsController) GetForm(enrollment *requests.Form) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return HandleSuccess(c, enrollment.Schema(), http.StatusOK)
+	}
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/places"
+)
+
+const (
+	defaultCompletions = 10
+	maxCompletions     = 50
+)
+
+type PlacesController struct {
+	logger zerolog.Logger
+}
+
+func NewPlacesController(logger zerolog.Logger) *PlacesController {
+	return &PlacesController{logger}
+}
+
+func (pc *PlacesController) HandleError(c echo.Context, err error, code int) error {
+	return handleError(pc.logger, c, err, code)
+}
+
+// GetStates sends the states and territories, with their major cities.
+func (pc *PlacesController) GetStates(gazetteer *places.Gazetteer) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return HandleSuccess(c, gazetteer.States, http.StatusOK)
+	}
+}
+
+// CompleteCities sends the cities starting with the q param, in the state
+// param when it is given, for the frontend to autocomplete with. When none
+// of the cities of the state does, it sends the closest ones instead. These
+// are the cities the form suggests for a near miss; towns with no close
+// match are accepted as given.
+func (pc *PlacesController) CompleteCities(gazetteer *places.Gazetteer) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		limit := defaultCompletions
+		if v := c.QueryParam("limit"); v != "" {
+			var err error
+			limit, err = strconv.Atoi(v)
+			if err != nil || limit < 1 {
+				return pc.HandleError(c, errors.New("Invalid Limit", 400), http.StatusBadRequest)
+			}
+			if limit > maxCompletions {
+				limit = maxCompletions
+			}
+		}
+
+		state := c.QueryParam("state")
+		if state != "" {
+			s, ok := gazetteer.State(state)
+			if !ok {
+				return pc.HandleError(c, errors.New("Invalid State", 400), http.StatusBadRequest)
+			}
+			state = s.Code
+		}
+
+		q := c.QueryParam("q")
+		completions := gazetteer.Complete(q, state, limit)
+		if len(completions) == 0 && state != "" {
+			for _, city := range gazetteer.SuggestCities(state, q) {
+				if len(completions) == limit {
+					break
+				}
+				completions = append(completions, places.Place{City: city, State: state})
+			}
+		}
+		return HandleSuccess(c, completions, http.StatusOK)
+	}
+}
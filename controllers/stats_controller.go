@@ -0,0 +1,35 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/stats"
+)
+
+type StatsController struct {
+	logger zerolog.Logger
+}
+
+func NewStatsController(logger zerolog.Logger) *StatsController {
+	return &StatsController{logger}
+}
+
+func (sc *StatsController) HandleError(c echo.Context, err error, code int) error {
+	return handleError(sc.logger, c, err, code)
+}
+
+// GetStats sends the enrollment stats, which may be as old as the cache TTL.
+func (sc *StatsController) GetStats(service stats.Service) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		report, err := service.Report(c.Request().Context())
+		if err != nil {
+			return sc.HandleError(c, err, errors.CodeFrom(err))
+		}
+
+		return HandleSuccess(c, report, http.StatusOK)
+	}
+}
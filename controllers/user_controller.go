@@ -1,17 +1,24 @@
 package controllers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
-	"unicode"
 
 	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog"
 
+	"github.com/thealamu/linkedinsignin/csvimport"
 	"github.com/thealamu/linkedinsignin/email"
 	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/export"
 	"github.com/thealamu/linkedinsignin/linkedin"
 	"github.com/thealamu/linkedinsignin/model"
 	"github.com/thealamu/linkedinsignin/repository"
@@ -81,7 +88,6 @@ func (u *UserController) CreateUser(userCreator repository.UserCreator, service
 			LinkedInURL: profile.ProfileURL,
 			Phone:       profile.Phone,
 			Photo:       profile.Photo,
-			CreatedAt:   time.Now().UTC().String(),
 		}
 
 		user, err := userCreator.CreateUser(ctx, data)
@@ -93,15 +99,32 @@ func (u *UserController) CreateUser(userCreator repository.UserCreator, service
 	}
 }
 
-func (u *UserController) UpdateUser(userGetter repository.UserGetter, userUpdater repository.UserUpdater, emailer email.Emailer) echo.HandlerFunc {
+// Enrollment is what enrolling users takes, bundled so the routes that do it
+// don't have to list every repository.
+type Enrollment struct {
+	Users interface {
+		repository.UserGetter
+		repository.UserLister
+		repository.UserUpdater
+		repository.EmailRecorder
+	}
+	Cohorts interface {
+		repository.CohortGetter
+		repository.CohortSeatCounter
+	}
+	Emailer email.Emailer
+	Form    *requests.Form
+}
+
+// UpdateUser enrolls the user into the open cohort, or puts them on its
+// waitlist when their track or the cohort is full.
+func (u *UserController) UpdateUser(e Enrollment) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		ctx := c.Request().Context()
 
 		// dump request headers
 		u.logger.Debug().Msgf("Request Headers: %+v", c.Request().Header)
 
-		var requestBody requests.UpdateUserRequest
-
 		// dump request body
 		// var body bytes.Buffer
 		// _, err := io.Copy(&body, c.Request().Body)
@@ -112,163 +135,293 @@ func (u *UserController) UpdateUser(userGetter repository.UserGetter, userUpdate
 		// cp := body.Bytes()
 		// u.logger.Debug().Msgf("Request Body: %s", cp)
 
-		err := json.NewDecoder(c.Request().Body).Decode(&requestBody)
+		answers, err := e.Form.Decode(c.Request().Body)
 		if err != nil {
-			return u.HandleError(c, err, http.StatusBadRequest)
+			return u.HandleError(c, err, errors.CodeFrom(err))
 		}
 
-		update, err := userGetter.GetUser(ctx, c.Param("email"))
+		update, err := e.Users.GetUser(ctx, c.Param("email"))
 		if err != nil {
 			return u.HandleError(c, err, errors.CodeFrom(err))
 		}
 		if update.Enrolled {
 			return u.HandleError(c, errors.New("User Already Enrolled", 400), http.StatusBadRequest)
 		}
+		if update.Waitlisted {
+			return u.HandleError(c, errors.New("User Already on the Waitlist", 400), http.StatusBadRequest)
+		}
+		before := *update
 
-		{
-			// if requestBody.Timezone != "" {
-			// 	update.Timezone = requestBody.Timezone
-			// }
+		if err := e.Form.Apply(answers, update); err != nil {
+			return u.HandleError(c, err, errors.CodeFrom(err))
+		}
 
-			if requestBody.LinkedInURL == "" {
-				return u.HandleError(c, errors.New("Missing Fields! LinkedIn URL is required", 400), http.StatusBadRequest)
-			}
-			match, err := isValidLinkedIn(requestBody.LinkedInURL)
-			if err != nil {
-				return u.HandleError(c, err, errors.CodeFrom(err))
-			}
-			if !match {
-				return u.HandleError(c, errors.New("Invalid LinkedIn URL", 400), http.StatusBadRequest)
-			}
-			update.LinkedInURL = requestBody.LinkedInURL
+		cohort, err := e.Cohorts.OpenCohort(ctx, time.Now().UTC())
+		if errors.CodeFrom(err) == 404 {
+			return u.HandleError(c, errors.New("Enrollment is Currently Closed", 400), http.StatusBadRequest)
+		}
+		if err != nil {
+			return u.HandleError(c, err, errors.CodeFrom(err))
+		}
+		if !cohort.OffersTrack(update.LearningTrack) {
+			return u.HandleError(c, errors.New("Learning Track is Not Offered in This Cohort", 400), http.StatusBadRequest)
+		}
 
-			if requestBody.Phone == "" {
-				return u.HandleError(c, errors.New("Missing Fields! Phone Number is required", 400), http.StatusBadRequest)
-			}
-			update.Phone = requestBody.Phone
+		// flag the applicant in the same write when the profile is known to
+		// be shared already
+		sharing, err := applicantsSharingProfile(ctx, e.Users, *update)
+		if err != nil {
+			return u.HandleError(c, err, errors.CodeFrom(err))
+		}
+		if len(sharing) > 0 {
+			update.LinkedInShared = true
+		}
 
-			if requestBody.Representation == "" {
-				return u.HandleError(c, errors.New("Missing Fields! Representation is required", 400), http.StatusBadRequest)
-			}
-			update.Representation = requestBody.Representation
+		seat, err := e.Cohorts.ReserveSeat(ctx, *cohort, update.LearningTrack)
+		if err != nil {
+			return u.HandleError(c, err, errors.CodeFrom(err))
+		}
 
-			if requestBody.Gender == "" {
-				return u.HandleError(c, errors.New("Missing Fields! Gender is required", 400), http.StatusBadRequest)
+		// update still carries the revision it was read at, so of two
+		// concurrent submissions only one enrolls and the other gets a 409
+		update.Enrolled = seat
+		update.Waitlisted = !seat
+		update.CohortID = cohort.ID
+		user, err := e.Users.UpdateUser(ctx, *update, u.edit(c, update.Email, before))
+		if err != nil {
+			if seat {
+				u.releaseSeat(ctx, e.Cohorts, cohort.ID, update.LearningTrack)
 			}
-			update.Gender = requestBody.Gender
+			return u.HandleError(c, err, errors.CodeFrom(err))
+		}
 
-			if requestBody.AgeGroup == "" {
-				return u.HandleError(c, errors.New("Missing Fields! Age Group is required", 400), http.StatusBadRequest)
-			}
-			update.AgeGroup = requestBody.AgeGroup
+		user = u.flagSharedProfile(c, e.Users, user)
+		u.sendEnrollmentEmail(ctx, e.Users, e.Emailer, user, cohort)
 
-			if requestBody.EmploymentStatus == "" {
-				return u.HandleError(c, errors.New("Missing Fields! Employment Status is required", 400), http.StatusBadRequest)
-			}
-			update.EmploymentStatus = requestBody.EmploymentStatus
+		return HandleSuccess(c, user, http.StatusOK)
+	}
+}
 
-			if requestBody.HighestSchool == "" {
-				return u.HandleError(c, errors.New("Missing Fields! Please choose Highest Education", 400), http.StatusBadRequest)
-			}
-			update.HighestSchool = requestBody.HighestSchool
+// PromoteWaitlist enrolls users from the waitlist of the cohort, in the
+// order they joined it, for as long as their tracks have seats left. Only
+// the users promoted are returned, also when promoting fails part way.
+func (u *UserController) PromoteWaitlist(e Enrollment) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		cohort, err := e.Cohorts.GetCohort(ctx, c.Param("id"))
+		if err != nil {
+			return u.HandleError(c, err, errors.CodeFrom(err))
+		}
+		taken, err := e.Cohorts.GetSeats(ctx, cohort.ID)
+		if err != nil {
+			return u.HandleError(c, err, errors.CodeFrom(err))
+		}
+
+		waitlist, err := listWaitlist(ctx, e.Users, *cohort, *taken)
+		if err != nil {
+			return u.HandleError(c, err, errors.CodeFrom(err))
+		}
 
-			if requestBody.CanWorkInUSA == "" {
-				return u.HandleError(c, errors.New("Missing Fields! Please choose if you can work in USA", 400), http.StatusBadRequest)
+		promoted := []model.User{}
+		for _, waiting := range waitlist {
+			seat, err := e.Cohorts.ReserveSeat(ctx, *cohort, waiting.LearningTrack)
+			if err != nil {
+				u.logger.Warn().Msgf("promoted %d waitlisted users in cohort %s before failing", len(promoted), cohort.ID)
+				return handlePartialError(u.logger, c, err, errors.CodeFrom(err), promoted)
 			}
-			if strings.ToUpper(requestBody.CanWorkInUSA) != "YES" {
-				return u.HandleError(c, errors.New("It is Required that You can Work in the USA", 400), http.StatusBadRequest)
+			if !seat {
+				continue
 			}
-			update.CanWorkInUSA = requestBody.CanWorkInUSA
 
-			if requestBody.LearningTrack == "" {
-				return u.HandleError(c, errors.New("Missing Fields! Please choose a Learning Track", 400), http.StatusBadRequest)
+			update := waiting
+			update.Waitlisted = false
+			update.Enrolled = true
+			user, err := e.Users.UpdateUser(ctx, update, u.edit(c, "admin", waiting))
+			if err != nil {
+				// most likely erased or changed since it was listed, the
+				// seat goes to whoever is next
+				u.logger.Err(err).Msgf("failed to promote %s", waiting.Email)
+				u.releaseSeat(ctx, e.Cohorts, cohort.ID, waiting.LearningTrack)
+				continue
 			}
-			update.LearningTrack = requestBody.LearningTrack
 
-			if requestBody.HoursPerWeek == "" {
-				return u.HandleError(c, errors.New("Missing Fields! Please choose Hours available Per Week", 400), http.StatusBadRequest)
-			}
-			update.HoursPerWeek = requestBody.HoursPerWeek
+			u.sendEnrollmentEmail(ctx, e.Users, e.Emailer, user, cohort)
+			promoted = append(promoted, *user)
+		}
 
-			if requestBody.Referral == "" {
-				return u.HandleError(c, errors.New("Missing Fields! Please choose your Referral", 400), http.StatusBadRequest)
-			}
-			update.Referral = requestBody.Referral
+		u.logger.Info().Msgf("promoted %d of %d waitlisted users with a seat left in cohort %s", len(promoted), len(waitlist), cohort.ID)
+		return HandleSuccess(c, promoted, http.StatusOK)
+	}
+}
 
-			if requestBody.Photo == "" || requestBody.Photo == "null" {
-				return u.HandleError(c, errors.New("Missing Fields! Please upload a picture", 400), http.StatusBadRequest)
-			}
-			update.Photo = requestBody.Photo
+// waitlistPageSize is how many waitlisted users are read at a time.
+const waitlistPageSize = 100
+
+// listWaitlist returns the users waiting for a seat in the cohort who can
+// still get one of the seats left once taken are taken, first come first.
+// Only as many users as there are seats left on each track are kept while
+// the waitlist is read, so it never has to be held in full.
+func listWaitlist(ctx context.Context, userLister repository.UserLister, cohort model.Cohort, taken model.Seats) ([]model.User, error) {
+	waitlisted := true
+	filter := repository.UserFilter{CohortID: cohort.ID, Waitlisted: &waitlisted}
+
+	tracks := make(map[string][]model.User)
+	cursor := ""
+	for {
+		page, err := userLister.ListUsers(ctx, filter, cursor, waitlistPageSize)
+		if err != nil {
+			return nil, err
+		}
 
-			if requestBody.City == "" {
-				return u.HandleError(c, errors.New("Missing Fields! Please set a City", 400), http.StatusBadRequest)
+		touched := make(map[string]bool)
+		for _, user := range page.Users {
+			if cohort.SeatsLeft(user.LearningTrack, taken) != 0 {
+				tracks[user.LearningTrack] = append(tracks[user.LearningTrack], user)
+				touched[user.LearningTrack] = true
 			}
-			if hasNumbers(requestBody.City) {
-				return u.HandleError(c, errors.New("Invalid City", 400), http.StatusBadRequest)
+		}
+		for track := range touched {
+			users := tracks[track]
+			sortWaitlist(users)
+			if left := cohort.SeatsLeft(track, taken); left > 0 && len(users) > left {
+				tracks[track] = users[:left]
 			}
-			update.City = requestBody.City
+		}
 
-			if requestBody.State == "" {
-				return u.HandleError(c, errors.New("Missing Fields! Please set a State", 400), http.StatusBadRequest)
-			}
-			update.State = requestBody.State
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
 
-			if requestBody.ProfessionalExperience == "" {
-				return u.HandleError(c, errors.New("Missing Fields! Please choose a Professional Experience", 400), http.StatusBadRequest)
-			}
-			update.ProfessionalExperience = requestBody.ProfessionalExperience
-
-			//industries := strings.Split(requestBody.Industries, ",")
-			//if len(industries) < 1 {
-			//	return u.HandleError(c, errors.New("Missing Fields! Please add at least one Industry", 400), http.StatusBadRequest)
-			//}
-			//for _, industry := range industries {
-			//	if !isAlpha(industry) {
-			//		return u.HandleError(c, errors.New("One of the Industries is invalid", 400), http.StatusBadRequest)
-			//	}
-			//}
-			if err := validateIndustries(requestBody.Industries); err != nil {
-				return u.HandleError(c, err, http.StatusBadRequest)
-			}
-			//industriesStr := strings.Join(industries, ",")
-			update.Industries = requestBody.Industries
+	var users []model.User
+	for _, waiting := range tracks {
+		users = append(users, waiting...)
+	}
+	sortWaitlist(users)
+	return users, nil
+}
 
-			//if requestBody.RacialDemographic == "" {
-			//	return u.HandleError(c, errors.New("Missing Fields! Please choose a Racial Demographic", 400), http.StatusBadRequest)
-			//}
-			//update.RacialDemographic = requestBody.RacialDemographic
+// sortWaitlist orders users by when they joined the waitlist. Users who
+// joined before that was recorded go last, by when they signed in.
+func sortWaitlist(users []model.User) {
+	sort.Slice(users, func(i, j int) bool {
+		a, b := users[i], users[j]
+		if (a.WaitlistedAt == nil) != (b.WaitlistedAt == nil) {
+			return b.WaitlistedAt == nil
+		}
+		if a.WaitlistedAt != nil && !a.WaitlistedAt.Equal(*b.WaitlistedAt) {
+			return a.WaitlistedAt.Before(*b.WaitlistedAt)
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.EmailKey < b.EmailKey
+	})
+}
 
-			if requestBody.PriorKnowledge == "" {
-				return u.HandleError(c, errors.New("Missing Fields! Please choose Prior Knowledge level", 400), http.StatusBadRequest)
-			}
-			update.PriorKnowledge = requestBody.PriorKnowledge
+// applicantsSharingProfile lists the other users who applied, enrolled or
+// waitlisted, with the LinkedIn profile of user.
+func applicantsSharingProfile(ctx context.Context, userLister repository.UserLister, user model.User) ([]model.User, error) {
+	slug, err := linkedin.ParseProfileURL(user.LinkedInURL)
+	if err != nil {
+		return nil, nil
+	}
 
-			if requestBody.ReferralOther != "" {
-				// referralOther is optional
-				update.ReferralOther = requestBody.ReferralOther
+	var sharing []model.User
+	cursor := ""
+	for {
+		page, err := userLister.ListUsers(ctx, repository.UserFilter{LinkedInSlug: slug}, cursor, 100)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range page.Users {
+			if other.EmailKey != user.EmailKey && (other.Enrolled || other.Waitlisted) {
+				sharing = append(sharing, other)
 			}
+		}
+		if page.NextCursor == "" {
+			return sharing, nil
+		}
+		cursor = page.NextCursor
+	}
+}
 
-			if requestBody.OptionalMajor == "" {
-				return u.HandleError(c, errors.New("Missing Fields! Please add a Field of Study", 400), http.StatusBadRequest)
-			}
-			if hasNumbers(requestBody.OptionalMajor) {
-				return u.HandleError(c, errors.New("Invalid Field of Study", 400), http.StatusBadRequest)
-			}
-			update.OptionalMajor = requestBody.OptionalMajor
+// flagSharedProfile flags user, once stored, and the other applicants with
+// their LinkedIn profile. It looks for them again rather than trusting the
+// look before the write, since two applicants with one profile may each
+// have looked before the other was stored. The user is returned as it ends
+// up. The flags are for an admin to look into, so failures are only logged.
+func (u *UserController) flagSharedProfile(c echo.Context, users interface {
+	repository.UserLister
+	repository.UserUpdater
+}, user *model.User) *model.User {
+	ctx := c.Request().Context()
+	sharing, err := applicantsSharingProfile(ctx, users, *user)
+	if err != nil {
+		u.logger.Err(err).Msgf("failed to look for the LinkedIn profile of %s", user.Email)
+		return user
+	}
 
+	if len(sharing) > 0 && !user.LinkedInShared {
+		update := *user
+		update.LinkedInShared = true
+		flagged, err := users.UpdateUser(ctx, update, u.edit(c, c.Param("email"), *user))
+		if err != nil {
+			u.logger.Err(err).Msgf("failed to flag the shared LinkedIn profile of %s", user.Email)
+		} else {
+			user = flagged
 		}
+	}
 
-		update.Enrolled = true
-		user, err := userUpdater.UpdateUser(ctx, *update)
-		if err != nil {
-			return u.HandleError(c, err, errors.CodeFrom(err))
+	for _, other := range sharing {
+		if other.LinkedInShared {
+			continue
 		}
+		u.logger.Warn().Msgf("%s applied with the LinkedIn profile of %s", c.Param("email"), other.Email)
 
-		if err := emailer.Welcome(ctx, user); err != nil {
-			u.logger.Error().Err(err).Msg("failed to send welcome email")
+		update := other
+		update.LinkedInShared = true
+		if _, err := users.UpdateUser(ctx, update, u.edit(c, c.Param("email"), other)); err != nil {
+			u.logger.Err(err).Msgf("failed to flag the shared LinkedIn profile of %s", other.Email)
 		}
+	}
+	return user
+}
 
-		return HandleSuccess(c, user, http.StatusOK)
+// releaseSeat gives back a seat that ended up not being used. Failing to do
+// so only costs a seat until the seats of the cohort are reconciled, so it
+// is logged rather than reported.
+func (u *UserController) releaseSeat(ctx context.Context, seats repository.CohortSeatCounter, cohortID, track string) {
+	if err := seats.ReleaseSeat(ctx, cohortID, track); err != nil {
+		u.logger.Err(err).Msgf("failed to release seat on %s in cohort %s", track, cohortID)
+	}
+}
+
+// sendEnrollmentEmail sends the welcome email to an enrolled user, or the
+// waitlisted one, and records it. Failures are recorded too but not
+// reported, the enrollment itself has gone through.
+func (u *UserController) sendEnrollmentEmail(ctx context.Context, emailRecorder repository.EmailRecorder, emailer email.Emailer, user *model.User, cohort *model.Cohort) {
+	record := model.EmailRecord{
+		Template: email.WelcomeTemplate,
+		Subject:  email.WelcomeSubject,
+		Status:   model.EmailSent,
+		SentAt:   time.Now().UTC(),
+	}
+	send := emailer.Welcome
+	if !user.Enrolled {
+		record.Template, record.Subject = email.WaitlistedTemplate, email.WaitlistedSubject
+		send = emailer.Waitlisted
+	}
+
+	if err := send(ctx, user, cohort); err != nil {
+		u.logger.Error().Err(err).Msgf("failed to send %s email", record.Template)
+		record.Status = model.EmailFailed
+		record.Error = err.Error()
+	}
+	if err := emailRecorder.RecordEmail(ctx, user.Email, record); err != nil {
+		u.logger.Err(err).Msgf("failed to record %s email", record.Template)
 	}
 }
 
@@ -290,97 +443,299 @@ func (u *UserController) GetUser(userGetter repository.UserGetter) echo.HandlerF
 	}
 }
 
-func (u *UserController) splitNames(name string) (string, string) {
-	names := strings.Split(name, " ")
-	if len(names) == 1 {
-		return names[0], ""
+// DeleteUser erases the user on request of the data subject and frees
+// their seat. Only the tombstone left behind is returned.
+func (u *UserController) DeleteUser(userGetter repository.UserGetter, userDeleter repository.UserDeleter, seats repository.CohortSeatCounter) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		userEmail := c.Param("email")
+		if userEmail == "" {
+			return u.HandleError(c, errors.New(" Email is required", 400), http.StatusBadRequest)
+		}
+
+		// an erasure that failed part way is retried even though the user
+		// may already be gone, the deleter answers 404 if there never was one
+		user, err := userGetter.GetUser(ctx, userEmail)
+		if err != nil && errors.CodeFrom(err) != 404 {
+			return u.HandleError(c, err, errors.CodeFrom(err))
+		}
+
+		tombstone, err := userDeleter.DeleteUser(ctx, userEmail)
+		if err != nil {
+			return u.HandleError(c, err, errors.CodeFrom(err))
+		}
+
+		if user != nil && user.Enrolled && user.CohortID != "" {
+			u.releaseSeat(ctx, seats, user.CohortID, user.LearningTrack)
+		}
+
+		u.logger.Info().Msgf("erased user with email hash %s", tombstone.EmailHash)
+		return HandleSuccess(c, tombstone, http.StatusOK)
 	}
-	return names[0], names[len(names)-1]
 }
 
-func isValidLinkedIn(url string) (bool, error) {
-	validRoot1 := "https://www.linkedin.com/in/"
-	validRoot2 := "https://linkedin.com/in/"
-	validRoot3 := "https://www.linkedin.com/mwlite/in/"
-	validRoot4 := "https://linkedin.com/mwlite/in/"
+func (u *UserController) GetUserHistory(historyGetter repository.UserHistoryGetter) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 
-	var (
-		hasRoot   bool
-		afterRoot string
-	)
+		userEmail := c.Param("email")
+		if userEmail == "" {
+			return u.HandleError(c, errors.New(" Email is required", 400), http.StatusBadRequest)
+		}
 
-	if strings.HasPrefix(url, validRoot1) {
-		hasRoot = true
-		afterRoot = url[len(validRoot1):]
-	} else if strings.HasPrefix(url, validRoot2) {
-		hasRoot = true
-		afterRoot = url[len(validRoot2):]
-	} else if strings.HasPrefix(url, validRoot3) {
-		hasRoot = true
-		afterRoot = url[len(validRoot3):]
-	} else if strings.HasPrefix(url, validRoot4) {
-		hasRoot = true
-		afterRoot = url[len(validRoot4):]
-	}
+		history, err := historyGetter.GetUserHistory(ctx, userEmail)
+		if err != nil {
+			return u.HandleError(c, err, errors.CodeFrom(err))
+		}
 
-	if !hasRoot {
-		return false, nil //errors.New("Invalid LinkedIn URL", 400)
+		return HandleSuccess(c, history, http.StatusOK)
 	}
+}
 
-	if strings.TrimSpace(afterRoot) == "" {
-		return false, nil //errors.New("Invalid LinkedIn URL", 400)
-	}
+// ExportUser sends everything held about the user as a downloadable JSON,
+// or HTML when format=html, bundle.
+func (u *UserController) ExportUser(sources export.SubjectSources) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 
-	return true, nil
-}
+		userEmail := c.Param("email")
+		if userEmail == "" {
+			return u.HandleError(c, errors.New(" Email is required", 400), http.StatusBadRequest)
+		}
 
-func isAlphaNum(s string) bool {
-	for _, r := range s {
-		if !unicode.IsLetter(r) && !unicode.IsNumber(r) {
-			return false
+		format := c.QueryParam("format")
+		if format == "" {
+			format = "json"
+		}
+		if format != "json" && format != "html" {
+			return u.HandleError(c, errors.New("Format must be json or html", 400), http.StatusBadRequest)
 		}
+
+		bundle, err := export.NewSubjectBundle(ctx, sources, userEmail)
+		if err != nil {
+			return u.HandleError(c, err, errors.CodeFrom(err))
+		}
+
+		var buf bytes.Buffer
+		contentType := echo.MIMEApplicationJSONCharsetUTF8
+		if format == "html" {
+			contentType = echo.MIMETextHTMLCharsetUTF8
+			err = bundle.WriteHTML(&buf)
+		} else {
+			err = bundle.WriteJSON(&buf)
+		}
+		if err != nil {
+			return u.HandleError(c, err, http.StatusInternalServerError)
+		}
+
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="user-data.%s"`, format))
+		return c.Blob(http.StatusOK, contentType, buf.Bytes())
 	}
-	return true
 }
 
-func isAlpha(s string) bool {
-	for _, r := range s {
-		if !unicode.IsLetter(r) {
-			return false
+// ExportUsers streams every user matching the ListUsers filters as a
+// spreadsheet, CSV by default or XLSX when format=xlsx.
+func (u *UserController) ExportUsers(userLister repository.UserLister) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		filter, err := parseUserFilter(c)
+		if err != nil {
+			return u.HandleError(c, err, http.StatusBadRequest)
+		}
+
+		format := c.QueryParam("format")
+		if format == "" {
+			format = export.FormatCSV
+		}
+		if format != export.FormatCSV && format != export.FormatXLSX {
+			return u.HandleError(c, errors.New("Format must be csv or xlsx", 400), http.StatusBadRequest)
+		}
+
+		// read the first page up front, so a failing store still gets a
+		// proper error rather than a truncated file
+		if _, err := userLister.ListUsers(ctx, filter, "", 1); err != nil {
+			return u.HandleError(c, err, errors.CodeFrom(err))
+		}
+
+		// the export streams for as long as the store takes to page through
+		// the users, which can outlast the server's write timeout
+		if err := http.NewResponseController(c.Response().Writer).SetWriteDeadline(time.Time{}); err != nil {
+			u.logger.Warn().Err(err).Msg("user export is bound by the write timeout")
+		}
+
+		res := c.Response()
+		res.Header().Set(echo.HeaderContentType, export.ContentType(format))
+		res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="users-%s.%s"`, time.Now().UTC().Format("2006-01-02"), format))
+		res.WriteHeader(http.StatusOK)
+
+		sheet, err := export.NewSheet(format, res)
+		if err == nil {
+			var n int
+			n, err = export.WriteUsers(ctx, userLister, filter, sheet)
+			if closeErr := sheet.Close(); err == nil {
+				err = closeErr
+			}
+			u.logger.Info().Msgf("exported %d users as %s", n, format)
 		}
+		if err != nil {
+			// the status is already out, all that is left is to cut the
+			// download short
+			u.logger.Error().Err(err).Msg("user export failed")
+			return err
+		}
+		return nil
 	}
-	return true
 }
 
-func hasNumbers(s string) bool {
-	for _, r := range s {
-		if unicode.IsNumber(r) {
-			return true
+func (u *UserController) ListUsers(userLister repository.UserLister, maxPageSize int) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		filter, err := parseUserFilter(c)
+		if err != nil {
+			return u.HandleError(c, err, http.StatusBadRequest)
+		}
+
+		limit := maxPageSize
+		if v := c.QueryParam("limit"); v != "" {
+			limit, err = strconv.Atoi(v)
+			if err != nil || limit < 1 {
+				return u.HandleError(c, errors.New("Invalid Limit", 400), http.StatusBadRequest)
+			}
+			if limit > maxPageSize {
+				limit = maxPageSize
+			}
 		}
+
+		page, err := userLister.ListUsers(ctx, filter, c.QueryParam("cursor"), limit)
+		if err != nil {
+			return u.HandleError(c, err, errors.CodeFrom(err))
+		}
+
+		return HandleSuccess(c, page, http.StatusOK)
 	}
-	return false
 }
 
-func isNum(s string) bool {
-	for _, r := range s {
-		if !unicode.IsNumber(r) {
-			return false
+// maxImportSize caps the size of an uploaded import file.
+const maxImportSize = 10 << 20
+
+// ImportUsers creates users from a CSV sent either as the "file" field of a
+// multipart form or as the request body. With dry_run=true nothing is
+// created.
+func (u *UserController) ImportUsers(users csvimport.Users, keys model.EmailPolicy, enrollment *requests.Form) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		dryRun := false
+		if v := c.QueryParam("dry_run"); v != "" {
+			var err error
+			if dryRun, err = strconv.ParseBool(v); err != nil {
+				return u.HandleError(c, errors.New("Invalid dry_run", 400), http.StatusBadRequest)
+			}
+		}
+
+		body := io.Reader(http.MaxBytesReader(c.Response(), c.Request().Body, maxImportSize))
+		if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+			fh, err := c.FormFile("file")
+			if err != nil {
+				return u.HandleError(c, errors.From(err, "Missing File", 400), http.StatusBadRequest)
+			}
+			if fh.Size > maxImportSize {
+				return u.HandleError(c, errors.New("File is Too Large", 400), http.StatusBadRequest)
+			}
+			f, err := fh.Open()
+			if err != nil {
+				return u.HandleError(c, err, http.StatusBadRequest)
+			}
+			defer f.Close()
+			body = f
 		}
+
+		report, err := csvimport.Import(ctx, users, body, csvimport.Options{
+			DryRun:    dryRun,
+			BatchSize: csvimport.DefaultBatchSize,
+			Keys:      keys,
+			Form:      enrollment,
+		})
+		if err != nil {
+			return u.HandleError(c, err, errors.CodeFrom(err))
+		}
+
+		u.logger.Info().Msgf("imported %d of %d users, dry run %t", len(report.Created), report.Rows, dryRun)
+		return HandleSuccess(c, report, http.StatusOK)
 	}
-	return true
 }
 
-func validateIndustries(s string) error {
-	industries := strings.Split(s, ",")
-	if len(industries) < 1 {
-		return errors.New("Missing Fields! Please add at least one Industry", 400)
+func parseUserFilter(c echo.Context) (repository.UserFilter, error) {
+	filter := repository.UserFilter{
+		CohortID:      c.QueryParam("cohort_id"),
+		LinkedInSlug:  c.QueryParam("linkedin_slug"),
+		LearningTrack: c.QueryParam("learning_track"),
+		State:         c.QueryParam("state"),
+		Referral:      c.QueryParam("referral"),
+	}
+
+	if v := c.QueryParam("enrolled"); v != "" {
+		enrolled, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, errors.New("Invalid Enrolled Filter", 400)
+		}
+		filter.Enrolled = &enrolled
+	}
+	if v := c.QueryParam("waitlisted"); v != "" {
+		waitlisted, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, errors.New("Invalid Waitlisted Filter", 400)
+		}
+		filter.Waitlisted = &waitlisted
 	}
-	for _, industry := range industries {
-		industry = strings.TrimSpace(industry)
-		if hasNumbers(industry) {
-			return errors.New("One of the Industries is invalid", 400)
+	if v := c.QueryParam("linkedin_shared"); v != "" {
+		shared, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, errors.New("Invalid LinkedIn Shared Filter", 400)
 		}
+		filter.LinkedInShared = &shared
+	}
+
+	var err error
+	if filter.CreatedAfter, err = parseDate(c.QueryParam("created_after")); err != nil {
+		return filter, errors.New("Invalid created_after Date", 400)
+	}
+	if filter.CreatedBefore, err = parseDate(c.QueryParam("created_before")); err != nil {
+		return filter, errors.New("Invalid created_before Date", 400)
+	}
+
+	return filter, nil
+}
+
+// parseDate accepts either a full RFC 3339 timestamp or a plain date. An
+// empty string gives the zero time.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// edit attributes an update of the user read as before to actor, through
+// the current request, so it is added to the user's history.
+func (u *UserController) edit(c echo.Context, actor string, before model.User) *repository.UserEdit {
+	return &repository.UserEdit{
+		Before: before,
+		Actor:  actor,
+		Source: c.Request().Method + " " + c.Path(),
 	}
+}
 
-	return nil
+func (u *UserController) splitNames(name string) (string, string) {
+	names := strings.Split(name, " ")
+	if len(names) == 1 {
+		return names[0], ""
+	}
+	return names[0], names[len(names)-1]
 }
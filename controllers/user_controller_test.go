@@ -1,48 +1,57 @@
 package controllers
 
-import "testing"
-
-func TestLinkedinURL(t *testing.T) {
-	testCases := []struct {
-		url   string
-		match bool
-	}{
-		{"https://www.linkedin.com/in/james-bond-007/", true},
-		{"https://linkedin.com/in/marllos-p-a383641b2/", true},
-		{"https://", false},
-		{"https://www.linkedin.com/in/", false},
-		{"https://www.linkedin.com/mwlite/in/techypally", true},
-	}
+import (
+	"context"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
 
-	for _, tc := range testCases {
-		got, err := isValidLinkedIn(tc.url)
-		if err != nil {
-			t.Errorf("isValidLinkedIn(%s) returned unexpected error: %v", tc.url, err)
-		}
-		if got != tc.match {
-			t.Errorf("isValidLinkedIn(%s) = %t, want %t", tc.url, got, tc.match)
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+	"github.com/thealamu/linkedinsignin/model"
+	"github.com/thealamu/linkedinsignin/repository"
+)
+
+// slowLister takes delay over every page.
+type slowLister struct {
+	repository.UserLister
+	delay time.Duration
+}
+
+func (s slowLister) ListUsers(ctx context.Context, filter repository.UserFilter, cursor string, limit int) (*repository.UserPage, error) {
+	time.Sleep(s.delay)
+	return s.UserLister.ListUsers(ctx, filter, cursor, limit)
+}
+
+func TestExportUsersOutlastsWriteTimeout(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{})
+	for _, email := range []string{"jane@example.com", "john@example.com"} {
+		if _, err := users.CreateUser(ctx, model.User{Email: email}); err != nil {
+			t.Fatal(err)
 		}
 	}
-}
 
-func TestIndustries(t *testing.T) {
-	testCases := []struct {
-		industries string
-		match      bool
-	}{
-		{",", false},
-		{"", false},
-		{"industry, industry,industry,industry, industry", true},
-		{",,,", false},
-		{",,industry", false},
-		{"industry,industry,industry", true},
-		{"industry", true},
+	e := echo.New()
+	e.GET("/export", NewUserController(zerolog.Nop()).ExportUsers(slowLister{users, 100 * time.Millisecond}))
+	srv := httptest.NewUnstartedServer(e)
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/export")
+	if err != nil {
+		t.Fatalf("unexpected error requesting the export: %v", err)
 	}
+	defer res.Body.Close()
 
-	for _, tc := range testCases {
-		err := validateIndustries(tc.industries)
-		if err != nil {
-			t.Errorf("validateIndustries(%s) returned unexpected error: %v", tc.industries, err)
-		}
+	rows, err := csv.NewReader(res.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("expected the whole file, got %v", err)
+	}
+	if len(rows) != 3 {
+		t.Errorf("expected a header and two users, got %d rows", len(rows))
 	}
 }
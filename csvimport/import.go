@@ -0,0 +1,265 @@
+package csvimport
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/mail"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+	"github.com/thealamu/linkedinsignin/repository"
+	"github.com/thealamu/linkedinsignin/requests"
+)
+
+// DefaultBatchSize is the number of users written at a time unless told
+// otherwise.
+const DefaultBatchSize = 50
+
+type (
+	// Users is what an import writes to.
+	Users interface {
+		repository.UserImporter
+	}
+
+	// batchLimiter is implemented by stores that import only so many users
+	// at once.
+	batchLimiter interface {
+		MaxImportBatch() int
+	}
+
+	Options struct {
+		// DryRun validates every row and checks for existing users, but
+		// creates nobody.
+		DryRun bool
+		// BatchSize rows are written at once, one batch after the other. It
+		// is lowered to what the store takes at once.
+		BatchSize int
+		// Keys tells rows for the same applicant apart from the rest.
+		Keys model.EmailPolicy
+		// Form validates every row as an enrollment form. It is required.
+		Form *requests.Form
+	}
+
+	// RowError is a row that was not imported. Line is where the row starts
+	// in the file, the header being line 1.
+	RowError struct {
+		Line  int    `json:"line"`
+		Email string `json:"email,omitempty"`
+		Error string `json:"error"`
+	}
+
+	// Report lists the outcome of every row. On a dry run Created holds the
+	// users that would have been created.
+	Report struct {
+		DryRun   bool       `json:"dry_run"`
+		Rows     int        `json:"rows"`
+		Created  []string   `json:"created"`
+		Existing []string   `json:"existing"`
+		Errors   []RowError `json:"errors"`
+	}
+
+	row struct {
+		line int
+		user model.User
+	}
+)
+
+// notImported are string fields of model.User that are managed by the
+// application, not by applicants.
+var notImported = map[string]bool{
+	"cohort_id": true,
+}
+
+// Import creates a user for every valid row of the CSV in r. The header
+// names the model.User field of each column by its JSON name, in any case
+// and with spaces for underscores. Rows are validated like the enrollment
+// form; users that already exist are left as they are and users who were
+// erased are not brought back.
+func Import(ctx context.Context, users Users, r io.Reader, opts Options) (*Report, error) {
+	if opts.BatchSize <= 0 {
+		return nil, fmt.Errorf("batch size must be positive")
+	}
+	if opts.Form == nil {
+		return nil, fmt.Errorf("a form is required to validate rows")
+	}
+	if l, ok := users.(batchLimiter); ok && opts.BatchSize > l.MaxImportBatch() {
+		opts.BatchSize = l.MaxImportBatch()
+	}
+
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("The File is Empty", 400)
+	}
+	if err != nil {
+		return nil, errors.From(err, "Invalid CSV Header", 400)
+	}
+	fields, err := mapHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		DryRun:   opts.DryRun,
+		Created:  []string{},
+		Existing: []string{},
+		Errors:   []RowError{},
+	}
+
+	seen := make(map[string]int)
+	var batch []row
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if pe, ok := err.(*csv.ParseError); ok {
+			report.Rows++
+			report.Errors = append(report.Errors, RowError{Line: pe.StartLine, Error: pe.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return report, errors.From(err, "failed to read CSV", 400)
+		}
+
+		report.Rows++
+		line, _ := cr.FieldPos(0)
+
+		user, err := parseRow(fields, record, opts.Form)
+		if err != nil {
+			report.Errors = append(report.Errors, RowError{Line: line, Email: user.Email, Error: message(err)})
+			continue
+		}
+
+		key := opts.Keys.Key(user.Email)
+		if first, ok := seen[key]; ok {
+			report.Errors = append(report.Errors, RowError{Line: line, Email: user.Email, Error: fmt.Sprintf("Same Applicant as Line %d", first)})
+			continue
+		}
+		seen[key] = line
+
+		batch = append(batch, row{line: line, user: user})
+		if len(batch) == opts.BatchSize {
+			if err := writeBatch(ctx, users, batch, opts.DryRun, report); err != nil {
+				return report, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if err := writeBatch(ctx, users, batch, opts.DryRun, report); err != nil {
+		return report, err
+	}
+
+	sort.SliceStable(report.Errors, func(i, j int) bool { return report.Errors[i].Line < report.Errors[j].Line })
+	return report, nil
+}
+
+// mapHeader returns the index of the model.User field for every column.
+func mapHeader(header []string) ([]int, error) {
+	importable := importableFields()
+
+	fields := make([]int, len(header))
+	used := make(map[int]bool)
+	hasEmail := false
+	for i, h := range header {
+		name := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(h)), " ", "_")
+		field, ok := importable[name]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unknown Column '%s'", h), 400)
+		}
+		if used[field] {
+			return nil, errors.New(fmt.Sprintf("Column '%s' Appears Twice", h), 400)
+		}
+		used[field] = true
+		fields[i] = field
+		hasEmail = hasEmail || name == "email"
+	}
+
+	if !hasEmail {
+		return nil, errors.New("Missing Column 'email'", 400)
+	}
+	return fields, nil
+}
+
+// importableFields maps the JSON names of the string fields of model.User
+// to their index.
+func importableFields() map[string]int {
+	t := reflect.TypeOf(model.User{})
+	fields := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.Type.Kind() != reflect.String || name == "" || name == "-" || notImported[name] {
+			continue
+		}
+		fields[name] = i
+	}
+	return fields
+}
+
+// parseRow builds the user of record and validates it as an enrollment
+// form.
+func parseRow(fields []int, record []string, enrollment *requests.Form) (model.User, error) {
+	var user model.User
+	v := reflect.ValueOf(&user).Elem()
+	for i, value := range record {
+		v.Field(fields[i]).SetString(strings.TrimSpace(value))
+	}
+
+	if user.Email == "" {
+		return user, errors.New("Missing Fields! Email is required", 400)
+	}
+	if _, err := mail.ParseAddress(user.Email); err != nil {
+		return user, errors.New("Invalid Email", 400)
+	}
+
+	return user, enrollment.Apply(enrollment.Answers(&user), &user)
+}
+
+// writeBatch creates the users of batch in one write and adds their outcome
+// to report in file order.
+func writeBatch(ctx context.Context, users Users, batch []row, dryRun bool, report *Report) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	batchUsers := make([]model.User, len(batch))
+	for i, r := range batch {
+		batchUsers[i] = r.user
+	}
+	outcomes, err := users.ImportUsers(ctx, batchUsers, dryRun)
+	if err != nil {
+		return err
+	}
+
+	for i, r := range batch {
+		switch outcomes[i] {
+		case repository.UserImported:
+			report.Created = append(report.Created, r.user.Email)
+		case repository.UserExists:
+			report.Existing = append(report.Existing, r.user.Email)
+		case repository.UserErased:
+			report.Errors = append(report.Errors, RowError{Line: r.line, Email: r.user.Email, Error: "Applicant Was Erased"})
+		}
+	}
+	return nil
+}
+
+// message is the part of err that is fit to show to whoever sent the file.
+func message(err error) string {
+	if e, ok := err.(errors.Error); ok {
+		return e.Message()
+	}
+	return err.Error()
+}
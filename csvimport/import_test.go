@@ -0,0 +1,143 @@
+package csvimport
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/form"
+	"github.com/thealamu/linkedinsignin/model"
+	"github.com/thealamu/linkedinsignin/options"
+	"github.com/thealamu/linkedinsignin/repository"
+	"github.com/thealamu/linkedinsignin/requests"
+)
+
+const header = "Email,First Name,linkedin_url,phone,representation,gender,age_group,employment_status,highest_school," +
+	"optional_major,can_work_in_usa,learning_track,hours_per_week,referral,photo,city,state,professional_experience,industries,prior_knowledge\n"
+
+func applicant(email, phone string) string {
+	return email + ",Jane,https://www.linkedin.com/in/jane/," + phone + ",yes,female,25-34,employed,bachelors," +
+		"Physics,Yes,design,10,friend,https://example.com/jane.png,Austin,TX,3 years,\"software, retail\",some\n"
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{})
+	for _, email := range []string{"old@example.com", "erased@example.com"} {
+		if _, err := repo.CreateUser(ctx, model.User{Email: email}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.DeleteUser(ctx, "erased@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	csv := header +
+		applicant("jane@example.com", "5125550100") +
+		applicant("john@example.com", "") +
+		applicant("JANE@example.com", "5125550101") +
+		applicant("old@example.com", "5125550102") +
+		applicant("not an email", "5125550103") +
+		applicant("erased@example.com", "5125550104")
+
+	report, err := Import(ctx, repo, strings.NewReader(csv), Options{DryRun: true, BatchSize: 2, Form: testForm(t)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Rows != 6 || len(report.Created) != 1 || len(report.Existing) != 1 || len(report.Errors) != 4 {
+		t.Fatalf("unexpected dry run report %+v", report)
+	}
+	for i, line := range []int{3, 4, 6, 7} {
+		if report.Errors[i].Line != line {
+			t.Errorf("expected error %d on line %d, got %+v", i, line, report.Errors[i])
+		}
+	}
+	if _, err := repo.GetUser(ctx, "jane@example.com"); errors.CodeFrom(err) != 404 {
+		t.Fatalf("expected a dry run to create nobody, got %v", err)
+	}
+
+	if _, err := Import(ctx, repo, strings.NewReader(csv), Options{BatchSize: 2, Form: testForm(t)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	user, err := repo.GetUser(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("expected jane to be imported, got %v", err)
+	}
+	if user.FirstName != "Jane" || user.City != "Austin" || user.Enrolled {
+		t.Errorf("unexpected imported user %+v", user)
+	}
+	if _, err := repo.GetUser(ctx, "erased@example.com"); errors.CodeFrom(err) != 404 {
+		t.Errorf("expected the erased applicant to stay erased, got %v", err)
+	}
+}
+
+// limitedUsers imports at most max users at once.
+type limitedUsers struct {
+	*repository.MemoryUserRepository
+	max     int
+	largest int
+}
+
+func (l *limitedUsers) MaxImportBatch() int { return l.max }
+
+func (l *limitedUsers) ImportUsers(ctx context.Context, users []model.User, dryRun bool) ([]repository.UserImport, error) {
+	if len(users) > l.largest {
+		l.largest = len(users)
+	}
+	return l.MemoryUserRepository.ImportUsers(ctx, users, dryRun)
+}
+
+func TestImportBatchLimit(t *testing.T) {
+	users := &limitedUsers{MemoryUserRepository: repository.NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{}), max: 1}
+	csv := header + applicant("jane@example.com", "5125550100") + applicant("john@example.com", "5125550101")
+
+	report, err := Import(context.Background(), users, strings.NewReader(csv), Options{BatchSize: 50, Form: testForm(t)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(report.Created) != 2 || users.largest != 1 {
+		t.Errorf("expected two batches of one user, got %+v and a batch of %d", report, users.largest)
+	}
+}
+
+func TestImportHeader(t *testing.T) {
+	repo := repository.NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{})
+
+	for _, csv := range []string{"", "first_name\n", "email,shoe_size\n", "email,Email\n", "email,cohort_id\n"} {
+		if _, err := Import(context.Background(), repo, strings.NewReader(csv), Options{BatchSize: 1, Form: testForm(t)}); errors.CodeFrom(err) != 400 {
+			t.Errorf("expected 400 for header %q, got %v", csv, err)
+		}
+	}
+
+	if _, err := Import(context.Background(), repo, strings.NewReader(header), Options{BatchSize: 1}); err == nil {
+		t.Error("expected an import without a form to fail")
+	}
+}
+
+func testForm(t *testing.T) *requests.Form {
+	schema, err := form.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := options.Parse([]byte(`{"version": "1", "fields": {
+		"gender": [{"value": "Female"}],
+		"age_group": [{"value": "25-34"}],
+		"employment_status": [{"value": "Employed"}],
+		"highest_school": [{"value": "Bachelors"}],
+		"learning_track": [{"value": "Design"}],
+		"hours_per_week": [{"value": "10"}],
+		"referral": [{"value": "Friend"}],
+		"professional_experience": [{"value": "3 years"}],
+		"prior_knowledge": [{"value": "Some"}]
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := requests.NewForm(schema, catalog, repository.UpdatableUserFields())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
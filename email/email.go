@@ -18,10 +18,29 @@ import (
 	"github.com/thealamu/linkedinsignin/model"
 )
 
+// Names and subjects of the emails sent, as kept in model.EmailRecord.
+const (
+	WelcomeTemplate    = "welcome"
+	WelcomeSubject     = "Welcome to Reskill Americans"
+	WaitlistedTemplate = "waitlisted"
+	WaitlistedSubject  = "You're on the Reskill Americans waitlist"
+)
+
 type (
 	Emailer interface {
-		// Welcome sends a welcome email to the user
-		Welcome(ctx context.Context, user *model.User) error
+		// Welcome sends a welcome email to the user, who has just enrolled
+		// into cohort
+		Welcome(ctx context.Context, user *model.User, cohort *model.Cohort) error
+		// Waitlisted tells the user they are on the waitlist of cohort, as
+		// their track or the cohort is full
+		Waitlisted(ctx context.Context, user *model.User, cohort *model.Cohort) error
+	}
+
+	// welcomeData is what the welcome and waitlisted templates are rendered
+	// with
+	welcomeData struct {
+		*model.User
+		Cohort *model.Cohort
 	}
 
 	sesEmailer struct {
@@ -30,8 +49,9 @@ type (
 	}
 
 	mailchimp struct {
-		apiKey string
-		tmpl   *template.Template
+		apiKey     string
+		tmpl       *template.Template
+		waitlisted *template.Template
 	}
 )
 
@@ -44,18 +64,31 @@ func NewMailChimp(apiKey string, logger zerolog.Logger) (*mailchimp, error) {
 	if err != nil {
 		return nil, err
 	}
+	waitlisted, err := template.New("waitlisted").Parse(waitlistedHTML)
+	if err != nil {
+		return nil, err
+	}
 
 	return &mailchimp{
-		apiKey: apiKey,
-		tmpl:   tmpl,
+		apiKey:     apiKey,
+		tmpl:       tmpl,
+		waitlisted: waitlisted,
 	}, nil
 }
 
-func (m *mailchimp) Welcome(ctx context.Context, user *model.User) error {
+func (m *mailchimp) Welcome(ctx context.Context, user *model.User, cohort *model.Cohort) error {
+	return m.send(ctx, m.tmpl, WelcomeSubject, user, cohort)
+}
+
+func (m *mailchimp) Waitlisted(ctx context.Context, user *model.User, cohort *model.Cohort) error {
+	return m.send(ctx, m.waitlisted, WaitlistedSubject, user, cohort)
+}
+
+func (m *mailchimp) send(ctx context.Context, tmpl *template.Template, subject string, user *model.User, cohort *model.Cohort) error {
 	endpoint := "https://mandrillapp.com/api/1.0/messages/send"
 
 	var buf bytes.Buffer
-	if err := m.tmpl.Execute(&buf, user); err != nil {
+	if err := tmpl.Execute(&buf, welcomeData{User: user, Cohort: cohort}); err != nil {
 		return err
 	}
 
@@ -63,7 +96,7 @@ func (m *mailchimp) Welcome(ctx context.Context, user *model.User) error {
 		"key": m.apiKey,
 		"message": map[string]interface{}{
 			"html":       buf.String(),
-			"subject":    "Welcome to Reskill Americans",
+			"subject":    subject,
 			"from_email": "info@reskillamericans.org",
 			"to": []map[string]interface{}{
 				{
@@ -114,19 +147,42 @@ func NewSES(ctx context.Context, logger zerolog.Logger) (*sesEmailer, error) {
 	}, nil
 }
 
-func (s *sesEmailer) Welcome(ctx context.Context, user *model.User) error {
+func (s *sesEmailer) Welcome(ctx context.Context, user *model.User, cohort *model.Cohort) error {
 	s.logger.Info().Msgf("Sending welcome email to '%s'", user.Email)
+	return s.send(ctx, "basic-welcome", user, cohort)
+}
 
-	payload := fmt.Sprintf(`{"name": "%s"}`, user.Name)
+func (s *sesEmailer) Waitlisted(ctx context.Context, user *model.User, cohort *model.Cohort) error {
+	s.logger.Info().Msgf("Sending waitlisted email to '%s'", user.Email)
+	return s.send(ctx, "basic-waitlisted", user, cohort)
+}
+
+// sesTemplateData fills the placeholders of the SES enrollment templates.
+type sesTemplateData struct {
+	Name      string `json:"name"`
+	Cohort    string `json:"cohort"`
+	StartDate string `json:"start_date"`
+}
+
+func (s *sesEmailer) send(ctx context.Context, template string, user *model.User, cohort *model.Cohort) error {
+	data, err := json.Marshal(sesTemplateData{
+		Name:      user.Name,
+		Cohort:    cohort.Name,
+		StartDate: cohort.StartDate.Format("January 2, 2006"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode template data: %w", err)
+	}
+	payload := string(data)
 
 	dst := types.Destination{
 		ToAddresses: []string{user.Email},
 	}
 
-	_, err := s.client.SendTemplatedEmail(ctx, &ses.SendTemplatedEmailInput{
+	_, err = s.client.SendTemplatedEmail(ctx, &ses.SendTemplatedEmailInput{
 		Destination:  &dst,
 		Source:       aws.String(constants.DefaultSourceEmail),
-		Template:     aws.String("basic-welcome"),
+		Template:     aws.String(template),
 		TemplateData: &payload,
 	})
 	if err != nil {
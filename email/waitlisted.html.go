@@ -0,0 +1,28 @@
+package email
+
+const waitlistedHTML = `
+<!DOCTYPE html>
+<html>
+<head>
+  <meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <title></title>
+</head>
+<body style="margin: 0; padding: 0; background-color: #f4f4f4; font-family: 'Lato', Arial, sans-serif; color: #333333;">
+  <table width="100%" cellpadding="0" cellspacing="0" border="0">
+    <tr>
+      <td align="center" style="padding: 40px 10px;">
+        <table width="600" cellpadding="0" cellspacing="0" border="0" style="background-color: #ffffff;">
+          <tr>
+            <td style="padding: 40px 30px; font-size: 16px; line-height: 24px;">
+              <h1 style="font-size: 28px; font-weight: 400;">You're on the waitlist</h1>
+              <p>You are registered as {{ .Name }} at {{ .Email }}.<br><br>Thank you for completing our enrollment form with your information. All seats in {{ .Cohort.Name }}{{ if .LearningTrack }} on the {{ .LearningTrack }} track{{ end }} have been taken, so we have added you to the waitlist.<br><br><strong>Next Steps:</strong> Seats do free up before the program starts on {{ .Cohort.StartDate.Format "January 2, 2006" }}. When one does, applicants on the waitlist are enrolled in the order they applied, and you will receive a welcome email with everything you need to get started. There is nothing you need to do in the meantime.<br><br>If you have additional questions, please contact us by hitting reply on this email.<br><br>Regards,<br>The Reskill Americans Team</p>
+            </td>
+          </tr>
+        </table>
+      </td>
+    </tr>
+  </table>
+</body>
+</html>
+`
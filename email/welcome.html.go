@@ -235,7 +235,7 @@ const welcomeHTML = `
                             <tbody>
                               <tr>
                                 <td class="pc-fb-font" valign="top" style="font-family: 'Lato', Helvetica, Arial, sans-serif; padding: 10px 20px 0; line-height: 28px; font-size: 18px; font-weight: 300; letter-spacing: -0.2px; color: #483b3b">
-                                  <p>You are registered as {{ .Name }} at {{ .Email }}.<br><br>Thank you for completing our enrollment form with your information. On October 3, 2022 you will begin your exciting journey towards a career in software development! We look forward to introducing you to new skills that will help prepare you for a starting position in the tech world.<br><br><strong>Next Steps:</strong> Prior to October 3rd, you will receive login details for our program’s Learning Management System (LMS) and our online chatrooms. There is nothing you need to do before then to prepare (except to respond to any inquiries we might send!) In the meantime, please visit our FAQ page on our website<br>and read the key information below about our program to familiarize yourself.<br><br><strong>WHAT YOU NEED TO KNOW:<br><br></strong>What You Need: In addition to a passion to learn and determination to keep studying with us, you’ll need a laptop, tablet, or desktop PC with an internet connection. It is possible to do every part of this program using all online resources, which is why you only need a computer with a web browser. All the software used will be free, either online or installed on your computer. For more details about tech specs, see our FAQ page.<br><br><strong>Time Commitment:</strong> This is a seven-month, 100% online/remote learning experience. Our program is designed to fit into your schedule so that you can choose your own hours, pending the schedules of any peers with whom you might be working. You will need to dedicate at least 15 hours per week to be successful.<br><br><strong>How It Works:</strong> You will learn the fundamental concepts of software development and product design through a range of formats: recorded and live video sessions, training modules, and online chats or video conferences with your instructors, mentors, and peers.<br><br><strong>We Are Here to Help:</strong> Whatever your level of knowledge is about the industry, our instructors and mentors will meet you at that level and work with you to address your questions. While it is not possible to become an expert in just seven months (expertise takes years of experience), we will give you everything you need to get started and the resources required to grow.<br><br>If you have additional questions, please contact us by hitting reply on this email.<br><br>Welcome aboard!<br>Regards,<br>The Reskill Americans Team</p>
+                                  <p>You are registered as {{ .Name }} at {{ .Email }}.<br><br>Thank you for completing our enrollment form with your information. On {{ .Cohort.StartDate.Format "January 2, 2006" }} you will begin your exciting journey towards a career in software development! We look forward to introducing you to new skills that will help prepare you for a starting position in the tech world.<br><br><strong>Next Steps:</strong> Prior to {{ .Cohort.StartDate.Format "January 2" }}, you will receive login details for our program’s Learning Management System (LMS) and our online chatrooms. There is nothing you need to do before then to prepare (except to respond to any inquiries we might send!) In the meantime, please visit our FAQ page on our website<br>and read the key information below about our program to familiarize yourself.<br><br><strong>WHAT YOU NEED TO KNOW:<br><br></strong>What You Need: In addition to a passion to learn and determination to keep studying with us, you’ll need a laptop, tablet, or desktop PC with an internet connection. It is possible to do every part of this program using all online resources, which is why you only need a computer with a web browser. All the software used will be free, either online or installed on your computer. For more details about tech specs, see our FAQ page.<br><br><strong>Time Commitment:</strong> This is a seven-month, 100% online/remote learning experience. Our program is designed to fit into your schedule so that you can choose your own hours, pending the schedules of any peers with whom you might be working. You will need to dedicate at least 15 hours per week to be successful.<br><br><strong>How It Works:</strong> You will learn the fundamental concepts of software development and product design through a range of formats: recorded and live video sessions, training modules, and online chats or video conferences with your instructors, mentors, and peers.<br><br><strong>We Are Here to Help:</strong> Whatever your level of knowledge is about the industry, our instructors and mentors will meet you at that level and work with you to address your questions. While it is not possible to become an expert in just seven months (expertise takes years of experience), we will give you everything you need to get started and the resources required to grow.<br><br>If you have additional questions, please contact us by hitting reply on this email.<br><br>Welcome aboard!<br>Regards,<br>The Reskill Americans Team</p>
                                 </td>
                               </tr>
                               <tr>
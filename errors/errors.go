@@ -1,11 +1,26 @@
 package errors
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type Error struct {
 	Msg   string
 	Code  int
 	Cause error
+	// Fields lists the fields of an invalid request, see Invalid.
+	Fields []FieldError
+}
+
+// FieldError is a field of a request that failed validation. Code is meant
+// for programs, Message for people. Suggestions, when there are any, are
+// values the field may have been meant to have.
+type FieldError struct {
+	Field       string   `json:"field"`
+	Code        string   `json:"code"`
+	Message     string   `json:"message"`
+	Suggestions []string `json:"suggestions,omitempty"`
 }
 
 func (e Error) Message() string {
@@ -38,6 +53,20 @@ func From(err error, msg string, code int) Error {
 	}
 }
 
+// Invalid returns a 422 for a request whose fields failed validation. Its
+// message joins the messages of the fields.
+func Invalid(fields []FieldError) Error {
+	msgs := make([]string, len(fields))
+	for i, f := range fields {
+		msgs[i] = f.Message
+	}
+	return Error{
+		Msg:    strings.Join(msgs, "; "),
+		Code:   422,
+		Fields: fields,
+	}
+}
+
 func CodeFrom(err error) int {
 	code := 1
 	if v, ok := err.(Error); ok {
@@ -0,0 +1,113 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+	"github.com/thealamu/linkedinsignin/repository"
+)
+
+type (
+	// SubjectSources is everything a subject access bundle is assembled
+	// from.
+	SubjectSources interface {
+		repository.UserReplicaGetter
+		repository.UserHistoryGetter
+		repository.EmailRecordGetter
+	}
+
+	// SubjectBundle is a copy of all the data held about one applicant, as
+	// handed to them on a subject access request.
+	SubjectBundle struct {
+		Email       string              `json:"email"`
+		GeneratedAt time.Time           `json:"generated_at"`
+		Replicas    []model.ReplicaCopy `json:"replicas"`
+		History     []model.UserChange  `json:"history"`
+		Emails      []model.EmailRecord `json:"emails"`
+	}
+)
+
+var subjectTmpl = template.Must(template.New("subject").Funcs(template.FuncMap{
+	"fields": userFields,
+}).Parse(subjectHTML))
+
+// NewSubjectBundle collects the data held about email. It fails with a 404
+// when nothing at all is held.
+func NewSubjectBundle(ctx context.Context, sources SubjectSources, email string) (*SubjectBundle, error) {
+	replicas, err := sources.GetUserReplicas(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := sources.GetUserHistory(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	emails, err := sources.GetEmailRecords(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	found := len(history) > 0 || len(emails) > 0
+	for _, r := range replicas {
+		if r.User != nil {
+			found = true
+		}
+		if r.Error != "" {
+			// an incomplete bundle would wrongly tell the applicant
+			// that's all we hold
+			return nil, errors.New(fmt.Sprintf("Replica %s is Unavailable", r.Replica), 503)
+		}
+	}
+	if !found {
+		return nil, errors.New("User Account Not Found", 404)
+	}
+
+	return &SubjectBundle{
+		Email:       email,
+		GeneratedAt: time.Now().UTC(),
+		Replicas:    replicas,
+		History:     history,
+		Emails:      emails,
+	}, nil
+}
+
+func (b *SubjectBundle) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}
+
+// WriteHTML renders the bundle as a standalone page readable by the
+// applicant.
+func (b *SubjectBundle) WriteHTML(w io.Writer) error {
+	return subjectTmpl.Execute(w, b)
+}
+
+type field struct {
+	Name  string
+	Value interface{}
+}
+
+// userFields lists the fields of user by json name, in declaration order.
+func userFields(user *model.User) []field {
+	v := reflect.ValueOf(user).Elem()
+	fields := make([]field, 0, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fields = append(fields, field{Name: name, Value: v.Field(i).Interface()})
+	}
+	return fields
+}
@@ -0,0 +1,60 @@
+package export
+
+const subjectHTML = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>Your data held by Reskill Americans</title>
+  <style>
+    body { font-family: Arial, sans-serif; margin: 2em; color: #222; }
+    table { border-collapse: collapse; margin-bottom: 2em; }
+    th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+    th { background: #f4f4f4; }
+  </style>
+</head>
+<body>
+  <h1>Your data held by Reskill Americans</h1>
+  <p>This is a copy of everything we hold about <strong>{{ .Email }}</strong>, generated on {{ .GeneratedAt.Format "January 2, 2006 15:04 MST" }}.</p>
+
+  <h2>Profile</h2>
+  {{ range .Replicas }}
+  <h3>Copy held in {{ .Replica }}</h3>
+  {{ if .User }}
+  <table>
+    {{ range fields .User }}<tr><th>{{ .Name }}</th><td>{{ .Value }}</td></tr>
+    {{ end }}
+  </table>
+  {{ else }}
+  <p>No profile is held in this copy.</p>
+  {{ end }}
+  {{ end }}
+
+  <h2>Change history</h2>
+  {{ if .History }}
+  <table>
+    <tr><th>When</th><th>By</th><th>Through</th><th>Changes</th></tr>
+    {{ range .History }}<tr>
+      <td>{{ .Timestamp.Format "2006-01-02 15:04 MST" }}</td>
+      <td>{{ .Actor }}</td>
+      <td>{{ .Source }}</td>
+      <td>{{ range .Changes }}{{ .Path }}: "{{ .Before }}" &rarr; "{{ .After }}"<br>{{ end }}</td>
+    </tr>
+    {{ end }}
+  </table>
+  {{ else }}
+  <p>No changes have been recorded.</p>
+  {{ end }}
+
+  <h2>Emails sent to you</h2>
+  {{ if .Emails }}
+  <table>
+    <tr><th>When</th><th>Subject</th><th>Status</th></tr>
+    {{ range .Emails }}<tr><td>{{ .SentAt.Format "2006-01-02 15:04 MST" }}</td><td>{{ .Subject }}</td><td>{{ .Status }}</td></tr>
+    {{ end }}
+  </table>
+  {{ else }}
+  <p>No emails have been sent to you.</p>
+  {{ end }}
+</body>
+</html>
+`
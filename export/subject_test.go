@@ -0,0 +1,54 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+	"github.com/thealamu/linkedinsignin/repository"
+)
+
+func TestNewSubjectBundle(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{})
+
+	if _, err := NewSubjectBundle(ctx, repo, "nobody@example.com"); errors.CodeFrom(err) != 404 {
+		t.Fatalf("expected 404 for an unknown user, got %v", err)
+	}
+
+	if _, err := repo.CreateUser(ctx, model.User{Email: "ada@example.com", FirstName: "Ada"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RecordEmail(ctx, "ada@example.com", model.EmailRecord{
+		Template: "welcome",
+		Subject:  "Welcome",
+		Status:   model.EmailSent,
+		SentAt:   time.Now().UTC(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := NewSubjectBundle(ctx, repo, "ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Replicas) != 1 || bundle.Replicas[0].User == nil {
+		t.Fatalf("expected the user on one replica, got %+v", bundle.Replicas)
+	}
+	if len(bundle.Emails) != 1 {
+		t.Fatalf("expected one email record, got %d", len(bundle.Emails))
+	}
+
+	var html bytes.Buffer
+	if err := bundle.WriteHTML(&html); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html.String(), "Ada") {
+		t.Error("expected the HTML bundle to contain the user's fields")
+	}
+}
@@ -0,0 +1,159 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+	"github.com/thealamu/linkedinsignin/repository"
+)
+
+// Spreadsheet formats of a user export.
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// exportPageSize is how many users are read from the store at a time, which
+// bounds the memory an export takes however many users there are.
+const exportPageSize = 200
+
+// Sheet receives the rows of an export one at a time. Close must be called
+// once all rows are written.
+type Sheet interface {
+	WriteRow(cells []string) error
+	Close() error
+}
+
+// NewSheet returns a Sheet writing format to w.
+func NewSheet(format string, w io.Writer) (Sheet, error) {
+	switch format {
+	case FormatCSV:
+		return &csvSheet{w: csv.NewWriter(w)}, nil
+	case FormatXLSX:
+		return newXLSXSheet(w)
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown Format '%s'", format), 400)
+}
+
+// ContentType is the media type of format.
+func ContentType(format string) string {
+	if format == FormatXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// UserColumns are the columns of a user export: the JSON names of model.User
+// in declaration order, so they only change when the model does.
+func UserColumns() []string {
+	fields := userFields(&model.User{})
+	columns := make([]string, len(fields))
+	for i, f := range fields {
+		columns[i] = f.Name
+	}
+	return columns
+}
+
+// WriteUsers writes a header and then a row for every user matching filter,
+// oldest first, and returns how many users were written. The sheet is not
+// closed.
+func WriteUsers(ctx context.Context, users repository.UserLister, filter repository.UserFilter, sheet Sheet) (int, error) {
+	if err := sheet.WriteRow(UserColumns()); err != nil {
+		return 0, err
+	}
+
+	written := 0
+	cursor := ""
+	for {
+		page, err := users.ListUsers(ctx, filter, cursor, exportPageSize)
+		if err != nil {
+			return written, err
+		}
+
+		for i := range page.Users {
+			fields := userFields(&page.Users[i])
+			cells := make([]string, len(fields))
+			for j, f := range fields {
+				cells[j] = cellValue(f.Value)
+			}
+			if err := sheet.WriteRow(cells); err != nil {
+				return written, err
+			}
+			written++
+		}
+
+		if page.NextCursor == "" {
+			return written, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// cellValue formats a field of model.User for a spreadsheet. Times are
+// RFC 3339 in UTC, unset ones are left empty. Form answers are kept together
+// as JSON, their questions changing too often for columns of their own.
+func cellValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339)
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return cellValue(*v)
+	case model.Answers:
+		if len(v) == 0 {
+			return ""
+		}
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	return fmt.Sprint(v)
+}
+
+type csvSheet struct {
+	w *csv.Writer
+}
+
+func (s *csvSheet) WriteRow(cells []string) error {
+	escaped := make([]string, len(cells))
+	for i, c := range cells {
+		escaped[i] = escapeFormula(c)
+	}
+	return s.w.Write(escaped)
+}
+
+func (s *csvSheet) Close() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// escapeFormula keeps spreadsheet applications from running applicant input
+// as a formula when the CSV is opened, by prefixing it with a quote. Signed
+// numbers, such as phone numbers, are left alone.
+func escapeFormula(s string) string {
+	if s == "" {
+		return s
+	}
+	switch s[0] {
+	case '=', '@', '\t', '\r':
+		return "'" + s
+	case '+', '-':
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return "'" + s
+		}
+	}
+	return s
+}
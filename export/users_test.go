@@ -0,0 +1,135 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/thealamu/linkedinsignin/model"
+	"github.com/thealamu/linkedinsignin/repository"
+)
+
+func newExportRepo(t *testing.T, n int) *repository.MemoryUserRepository {
+	ctx := context.Background()
+	repo := repository.NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{})
+	for i := 0; i < n; i++ {
+		user := model.User{
+			Email:         strings.Repeat("a", i+1) + "@example.com",
+			FirstName:     "=HYPERLINK(\"x\")",
+			LearningTrack: "Frontend",
+			Enrolled:      i%2 == 0,
+		}
+		if _, err := repo.CreateUser(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func TestWriteUsersCSV(t *testing.T) {
+	// more users than a page, to go through the cursor
+	repo := newExportRepo(t, exportPageSize+3)
+
+	enrolled := true
+	var buf bytes.Buffer
+	sheet, _ := NewSheet(FormatCSV, &buf)
+	n, err := WriteUsers(context.Background(), repo, repository.UserFilter{Enrolled: &enrolled}, sheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sheet.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := (exportPageSize + 4) / 2
+	if n != want {
+		t.Fatalf("expected %d users, got %d", want, n)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != want+1 {
+		t.Fatalf("expected %d rows, got %d", want+1, len(records))
+	}
+
+	columns := UserColumns()
+	if strings.Join(records[0], ",") != strings.Join(columns, ",") {
+		t.Fatalf("unexpected header %v", records[0])
+	}
+	for _, c := range columns {
+		if c == "email_key" || c == "revision" {
+			t.Fatalf("column %s should not be exported", c)
+		}
+	}
+
+	row := make(map[string]string)
+	for i, c := range columns {
+		row[c] = records[1][i]
+	}
+	if row["email"] != "a@example.com" || row["enrolled"] != "true" || row["enrolled_at"] == "" {
+		t.Fatalf("unexpected first row %v", row)
+	}
+	if row["first_name"] != `'=HYPERLINK("x")` {
+		t.Fatalf("formula not escaped: %q", row["first_name"])
+	}
+}
+
+func TestWriteUsersXLSX(t *testing.T) {
+	repo := newExportRepo(t, 2)
+
+	var buf bytes.Buffer
+	sheet, _ := NewSheet(FormatXLSX, &buf)
+	if _, err := WriteUsers(context.Background(), repo, repository.UserFilter{}, sheet); err != nil {
+		t.Fatal(err)
+	}
+	if err := sheet.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		parts[f.Name] = string(b)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels"} {
+		if _, ok := parts[name]; !ok {
+			t.Fatalf("missing part %s", name)
+		}
+	}
+	data := parts["xl/worksheets/sheet1.xml"]
+	for _, want := range []string{
+		`<c r="A1" t="inlineStr"><is><t xml:space="preserve">email</t>`,
+		`<c r="A3" t="inlineStr"><is><t xml:space="preserve">aa@example.com</t>`,
+		`=HYPERLINK(&#34;x&#34;)`,
+		`</sheetData></worksheet>`,
+	} {
+		if !strings.Contains(data, want) {
+			t.Fatalf("sheet does not contain %s:\n%s", want, data)
+		}
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		if got := columnName(i); got != want {
+			t.Errorf("columnName(%d) = %s, want %s", i, got, want)
+		}
+	}
+}
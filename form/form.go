@@ -0,0 +1,144 @@
+package form
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/thealamu/linkedinsignin/options"
+)
+
+// defaultSchema is used when no schema file is configured.
+//
+//go:embed schema.json
+var defaultSchema []byte
+
+// AnswersPrefix marks the storage path of questions kept in model.User's
+// Answers rather than in a field of their own.
+const AnswersPrefix = "answers."
+
+// Question types. The server treats them all as strings; the type tells the
+// frontend which input to render.
+const (
+	TypeText     = "text"
+	TypeTextarea = "textarea"
+	TypeChoice   = "choice"
+	TypeURL      = "url"
+	TypePhone    = "phone"
+	TypePhoto    = "photo"
+)
+
+var types = map[string]bool{
+	TypeText:     true,
+	TypeTextarea: true,
+	TypeChoice:   true,
+	TypeURL:      true,
+	TypePhone:    true,
+	TypePhoto:    true,
+}
+
+type (
+	// Question is one entry of the enrollment form. Key is its name in the
+	// request, Store where the answer is kept: the firestore name of a
+	// model.User field, or AnswersPrefix followed by a name of its own.
+	//
+	// Options restrict the answer; choices that the option catalog lists
+	// for Key take their place. Accept narrows the answers further, for
+	// questions where only some of the choices qualify. Validate names extra
+	// checks known to the server, such as "linkedin".
+	Question struct {
+		Key             string           `json:"key"`
+		Label           string           `json:"label"`
+		Type            string           `json:"type"`
+		Required        bool             `json:"required"`
+		RequiredMessage string           `json:"required_message,omitempty"`
+		InvalidMessage  string           `json:"invalid_message,omitempty"`
+		Pattern         string           `json:"pattern,omitempty"`
+		Options         []options.Option `json:"options,omitempty"`
+		Accept          []string         `json:"accept,omitempty"`
+		Validate        []string         `json:"validate,omitempty"`
+		Store           string           `json:"store"`
+	}
+
+	// Schema lists the questions of the enrollment form in the order they
+	// are asked. Version changes whenever the questions do.
+	Schema struct {
+		Version   string     `json:"version"`
+		Questions []Question `json:"questions"`
+	}
+)
+
+// Load reads the schema at path, or the default one when path is empty.
+func Load(path string) (*Schema, error) {
+	data := defaultSchema
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read form schema: %w", err)
+		}
+	}
+	return Parse(data)
+}
+
+// Parse decodes a schema and checks that it is well formed. Whether its
+// storage paths and validators exist is up to whoever applies it.
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid form schema: %w", err)
+	}
+
+	if s.Version == "" {
+		return nil, fmt.Errorf("form schema has no version")
+	}
+	if len(s.Questions) == 0 {
+		return nil, fmt.Errorf("form schema has no questions")
+	}
+
+	keys := make(map[string]bool)
+	stores := make(map[string]bool)
+	for _, q := range s.Questions {
+		if q.Key == "" {
+			return nil, fmt.Errorf("form schema has a question without a key")
+		}
+		if keys[q.Key] {
+			return nil, fmt.Errorf("form schema asks '%s' twice", q.Key)
+		}
+		keys[q.Key] = true
+
+		if !types[q.Type] {
+			return nil, fmt.Errorf("question '%s' has unknown type '%s'", q.Key, q.Type)
+		}
+		if q.Store == "" || q.Store == AnswersPrefix {
+			return nil, fmt.Errorf("question '%s' has no storage path", q.Key)
+		}
+		if stores[q.Store] {
+			return nil, fmt.Errorf("question '%s' is stored in '%s' along with another question", q.Key, q.Store)
+		}
+		stores[q.Store] = true
+
+		if q.Pattern != "" {
+			if _, err := regexp.Compile(q.Pattern); err != nil {
+				return nil, fmt.Errorf("question '%s' has an invalid pattern: %w", q.Key, err)
+			}
+		}
+		for _, o := range q.Options {
+			if o.Value == "" {
+				return nil, fmt.Errorf("question '%s' has an option with an empty value", q.Key)
+			}
+		}
+	}
+	return &s, nil
+}
+
+// Answer reports whether the question is kept in model.User's Answers, and
+// under which name.
+func (q Question) Answer() (string, bool) {
+	if !strings.HasPrefix(q.Store, AnswersPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(q.Store, AnswersPrefix), true
+}
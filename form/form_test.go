@@ -0,0 +1,35 @@
+package form
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	for _, data := range []string{
+		`{`,
+		`{"questions": [{"key": "city", "type": "text", "store": "city"}]}`,
+		`{"version": "1", "questions": []}`,
+		`{"version": "1", "questions": [{"type": "text", "store": "city"}]}`,
+		`{"version": "1", "questions": [{"key": "city", "type": "map", "store": "city"}]}`,
+		`{"version": "1", "questions": [{"key": "city", "type": "text"}]}`,
+		`{"version": "1", "questions": [{"key": "city", "type": "text", "store": "answers."}]}`,
+		`{"version": "1", "questions": [{"key": "city", "type": "text", "store": "city", "pattern": "("}]}`,
+		`{"version": "1", "questions": [{"key": "city", "type": "text", "store": "city"}, {"key": "city", "type": "text", "store": "state"}]}`,
+		`{"version": "1", "questions": [{"key": "city", "type": "text", "store": "city"}, {"key": "town", "type": "text", "store": "city"}]}`,
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("expected schema %s to be refused", data)
+		}
+	}
+
+	s, err := Load("")
+	if err != nil {
+		t.Fatalf("unexpected error loading the default schema: %v", err)
+	}
+	for _, q := range s.Questions {
+		if _, ok := q.Answer(); ok {
+			t.Errorf("expected %s of the default schema to have a field of its own", q.Key)
+		}
+	}
+	if name, ok := (Question{Store: "answers.pronouns"}).Answer(); !ok || name != "pronouns" {
+		t.Errorf("unexpected answer name %q", name)
+	}
+}
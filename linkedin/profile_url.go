@@ -0,0 +1,111 @@
+package linkedin
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// LinkedIn allows vanity names of 3 to 100 letters, digits, dashes and
+// underscores.
+const (
+	minSlugLength = 3
+	maxSlugLength = 100
+)
+
+// ParseProfileURL extracts the vanity name of a member from the URL of
+// their profile, in lower case. The scheme may be left out or be http, the
+// host may be linkedin.com under www, a country code or the mobile m, and
+// the path may go through mwlite and carry more segments after the name.
+// Query strings and fragments are ignored.
+func ParseProfileURL(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "https" && scheme != "http" {
+		return "", fmt.Errorf("unsupported scheme '%s'", u.Scheme)
+	}
+	if !isLinkedInHost(u.Hostname()) || u.Port() != "" || u.User != nil {
+		return "", fmt.Errorf("'%s' is not a LinkedIn host", u.Host)
+	}
+
+	segments := strings.Split(strings.Trim(u.EscapedPath(), "/"), "/")
+	if strings.EqualFold(segments[0], "mwlite") {
+		segments = segments[1:]
+	}
+	if len(segments) < 2 || !strings.EqualFold(segments[0], "in") {
+		return "", fmt.Errorf("not the URL of a member profile")
+	}
+
+	slug, err := url.PathUnescape(segments[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid vanity name: %w", err)
+	}
+	slug = strings.ToLower(slug)
+	if err := checkSlug(slug); err != nil {
+		return "", err
+	}
+	return slug, nil
+}
+
+// ProfileURL is the canonical URL of the profile with vanity name slug.
+func ProfileURL(slug string) string {
+	return "https://www.linkedin.com/in/" + url.PathEscape(slug) + "/"
+}
+
+// CanonicalProfileURL returns the canonical form of a profile URL along with
+// its vanity name, or s and no name when it isn't the URL of a profile.
+func CanonicalProfileURL(s string) (canonical, slug string) {
+	slug, err := ParseProfileURL(s)
+	if err != nil {
+		return s, ""
+	}
+	return ProfileURL(slug), slug
+}
+
+func isLinkedInHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "linkedin.com" {
+		return true
+	}
+	sub := strings.TrimSuffix(host, ".linkedin.com")
+	if sub == host {
+		return false
+	}
+	// www, the mobile site and country sites such as uk
+	if sub == "www" || sub == "m" {
+		return true
+	}
+	return len(sub) == 2 && isLower(sub)
+}
+
+func isLower(s string) bool {
+	for _, r := range s {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func checkSlug(slug string) error {
+	n := utf8.RuneCountInString(slug)
+	if n < minSlugLength || n > maxSlugLength {
+		return fmt.Errorf("vanity name must be %d to %d characters long", minSlugLength, maxSlugLength)
+	}
+	for _, r := range slug {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return fmt.Errorf("unexpected '%c' in vanity name", r)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package linkedin
+
+import "testing"
+
+func TestLinkedinURL(t *testing.T) {
+	testCases := []struct {
+		url  string
+		slug string
+	}{
+		{"https://www.linkedin.com/in/james-bond-007/", "james-bond-007"},
+		{"https://linkedin.com/in/marllos-p-a383641b2/", "marllos-p-a383641b2"},
+		{"https://", ""},
+		{"https://www.linkedin.com/in/", ""},
+		{"https://www.linkedin.com/mwlite/in/techypally", "techypally"},
+		{"http://uk.linkedin.com/in/jane-doe", "jane-doe"},
+		{"HTTPS://WWW.LINKEDIN.COM/IN/Jane-Doe", "jane-doe"},
+		{"https://m.linkedin.com/in/jane-doe", "jane-doe"},
+		{"www.linkedin.com/in/jane-doe", "jane-doe"},
+		{"https://www.linkedin.com/in/jane-doe?originalSubdomain=uk", "jane-doe"},
+		{"https://www.linkedin.com/in/jane-doe/details/experience/#main", "jane-doe"},
+		{"https://www.linkedin.com/in/jane_doe", "jane_doe"},
+		{"https://www.linkedin.com/in/j%C3%BCrgen-m", "jürgen-m"},
+		{"https://www.linkedin.com/company/acme", ""},
+		{"https://www.linkedin.com/in/ab", ""},
+		{"https://www.linkedin.com/in/jane%20doe", ""},
+		{"https://notlinkedin.com/in/jane-doe", ""},
+		{"https://www.linkedin.com.evil.com/in/jane-doe", ""},
+		{"https://linkedin.com:8080/in/jane-doe", ""},
+		{"ftp://linkedin.com/in/jane-doe", ""},
+	}
+
+	for _, tc := range testCases {
+		slug, err := ParseProfileURL(tc.url)
+		if tc.slug == "" {
+			if err == nil {
+				t.Errorf("ParseProfileURL(%s) = %s, want an error", tc.url, slug)
+			}
+			continue
+		}
+		if err != nil || slug != tc.slug {
+			t.Errorf("ParseProfileURL(%s) = %s, %v, want %s", tc.url, slug, err, tc.slug)
+		}
+	}
+}
+
+func TestCanonicalProfileURL(t *testing.T) {
+	canonical, slug := CanonicalProfileURL("http://uk.linkedin.com/in/Jane-Doe?trk=x")
+	if canonical != "https://www.linkedin.com/in/jane-doe/" || slug != "jane-doe" {
+		t.Errorf("unexpected canonical form %s, %s", canonical, slug)
+	}
+	if canonical, slug := CanonicalProfileURL("https://www.linkedin.com/in/j%C3%BCrgen-m"); canonical != "https://www.linkedin.com/in/j%C3%BCrgen-m/" || slug != "jürgen-m" {
+		t.Errorf("unexpected canonical form %s, %s", canonical, slug)
+	}
+	if canonical, slug := CanonicalProfileURL("not a url"); canonical != "not a url" || slug != "" {
+		t.Errorf("expected an invalid URL to be kept, got %s, %s", canonical, slug)
+	}
+}
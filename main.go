@@ -5,13 +5,18 @@ import (
 	"os"
 
 	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
 	"github.com/rs/zerolog"
 	"github.com/thealamu/linkedinsignin/config"
 	"github.com/thealamu/linkedinsignin/controllers"
 	"github.com/thealamu/linkedinsignin/email"
+	"github.com/thealamu/linkedinsignin/form"
 	"github.com/thealamu/linkedinsignin/linkedin"
+	"github.com/thealamu/linkedinsignin/options"
 	"github.com/thealamu/linkedinsignin/repository"
+	"github.com/thealamu/linkedinsignin/requests"
 	"github.com/thealamu/linkedinsignin/server"
+	"github.com/thealamu/linkedinsignin/stats"
 )
 
 var defaultWriter = zerolog.ConsoleWriter{Out: os.Stdout}
@@ -29,10 +34,24 @@ func main() {
 		appLogger.Fatal().Err(err).Msg("Failed to load configs")
 	}
 
-	writeSAs(appLogger, env)
+	if env[config.UserStore] == config.UserStoreFirestore {
+		replicas, err := config.Replicas(env)
+		if err != nil {
+			appLogger.Fatal().Err(err).Msg("Failed to load replicas")
+		}
+		writeCredentials(appLogger, replicas)
+	}
+
+	if len(os.Args) > 1 {
+		if err := runCommand(appLogger, env, os.Args[1], os.Args[2:]); err != nil {
+			appLogger.Fatal().Err(err).Msgf("Command '%s' failed", os.Args[1])
+		}
+		return
+	}
 
 	cts := controllers.NewContainer(appLogger)
-	rc := repository.NewContainer(appLogger)
+	rc := repository.NewContainer(appLogger, env)
+	defer rc.Close()
 	service := linkedin.New(appLogger, env)
 
 	emailer, err := email.NewMailChimp(env[config.MailChimpAPIKey], appLogger)
@@ -40,30 +59,43 @@ func main() {
 		appLogger.Fatal().Err(err).Msg("Failed to create email service")
 	}
 
-	if err := server.Start(appLogger, env, cts, rc, service, emailer); err != nil {
+	statsService := stats.New(appLogger, env, rc.UserRepository)
+
+	catalog, enrollment, err := loadForm(env)
+	if err != nil {
+		appLogger.Fatal().Err(err).Msg("Failed to load enrollment form")
+	}
+	appLogger.Info().Msgf("Using option catalog version %s and form schema version %s", catalog.Version, enrollment.Schema().Version)
+
+	if err := server.Start(appLogger, env, cts, rc, service, emailer, statsService, catalog, enrollment); err != nil {
 		appLogger.Fatal().Err(err).Msg("Failed to start server")
 	}
 }
 
-func writeSAs(appLogger zerolog.Logger, env config.Environment) {
-	sa1, ok := env[config.ServiceAccount1]
-	if !ok {
-		appLogger.Fatal().Msg("Service account 1 not found")
+// writeCredentials writes the service account of every replica to the file
+// the repository reads it from.
+func writeCredentials(appLogger zerolog.Logger, replicas []config.Replica) {
+	for _, r := range replicas {
+		if err := os.WriteFile(r.CredentialsFile(), []byte(r.Credentials), 0644); err != nil {
+			appLogger.Fatal().Err(err).Msgf("Failed to write service account for replica %s", r.Name)
+		}
 	}
+}
 
-	sa2, ok := env[config.ServiceAccount2]
-	if !ok {
-		appLogger.Fatal().Msg("Service account 2 not found")
+// loadForm reads the option catalog and the schema of the enrollment form
+// and checks that they fit each other and the user model.
+func loadForm(env config.Environment) (*options.Catalog, *requests.Form, error) {
+	catalog, err := options.Load(env[config.OptionsFile])
+	if err != nil {
+		return nil, nil, err
 	}
-
-	sa1OutFile := "service-account-1.json"
-	sa2OutFile := "service-account-2.json"
-
-	if err := os.WriteFile(sa1OutFile, []byte(sa1), 0644); err != nil {
-		appLogger.Fatal().Err(err).Msg("Failed to write service account 1")
+	schema, err := form.Load(env[config.FormSchemaFile])
+	if err != nil {
+		return nil, nil, err
 	}
-
-	if err := os.WriteFile(sa2OutFile, []byte(sa2), 0644); err != nil {
-		appLogger.Fatal().Err(err).Msg("Failed to write service account 2")
+	enrollment, err := requests.NewForm(schema, catalog, repository.UpdatableUserFields())
+	if err != nil {
+		return nil, nil, err
 	}
+	return catalog, enrollment, nil
 }
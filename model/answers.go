@@ -0,0 +1,43 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Answers holds the enrollment form answers that have no field of their own
+// on User, by the name the form schema stores them under.
+type Answers map[string]string
+
+// Value stores the answers as JSON text, empty when there are none.
+func (a Answers) Value() (driver.Value, error) {
+	if len(a) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan reads answers stored by Value.
+func (a *Answers) Scan(src interface{}) error {
+	var data []byte
+	switch src := src.(type) {
+	case nil:
+	case string:
+		data = []byte(src)
+	case []byte:
+		data = src
+	default:
+		return fmt.Errorf("cannot scan %T into answers", src)
+	}
+
+	*a = nil
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, a)
+}
@@ -0,0 +1,78 @@
+package model
+
+import "time"
+
+// Cohort is one intake of the program. Applicants enrolling between
+// EnrollmentOpen and EnrollmentClose join the cohort and start on StartDate.
+type Cohort struct {
+	ID              string    `json:"id" firestore:"id"`
+	Name            string    `json:"name" firestore:"name"`
+	StartDate       time.Time `json:"start_date" firestore:"start_date"`
+	EnrollmentOpen  time.Time `json:"enrollment_open" firestore:"enrollment_open"`
+	EnrollmentClose time.Time `json:"enrollment_close" firestore:"enrollment_close"`
+	// Capacity is the number of seats in the cohort, 0 meaning unlimited.
+	Capacity int `json:"capacity" firestore:"capacity"`
+	// Tracks lists the learning tracks offered, empty meaning all of them.
+	Tracks []string `json:"tracks" firestore:"tracks"`
+	// TrackCapacity is the number of seats on each learning track. Tracks
+	// left out are only limited by Capacity.
+	TrackCapacity map[string]int `json:"track_capacity" firestore:"track_capacity"`
+}
+
+// Seats counts the seats taken in a cohort, overall and per learning track.
+type Seats struct {
+	Total  int            `json:"total" firestore:"total"`
+	Tracks map[string]int `json:"tracks" firestore:"tracks"`
+}
+
+// HasSeat reports whether a seat on track is left in c once taken are
+// taken.
+func (c Cohort) HasSeat(track string, taken Seats) bool {
+	if c.Capacity > 0 && taken.Total >= c.Capacity {
+		return false
+	}
+	if n := c.TrackCapacity[track]; n > 0 && taken.Tracks[track] >= n {
+		return false
+	}
+	return true
+}
+
+// SeatsLeft is how many seats on track are left in c once taken are taken,
+// or -1 when there is no limit.
+func (c Cohort) SeatsLeft(track string, taken Seats) int {
+	left := -1
+	if c.Capacity > 0 {
+		left = seatsLeft(c.Capacity, taken.Total)
+	}
+	if n := c.TrackCapacity[track]; n > 0 {
+		if t := seatsLeft(n, taken.Tracks[track]); left < 0 || t < left {
+			left = t
+		}
+	}
+	return left
+}
+
+func seatsLeft(capacity, taken int) int {
+	if taken >= capacity {
+		return 0
+	}
+	return capacity - taken
+}
+
+// IsOpen reports whether enrollment into c is open at t.
+func (c Cohort) IsOpen(t time.Time) bool {
+	return !t.Before(c.EnrollmentOpen) && t.Before(c.EnrollmentClose)
+}
+
+// OffersTrack reports whether track can be chosen in c.
+func (c Cohort) OffersTrack(track string) bool {
+	if len(c.Tracks) == 0 {
+		return true
+	}
+	for _, t := range c.Tracks {
+		if t == track {
+			return true
+		}
+	}
+	return false
+}
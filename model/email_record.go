@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// EmailRecord is kept for every email sent, or attempted, to a user.
+type EmailRecord struct {
+	Template string    `json:"template" firestore:"template"`
+	Subject  string    `json:"subject" firestore:"subject"`
+	Status   string    `json:"status" firestore:"status"`
+	Error    string    `json:"error,omitempty" firestore:"error"`
+	SentAt   time.Time `json:"sent_at" firestore:"sent_at"`
+}
+
+const (
+	EmailSent   = "sent"
+	EmailFailed = "failed"
+)
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+type (
+	// FieldChange is one field of a user document before and after a change.
+	// Path is the field's firestore tag.
+	FieldChange struct {
+		Path   string      `json:"path" firestore:"path"`
+		Before interface{} `json:"before" firestore:"before"`
+		After  interface{} `json:"after" firestore:"after"`
+	}
+
+	// UserChange is an entry in a user's history. Actor is who made the
+	// change and Source where it came from, e.g. the endpoint called.
+	UserChange struct {
+		Timestamp time.Time     `json:"timestamp" firestore:"timestamp"`
+		Actor     string        `json:"actor" firestore:"actor"`
+		Source    string        `json:"source" firestore:"source"`
+		Changes   []FieldChange `json:"changes" firestore:"changes"`
+	}
+)
@@ -0,0 +1,35 @@
+package model
+
+import "strings"
+
+// EmailPolicy decides which email addresses belong to the same applicant.
+type EmailPolicy struct {
+	// FoldGmail drops dots and +suffixes from Gmail addresses, which Gmail
+	// itself ignores when delivering.
+	FoldGmail bool
+	// TombstoneSecret keys the email hashes of tombstones, see EmailHash.
+	TombstoneSecret []byte
+}
+
+// Key is the identity users are stored and looked up under: the address
+// trimmed and lowercased, and folded if the policy says so.
+func (p EmailPolicy) Key(email string) string {
+	key := strings.ToLower(strings.TrimSpace(email))
+	if !p.FoldGmail {
+		return key
+	}
+
+	at := strings.LastIndex(key, "@")
+	if at < 0 {
+		return key
+	}
+	local, domain := key[:at], key[at+1:]
+	if domain != "gmail.com" && domain != "googlemail.com" {
+		return key
+	}
+
+	if plus := strings.IndexByte(local, '+'); plus >= 0 {
+		local = local[:plus]
+	}
+	return strings.ReplaceAll(local, ".", "") + "@gmail.com"
+}
@@ -0,0 +1,35 @@
+package model
+
+import "testing"
+
+func TestEmailPolicyKey(t *testing.T) {
+	cases := []struct {
+		email string
+		fold  bool
+		want  string
+	}{
+		{" Jane@Example.com ", false, "jane@example.com"},
+		{"Jane.Doe+apply@Gmail.com", false, "jane.doe+apply@gmail.com"},
+		{"Jane.Doe+apply@Gmail.com", true, "janedoe@gmail.com"},
+		{"jane.doe@googlemail.com", true, "janedoe@gmail.com"},
+		{"jane.doe+x@example.com", true, "jane.doe+x@example.com"},
+		{"not an email", true, "not an email"},
+	}
+
+	for _, c := range cases {
+		if got := (EmailPolicy{FoldGmail: c.fold}).Key(c.email); got != c.want {
+			t.Errorf("Key(%q) with fold=%v: expected %q, got %q", c.email, c.fold, c.want, got)
+		}
+	}
+}
+
+func TestTombstoneHash(t *testing.T) {
+	p := EmailPolicy{TombstoneSecret: []byte("secret")}
+
+	if got, want := p.TombstoneHash(" Jane@Example.com "), p.EmailHash("jane@example.com"); got != want {
+		t.Errorf("expected the hash of the key %s, got %s", want, got)
+	}
+	if p.TombstoneHash("jane@example.com") == (EmailPolicy{TombstoneSecret: []byte("other")}).TombstoneHash("jane@example.com") {
+		t.Error("expected the hash to depend on the secret")
+	}
+}
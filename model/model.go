@@ -1,18 +1,28 @@
 package model
 
+import "time"
+
 type User struct {
 	// Basic
 	Email string `json:"email" firestore:"email"`
-	Name  string `json:"name" firestore:"name"`
+	// EmailKey is the identity the user is stored under, see EmailPolicy.
+	EmailKey string `json:"-" firestore:"email_key"`
+	Name     string `json:"name" firestore:"name"`
 	//Location string `json:"location" firestore:"location"`
 	//Timezone  string `json:"timezone" firestore:"timezone"`
-	Phone     string `json:"phone" firestore:"phone"`
+	Phone string `json:"phone" firestore:"phone"`
+	// PhoneE164 is Phone in E.164 form, e.g. +15125550100, and empty when
+	// Phone is not a possible number. The repository keeps it in step.
+	PhoneE164 string `json:"phone_e164" firestore:"phone_e164"`
 	FirstName string `json:"first_name" firestore:"first_name"`
 	LastName  string `json:"last_name" firestore:"last_name"`
 	Photo     string `json:"photo" firestore:"photo"`
 
 	// Extras
-	LinkedInURL      string `json:"linkedin_url" firestore:"linkedin_url"`
+	LinkedInURL string `json:"linkedin_url" firestore:"linkedin_url"`
+	// LinkedInSlug is the vanity name in LinkedInURL, empty when it is not
+	// a profile URL. The repository keeps it in step.
+	LinkedInSlug     string `json:"linkedin_slug" firestore:"linkedin_slug"`
 	Representation   string `json:"representation" firestore:"representation"`
 	Gender           string `json:"gender" firestore:"gender"`
 	AgeGroup         string `json:"age_group" firestore:"age_group"`
@@ -34,12 +44,34 @@ type User struct {
 	//OpenToMeet             string `json:"open_to_meet" firestore:"open_to_meet"`
 	//RacialDemographic      string `json:"racial_demographic" firestore:"racial_demographic"`
 	PriorKnowledge string `json:"prior_knowledge" firestore:"prior_knowledge"`
+	// Answers keeps the answers to the questions of the form schema that
+	// are not stored in a field above.
+	Answers Answers `json:"answers" firestore:"answers"`
 
 	// Meta
-	Enrolled     bool   `json:"enrolled" firestore:"enrolled"`
-	CreatedAt    string `json:"created_at" firestore:"created_at"`
-	GitAccount   string `json:"gitaccount" firestore:"gitaccount"`
-	FigmaAccount string `json:"figmaaccount" firestore:"figmaaccount"`
-	GitYes       string `json:"git_yes" firestore:"git_yes"`
-	FigmaYes     string `json:"figma_yes" firestore:"figma_yes"`
+	SchemaVersion int    `json:"schema_version" firestore:"schema_version"`
+	Enrolled      bool   `json:"enrolled" firestore:"enrolled"`
+	CohortID      string `json:"cohort_id" firestore:"cohort_id"`
+	// EnrolledAt is unknown, and nil, for users enrolled before it was
+	// recorded.
+	EnrolledAt *time.Time `json:"enrolled_at" firestore:"enrolled_at"`
+	// Waitlisted users applied once their track or cohort was full. They
+	// are enrolled, in the order they joined, as seats free up.
+	Waitlisted   bool       `json:"waitlisted" firestore:"waitlisted"`
+	WaitlistedAt *time.Time `json:"waitlisted_at" firestore:"waitlisted_at"`
+	// LinkedInShared flags users who enrolled, or joined the waitlist, with
+	// the LinkedIn profile of someone else, for an admin to look into.
+	LinkedInShared bool      `json:"linkedin_shared" firestore:"linkedin_shared"`
+	CreatedAt      time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" firestore:"updated_at"`
+	GitAccount     string    `json:"gitaccount" firestore:"gitaccount"`
+	FigmaAccount   string    `json:"figmaaccount" firestore:"figmaaccount"`
+	GitYes         string    `json:"git_yes" firestore:"git_yes"`
+	FigmaYes       string    `json:"figma_yes" firestore:"figma_yes"`
+
+	// Revision identifies the stored version the user was read at. An
+	// update only goes through while it is still the latest one. Firestore
+	// leaves it unset on users read from a secondary, which can't be
+	// updated.
+	Revision int64 `json:"-" firestore:"-"`
 }
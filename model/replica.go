@@ -0,0 +1,10 @@
+package model
+
+// ReplicaCopy is a user document as held by one replica of the store. User
+// is nil when the replica has no such document; Error is set when the
+// replica couldn't be read.
+type ReplicaCopy struct {
+	Replica string `json:"replica"`
+	User    *User  `json:"user"`
+	Error   string `json:"error,omitempty"`
+}
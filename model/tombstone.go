@@ -0,0 +1,39 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Tombstone is left behind when a user is erased. It only holds a keyed hash
+// of the email, enough to recognise the user again without keeping their
+// data, and without letting anyone holding the tombstones test addresses
+// against them.
+type Tombstone struct {
+	EmailHash string    `json:"email_hash" firestore:"email_hash"`
+	DeletedAt time.Time `json:"deleted_at" firestore:"deleted_at"`
+}
+
+// NewTombstone returns the tombstone of the user stored under key.
+func (p EmailPolicy) NewTombstone(key string, deletedAt time.Time) Tombstone {
+	return Tombstone{
+		EmailHash: p.EmailHash(key),
+		DeletedAt: deletedAt,
+	}
+}
+
+// EmailHash is the hex encoded HMAC-SHA256 of key under the policy's
+// tombstone secret.
+func (p EmailPolicy) EmailHash(key string) string {
+	mac := hmac.New(sha256.New, p.TombstoneSecret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// TombstoneHash is the hash a tombstone of the user with email is filed
+// under, EmailHash of its key.
+func (p EmailPolicy) TombstoneHash(email string) string {
+	return p.EmailHash(p.Key(email))
+}
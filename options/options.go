@@ -0,0 +1,89 @@
+package options
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type (
+	// Option is one choice of a field. Value is what gets stored, Label what
+	// the applicant sees.
+	Option struct {
+		Value string `json:"value"`
+		Label string `json:"label"`
+	}
+
+	// Catalog holds the choices of the enrollment form fields, by their JSON
+	// name. Fields it leaves out keep the choices of the form schema, and
+	// values are matched regardless of case. Version changes whenever the choices do, so the
+	// frontend can tell its copy is stale.
+	Catalog struct {
+		Version string              `json:"version"`
+		Fields  map[string][]Option `json:"fields"`
+	}
+)
+
+// Load reads the catalog at path. Without a path the catalog is empty: the
+// choices are the program's to make, there is no sensible default, and a
+// form whose choice questions list none of their own won't load.
+func Load(path string) (*Catalog, error) {
+	if path == "" {
+		return &Catalog{Fields: map[string][]Option{}}, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read option catalog: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse decodes a catalog and checks that it is usable.
+func Parse(data []byte) (*Catalog, error) {
+	var c Catalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid option catalog: %w", err)
+	}
+
+	if c.Version == "" {
+		return nil, fmt.Errorf("option catalog has no version")
+	}
+	for field, opts := range c.Fields {
+		if len(opts) == 0 {
+			return nil, fmt.Errorf("field '%s' of the option catalog has no options", field)
+		}
+		seen := make(map[string]bool)
+		for _, o := range opts {
+			if o.Value == "" {
+				return nil, fmt.Errorf("field '%s' of the option catalog has an empty value", field)
+			}
+			value := strings.ToLower(o.Value)
+			if seen[value] {
+				return nil, fmt.Errorf("field '%s' of the option catalog lists '%s' twice", field, o.Value)
+			}
+			seen[value] = true
+		}
+	}
+	return &c, nil
+}
+
+// Has reports whether the catalog lists the choices of field. A nil catalog
+// lists none.
+func (c *Catalog) Has(field string) bool {
+	if c == nil {
+		return false
+	}
+	_, ok := c.Fields[field]
+	return ok
+}
+
+// Find returns the option of value, regardless of case.
+func Find(opts []Option, value string) (Option, bool) {
+	for _, o := range opts {
+		if strings.EqualFold(o.Value, value) {
+			return o, true
+		}
+	}
+	return Option{}, false
+}
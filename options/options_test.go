@@ -0,0 +1,39 @@
+package options
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	for _, data := range []string{
+		`{`,
+		`{"fields": {}}`,
+		`{"version": "1", "fields": {"gender": []}}`,
+		`{"version": "1", "fields": {"gender": [{"value": ""}]}}`,
+		`{"version": "1", "fields": {"gender": [{"value": "Female"}, {"value": "Female"}]}}`,
+		`{"version": "1", "fields": {"gender": [{"value": "Female"}, {"value": "female"}]}}`,
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("expected catalog %s to be refused", data)
+		}
+	}
+
+	c, err := Parse([]byte(`{"version": "1", "fields": {"gender": [{"value": "Female", "label": "Woman"}]}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !c.Has("gender") || c.Has("city") {
+		t.Errorf("unexpected fields in %+v", c)
+	}
+	if o, ok := Find(c.Fields["gender"], "FEMALE"); !ok || o.Value != "Female" {
+		t.Errorf("expected the catalog spelling, got %+v, %v", o, ok)
+	}
+
+	empty, err := Load("")
+	if err != nil || empty.Has("gender") {
+		t.Errorf("expected the catalog without a file to list nothing, got %+v, %v", empty, err)
+	}
+
+	var none *Catalog
+	if none.Has("gender") {
+		t.Error("expected a nil catalog to list nothing")
+	}
+}
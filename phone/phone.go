@@ -0,0 +1,161 @@
+// Package phone parses the phone numbers applicants type in. Numbers without
+// a country code are read as US numbers, the way they would be dialled from
+// the US.
+package phone
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Number is a phone number split into its country calling code and the
+// national number that follows it.
+type Number struct {
+	CountryCode int
+	National    string
+}
+
+// E.164 caps a number at 15 digits, country code included. No country
+// assigns national numbers shorter than 4 digits.
+const (
+	maxDigits         = 15
+	minNationalDigits = 4
+)
+
+// countryCodes are the assigned country calling codes.
+var countryCodes = make(map[string]bool)
+
+func init() {
+	for _, code := range strings.Fields(`
+		1 7 20 27 30 31 32 33 34 36 39 40 41 43 44 45 46 47 48 49
+		51 52 53 54 55 56 57 58 60 61 62 63 64 65 66 81 82 84 86
+		90 91 92 93 94 95 98
+		211 212 213 216 218 220 221 222 223 224 225 226 227 228 229
+		230 231 232 233 234 235 236 237 238 239 240 241 242 243 244
+		245 246 247 248 249 250 251 252 253 254 255 256 257 258 260
+		261 262 263 264 265 266 267 268 269 290 291 297 298 299
+		350 351 352 353 354 355 356 357 358 359 370 371 372 373 374
+		375 376 377 378 379 380 381 382 383 385 386 387 389
+		420 421 423 500 501 502 503 504 505 506 507 508 509
+		590 591 592 593 594 595 596 597 598 599
+		670 672 673 674 675 676 677 678 679 680 681 682 683 685 686
+		687 688 689 690 691 692
+		800 808 850 852 853 855 856 870 878 880 881 882 883 886 888
+		960 961 962 963 964 965 966 967 968 970 971 972 973 974 975
+		976 977 979 992 993 994 995 996 998
+	`) {
+		countryCodes[code] = true
+	}
+}
+
+// Parse reads s as a phone number. A leading + or the US international
+// prefix 011 starts a country code, and anything else must be a North
+// American number of 10 digits, optionally preceded by a 1. Spaces,
+// parentheses, dots and dashes are ignored; any other character, or a
+// number that can't exist, is an error.
+func Parse(s string) (Number, error) {
+	s = strings.TrimSpace(s)
+	international := strings.HasPrefix(s, "+")
+	if international {
+		s = s[1:]
+	}
+
+	var digits strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '(' || r == ')' || r == '.' || r == '-':
+		default:
+			return Number{}, fmt.Errorf("unexpected '%c' in phone number", r)
+		}
+	}
+	d := digits.String()
+	if d == "" {
+		return Number{}, fmt.Errorf("phone number has no digits")
+	}
+
+	if !international {
+		switch {
+		case strings.HasPrefix(d, "011"):
+			international, d = true, d[3:]
+		case strings.HasPrefix(d, "00"):
+			// never a North American number, so it must have been
+			// dialled from abroad
+			international, d = true, d[2:]
+		}
+	}
+	if !international {
+		if len(d) == 11 && d[0] == '1' {
+			d = d[1:]
+		}
+		return nanp(d)
+	}
+
+	if len(d) > maxDigits {
+		return Number{}, fmt.Errorf("phone number is too long")
+	}
+	for n := 1; n <= 3 && n < len(d); n++ {
+		if !countryCodes[d[:n]] {
+			continue
+		}
+		if d[:n] == "1" {
+			return nanp(d[1:])
+		}
+		if len(d)-n < minNationalDigits {
+			return Number{}, fmt.Errorf("phone number is too short")
+		}
+		code, _ := strconv.Atoi(d[:n])
+		return Number{CountryCode: code, National: d[n:]}, nil
+	}
+	return Number{}, fmt.Errorf("phone number has an unknown country code")
+}
+
+// nanp checks a national number of the North American Numbering Plan: a
+// three digit area code and exchange, neither starting with 0 or 1 nor
+// ending in 11, and four more digits. Area codes with 9 in the middle are
+// reserved.
+func nanp(d string) (Number, error) {
+	if len(d) != 10 {
+		return Number{}, fmt.Errorf("US phone numbers have 10 digits")
+	}
+	area, exchange := d[:3], d[3:6]
+	if area[0] < '2' || area[1] == '9' || area[1:] == "11" {
+		return Number{}, fmt.Errorf("phone number has an invalid area code")
+	}
+	if exchange[0] < '2' || exchange[1:] == "11" {
+		return Number{}, fmt.Errorf("phone number has an invalid exchange")
+	}
+	return Number{CountryCode: 1, National: d}, nil
+}
+
+// Valid reports whether s parses as a phone number.
+func Valid(s string) bool {
+	_, err := Parse(s)
+	return err == nil
+}
+
+// E164 formats n as +, the country code and the national number.
+func (n Number) E164() string {
+	return fmt.Sprintf("+%d%s", n.CountryCode, n.National)
+}
+
+// Display formats n for people: North American numbers as (512) 555-0100,
+// others as their country code and national number apart.
+func (n Number) Display() string {
+	if n.CountryCode == 1 {
+		return fmt.Sprintf("(%s) %s-%s", n.National[:3], n.National[3:6], n.National[6:])
+	}
+	return fmt.Sprintf("+%d %s", n.CountryCode, n.National)
+}
+
+// Normalize returns the display and E.164 forms of s, or s itself and no
+// E.164 form when it isn't a phone number.
+func Normalize(s string) (display, e164 string) {
+	n, err := Parse(s)
+	if err != nil {
+		return s, ""
+	}
+	return n.Display(), n.E164()
+}
@@ -0,0 +1,52 @@
+package phone
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		in      string
+		e164    string
+		display string
+	}{
+		{"5125550100", "+15125550100", "(512) 555-0100"},
+		{"(512) 555-0100", "+15125550100", "(512) 555-0100"},
+		{"1-512-555-0100", "+15125550100", "(512) 555-0100"},
+		{"+1 512.555.0100", "+15125550100", "(512) 555-0100"},
+		{"011 44 20 7946 0958", "+442079460958", "+44 2079460958"},
+		{"+44 20 7946 0958", "+442079460958", "+44 2079460958"},
+		{"0044 20 7946 0958", "+442079460958", "+44 2079460958"},
+		{"+234 803 123 4567", "+2348031234567", "+234 8031234567"},
+	}
+	for _, tc := range testCases {
+		n, err := Parse(tc.in)
+		if err != nil {
+			t.Errorf("Parse(%s) returned unexpected error: %v", tc.in, err)
+			continue
+		}
+		if n.E164() != tc.e164 || n.Display() != tc.display {
+			t.Errorf("Parse(%s) = %s, %s, want %s, %s", tc.in, n.E164(), n.Display(), tc.e164, tc.display)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"555-0100",
+		"512555010",
+		"51255501000",
+		"25125550100",
+		"(112) 555-0100",
+		"(911) 555-0100",
+		"(592) 555-0100",
+		"(512) 155-0100",
+		"(512) 411-0100",
+		"512-555-0100 ext 2",
+		"+1 512 555 010",
+		"+999 1234 5678",
+		"+44 123",
+		"+44 1234 5678 9012 345",
+	} {
+		if Valid(in) {
+			t.Errorf("expected %q to be refused", in)
+		}
+	}
+}
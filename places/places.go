@@ -0,0 +1,248 @@
+// Package places knows the US states and territories and their major
+// cities, for checking where applicants say they live.
+package places
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//go:embed us.json
+var usData []byte
+
+// maxSuggestions caps the close matches offered for a misspelt name.
+const maxSuggestions = 3
+
+type (
+	// State is a state, the District of Columbia or a territory. Cities
+	// lists its major cities, largest first; it is not exhaustive.
+	State struct {
+		Code      string   `json:"code"`
+		Name      string   `json:"name"`
+		Aliases   []string `json:"aliases,omitempty"`
+		Territory bool     `json:"territory,omitempty"`
+		Cities    []string `json:"cities"`
+	}
+
+	// Place is a city along with the code of its state.
+	Place struct {
+		City  string `json:"city"`
+		State string `json:"state"`
+	}
+
+	// Gazetteer looks states and cities up by name, forgiving case,
+	// punctuation and common abbreviations.
+	Gazetteer struct {
+		States []State `json:"states"`
+
+		states map[string]int
+		cities []map[string]string
+	}
+)
+
+// US is the gazetteer built into the binary.
+var US *Gazetteer
+
+func init() {
+	var err error
+	if US, err = Parse(usData); err != nil {
+		panic(err)
+	}
+}
+
+// Parse decodes a gazetteer and indexes it.
+func Parse(data []byte) (*Gazetteer, error) {
+	var g Gazetteer
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("invalid gazetteer: %w", err)
+	}
+
+	g.states = make(map[string]int)
+	g.cities = make([]map[string]string, len(g.States))
+	for i, s := range g.States {
+		if len(s.Code) != 2 || s.Code != strings.ToUpper(s.Code) || s.Name == "" {
+			return nil, fmt.Errorf("gazetteer has an invalid state '%s'", s.Code)
+		}
+		for _, name := range append([]string{s.Code, s.Name}, s.Aliases...) {
+			key := fold(name)
+			if j, ok := g.states[key]; ok && j != i {
+				return nil, fmt.Errorf("gazetteer has '%s' for both %s and %s", name, g.States[j].Code, s.Code)
+			}
+			g.states[key] = i
+		}
+
+		g.cities[i] = make(map[string]string, len(s.Cities))
+		for _, city := range s.Cities {
+			g.cities[i][fold(city)] = city
+		}
+	}
+	return &g, nil
+}
+
+// State looks s up by code, name or alias.
+func (g *Gazetteer) State(s string) (State, bool) {
+	i, ok := g.states[fold(s)]
+	if !ok {
+		return State{}, false
+	}
+	return g.States[i], true
+}
+
+// SuggestStates returns the names of the states closest to s, closest first.
+func (g *Gazetteer) SuggestStates(s string) []string {
+	names := make([]string, len(g.States))
+	for i, state := range g.States {
+		names[i] = state.Name
+	}
+	return closest(s, names)
+}
+
+// City looks name up among the cities of state, returning it as the
+// gazetteer spells it.
+func (g *Gazetteer) City(state, name string) (string, bool) {
+	i, ok := g.states[fold(state)]
+	if !ok {
+		return "", false
+	}
+	city, ok := g.cities[i][fold(name)]
+	return city, ok
+}
+
+// SuggestCities returns the cities of state closest to name, closest first.
+// A name of the gazetteer has no suggestions.
+func (g *Gazetteer) SuggestCities(state, name string) []string {
+	i, ok := g.states[fold(state)]
+	if !ok {
+		return nil
+	}
+	if _, ok := g.cities[i][fold(name)]; ok {
+		return nil
+	}
+	return closest(name, g.States[i].Cities)
+}
+
+// Complete returns up to limit cities starting with prefix, in the state
+// given or anywhere when state is empty, ordered by name.
+func (g *Gazetteer) Complete(prefix, state string, limit int) []Place {
+	prefix = fold(prefix)
+	places := []Place{}
+	if prefix == "" && state == "" {
+		return places
+	}
+
+	for i, s := range g.States {
+		if state != "" && g.states[fold(state)] != i {
+			continue
+		}
+		for _, city := range s.Cities {
+			if strings.HasPrefix(fold(city), prefix) {
+				places = append(places, Place{City: city, State: s.Code})
+			}
+		}
+	}
+
+	sort.SliceStable(places, func(i, j int) bool {
+		return places[i].City < places[j].City
+	})
+	if len(places) > limit {
+		places = places[:limit]
+	}
+	return places
+}
+
+// abbreviations are written out the short way before names are compared.
+var abbreviations = map[string]string{
+	"saint": "st",
+	"mount": "mt",
+	"fort":  "ft",
+}
+
+// fold reduces a name to what identifies it: lower case words without
+// punctuation, with common words abbreviated.
+func fold(s string) string {
+	s = strings.ToLower(s)
+	s = strings.NewReplacer(".", "", "'", "", "-", " ", ",", " ").Replace(s)
+	words := strings.Fields(s)
+	for i, w := range words {
+		if short, ok := abbreviations[w]; ok {
+			words[i] = short
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// closest returns the names within a few typos of s, fewest typos first and
+// in the order given otherwise. The typos allowed grow with the length of s.
+func closest(s string, names []string) []string {
+	s = fold(s)
+	allowed := 2
+	switch {
+	case len(s) < 4:
+		return nil
+	case len(s) < 9:
+		allowed = 1
+	}
+
+	type match struct {
+		name     string
+		distance int
+	}
+	var matches []match
+	for _, name := range names {
+		if d := distance(s, fold(name)); d <= allowed {
+			matches = append(matches, match{name, d})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].distance < matches[j].distance
+	})
+
+	var suggestions []string
+	for _, m := range matches {
+		if len(suggestions) == maxSuggestions {
+			break
+		}
+		suggestions = append(suggestions, m.name)
+	}
+	return suggestions
+}
+
+// distance counts the insertions, deletions, substitutions and swaps of
+// adjacent letters that turn a into b.
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+func min(first int, rest ...int) int {
+	for _, v := range rest {
+		if v < first {
+			first = v
+		}
+	}
+	return first
+}
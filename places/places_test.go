@@ -0,0 +1,78 @@
+package places
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestState(t *testing.T) {
+	for _, in := range []string{"NY", "ny", "N.Y.", "new york", " New  York "} {
+		if s, ok := US.State(in); !ok || s.Code != "NY" {
+			t.Errorf("State(%q) = %s, %t, want NY", in, s.Code, ok)
+		}
+	}
+	if s, ok := US.State("Washington D.C."); !ok || s.Code != "DC" {
+		t.Errorf("expected the DC alias to be known, got %s, %t", s.Code, ok)
+	}
+	if _, ok := US.State("New Yrok"); ok {
+		t.Error("expected a misspelt state to be unknown")
+	}
+	if got := US.SuggestStates("New Yrok"); !reflect.DeepEqual(got, []string{"New York"}) {
+		t.Errorf("unexpected suggestions %v", got)
+	}
+}
+
+func TestCity(t *testing.T) {
+	for _, tc := range []struct{ state, in, want string }{
+		{"TX", "austin", "Austin"},
+		{"missouri", "saint louis", "St. Louis"},
+		{"NC", "winston salem", "Winston-Salem"},
+		{"MO", "Lees Summit", "Lee's Summit"},
+	} {
+		if got, ok := US.City(tc.state, tc.in); !ok || got != tc.want {
+			t.Errorf("City(%s, %s) = %s, %t, want %s", tc.state, tc.in, got, ok, tc.want)
+		}
+	}
+
+	if got := US.SuggestCities("TX", "Austn"); !reflect.DeepEqual(got, []string{"Austin"}) {
+		t.Errorf("unexpected suggestions %v", got)
+	}
+	if got := US.SuggestCities("CA", "San Fransisco"); !reflect.DeepEqual(got, []string{"San Francisco"}) {
+		t.Errorf("unexpected suggestions %v", got)
+	}
+	if got := US.SuggestCities("TX", "Austin"); got != nil {
+		t.Errorf("expected no suggestions for a known city, got %v", got)
+	}
+	if got := US.SuggestCities("TX", "Marfa"); got != nil {
+		t.Errorf("expected no suggestions for an unrelated town, got %v", got)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	got := US.Complete("san", "tx", 10)
+	if !reflect.DeepEqual(got, []Place{{City: "San Antonio", State: "TX"}}) {
+		t.Errorf("unexpected completions %v", got)
+	}
+	if got := US.Complete("spring", "", 2); len(got) != 2 || got[0].City != "Springdale" {
+		t.Errorf("unexpected completions %v", got)
+	}
+	if got := US.Complete("", "", 10); len(got) != 0 {
+		t.Errorf("expected nothing without a prefix or state, got %v", got)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"austin", "austin", 0},
+		{"austn", "austin", 1},
+		{"autsin", "austin", 1},
+		{"boston", "austin", 3},
+	} {
+		if got := distance(tc.a, tc.b); got != tc.want {
+			t.Errorf("distance(%s, %s) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
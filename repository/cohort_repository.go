@@ -0,0 +1,249 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog"
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CohortRepository keeps cohorts in the primary Firestore project. Cohorts
+// are program settings rather than applicant data, so they aren't replicated.
+type CohortRepository struct {
+	logger zerolog.Logger
+	client *firestore.Client
+	users  *firestore.CollectionRef
+}
+
+var _ CohortRepositoryInterface = (*CohortRepository)(nil)
+
+func NewCohortRepository(logger zerolog.Logger, users *UserRepository) *CohortRepository {
+	return &CohortRepository{
+		logger: logger,
+		client: users.primary.client,
+		users:  users.primary.users(),
+	}
+}
+
+func (r *CohortRepository) SaveCohort(ctx context.Context, cohort model.Cohort) (*model.Cohort, error) {
+	r.logger.Debug().Msgf("Firestore: saving cohort: %s", cohort.ID)
+
+	if _, err := r.client.Collection("cohorts").Doc(cohort.ID).Set(ctx, cohort); err != nil {
+		return nil, errors.From(err, "failed to save cohort", 500)
+	}
+
+	return &cohort, nil
+}
+
+func (r *CohortRepository) GetCohort(ctx context.Context, id string) (*model.Cohort, error) {
+	r.logger.Debug().Msgf("Firestore: getting cohort: %s", id)
+
+	data, err := r.client.Collection("cohorts").Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, errors.From(err, "Cohort Not Found", 404)
+	}
+	if err != nil {
+		return nil, errors.From(err, "failed to get cohort", 500)
+	}
+
+	cohort := model.Cohort{}
+	if err := data.DataTo(&cohort); err != nil {
+		return nil, errors.From(err, "failed to bind cohort data", 500)
+	}
+
+	return &cohort, nil
+}
+
+func (r *CohortRepository) OpenCohort(ctx context.Context, t time.Time) (*model.Cohort, error) {
+	r.logger.Debug().Msgf("Firestore: getting cohort open at: %s", t)
+
+	docs, err := r.client.Collection("cohorts").Where("enrollment_close", ">", t).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, errors.From(err, "failed to get open cohort", 500)
+	}
+
+	cohorts := make([]model.Cohort, 0, len(docs))
+	for _, doc := range docs {
+		cohort := model.Cohort{}
+		if err := doc.DataTo(&cohort); err != nil {
+			return nil, errors.From(err, "failed to bind cohort data", 500)
+		}
+		cohorts = append(cohorts, cohort)
+	}
+
+	return openCohort(cohorts, t)
+}
+
+func (r *CohortRepository) ListCohorts(ctx context.Context) ([]model.Cohort, error) {
+	r.logger.Debug().Msg("Firestore: listing cohorts")
+
+	docs, err := r.client.Collection("cohorts").OrderBy("start_date", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, errors.From(err, "failed to list cohorts", 500)
+	}
+
+	cohorts := make([]model.Cohort, 0, len(docs))
+	for _, doc := range docs {
+		cohort := model.Cohort{}
+		if err := doc.DataTo(&cohort); err != nil {
+			return nil, errors.From(err, "failed to bind cohort data", 500)
+		}
+		cohorts = append(cohorts, cohort)
+	}
+
+	return cohorts, nil
+}
+
+// seatCollection holds one model.Seats per cohort, keyed by cohort ID.
+const seatCollection = "cohort_seats"
+
+func (r *CohortRepository) ReserveSeat(ctx context.Context, cohort model.Cohort, track string) (bool, error) {
+	r.logger.Debug().Msgf("Firestore: reserving seat on %s in cohort: %s", track, cohort.ID)
+
+	ref := r.client.Collection(seatCollection).Doc(cohort.ID)
+	reserved := false
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		reserved = false
+		seats, err := r.seatsIn(tx, ref, cohort.ID)
+		if err != nil {
+			return err
+		}
+		if !cohort.HasSeat(track, *seats) {
+			return nil
+		}
+		seats.Total++
+		seats.Tracks[track]++
+		reserved = true
+		return tx.Set(ref, seats)
+	})
+	if err != nil {
+		return false, errors.From(err, "failed to reserve seat", 500)
+	}
+
+	return reserved, nil
+}
+
+func (r *CohortRepository) ReleaseSeat(ctx context.Context, cohortID, track string) error {
+	r.logger.Debug().Msgf("Firestore: releasing seat on %s in cohort: %s", track, cohortID)
+
+	ref := r.client.Collection(seatCollection).Doc(cohortID)
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		seats, err := r.seatsIn(tx, ref, cohortID)
+		if err != nil {
+			return err
+		}
+		releaseSeat(seats, track)
+		return tx.Set(ref, seats)
+	})
+	if err != nil {
+		return errors.From(err, "failed to release seat", 500)
+	}
+
+	return nil
+}
+
+func (r *CohortRepository) ReconcileSeats(ctx context.Context, cohortID string) (*model.Seats, error) {
+	r.logger.Debug().Msgf("Firestore: reconciling seats of cohort: %s", cohortID)
+
+	ref := r.client.Collection(seatCollection).Doc(cohortID)
+	var seats *model.Seats
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var err error
+		if seats, err = r.enrolledSeats(tx, cohortID); err != nil {
+			return err
+		}
+		return tx.Set(ref, seats)
+	})
+	if err != nil {
+		return nil, errors.From(err, "failed to reconcile seats", 500)
+	}
+
+	return seats, nil
+}
+
+func (r *CohortRepository) GetSeats(ctx context.Context, cohortID string) (*model.Seats, error) {
+	r.logger.Debug().Msgf("Firestore: getting seats of cohort: %s", cohortID)
+
+	ref := r.client.Collection(seatCollection).Doc(cohortID)
+	var seats *model.Seats
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var err error
+		seats, err = r.seatsIn(tx, ref, cohortID)
+		return err
+	}, firestore.ReadOnly)
+	if err != nil {
+		return nil, errors.From(err, "failed to get seats", 500)
+	}
+
+	return seats, nil
+}
+
+// seatsIn reads the seats of a cohort within tx. Cohorts that enrolled users
+// before seats were counted start from the users enrolled in them.
+func (r *CohortRepository) seatsIn(tx *firestore.Transaction, ref *firestore.DocumentRef, cohortID string) (*model.Seats, error) {
+	snap, err := tx.Get(ref)
+	if err == nil {
+		seats := &model.Seats{}
+		if err := snap.DataTo(seats); err != nil {
+			return nil, err
+		}
+		if seats.Tracks == nil {
+			seats.Tracks = make(map[string]int)
+		}
+		return seats, nil
+	}
+	if status.Code(err) != codes.NotFound {
+		return nil, err
+	}
+	return r.enrolledSeats(tx, cohortID)
+}
+
+// enrolledSeats counts the seats taken by the users enrolled in the cohort.
+func (r *CohortRepository) enrolledSeats(tx *firestore.Transaction, cohortID string) (*model.Seats, error) {
+	seats := &model.Seats{Tracks: make(map[string]int)}
+	docs, err := tx.Documents(r.users.Where("cohort_id", "==", cohortID).Where("enrolled", "==", true)).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		track, _ := doc.Data()["learning_track"].(string)
+		seats.Total++
+		seats.Tracks[track]++
+	}
+	return seats, nil
+}
+
+// openCohort picks the cohort open at t that starts first.
+func openCohort(cohorts []model.Cohort, t time.Time) (*model.Cohort, error) {
+	var open []model.Cohort
+	for _, c := range cohorts {
+		if c.IsOpen(t) {
+			open = append(open, c)
+		}
+	}
+	if len(open) == 0 {
+		return nil, errors.New("No Cohort is Open for Enrollment", 404)
+	}
+
+	sort.Slice(open, func(i, j int) bool {
+		return open[i].StartDate.Before(open[j].StartDate)
+	})
+	return &open[0], nil
+}
+
+// releaseSeat gives back a seat on track, never going below zero should the
+// count be off.
+func releaseSeat(seats *model.Seats, track string) {
+	if seats.Total > 0 {
+		seats.Total--
+	}
+	if seats.Tracks[track] > 0 {
+		seats.Tracks[track]--
+	}
+}
@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+)
+
+func TestMemoryCohortRepository(t *testing.T) {
+	testCohortRepository(t, NewMemoryCohortRepository(zerolog.Nop(), NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{})))
+}
+
+func TestSQLCohortRepository(t *testing.T) {
+	testCohortRepository(t, NewSQLCohortRepository(zerolog.Nop(), newTestSQLUserRepository(t).db))
+}
+
+func TestMemorySeats(t *testing.T) {
+	testSeats(t, NewMemoryCohortRepository(zerolog.Nop(), NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{})))
+}
+
+func TestSQLSeats(t *testing.T) {
+	testSeats(t, NewSQLCohortRepository(zerolog.Nop(), newTestSQLUserRepository(t).db))
+}
+
+func TestMemoryReconcileSeats(t *testing.T) {
+	users := NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{})
+	testReconcileSeats(t, users, NewMemoryCohortRepository(zerolog.Nop(), users))
+}
+
+func TestReconcileSeats(t *testing.T) {
+	users, _ := newFakeUserRepository(t, 0)
+	testReconcileSeats(t, users, NewCohortRepository(zerolog.Nop(), users))
+}
+
+func TestSQLReconcileSeats(t *testing.T) {
+	users := newTestSQLUserRepository(t)
+	testReconcileSeats(t, users, NewSQLCohortRepository(zerolog.Nop(), users.db))
+}
+
+func testReconcileSeats(t *testing.T, users UserRepositoryInterface, repo CohortRepositoryInterface) {
+	t.Helper()
+	ctx := context.Background()
+
+	cohort := model.Cohort{ID: "fall", Capacity: 2}
+	for _, track := range []string{"frontend", "*"} {
+		if ok, err := repo.ReserveSeat(ctx, cohort, track); err != nil || !ok {
+			t.Fatalf("expected a seat on %s, got %v, %v", track, ok, err)
+		}
+	}
+	// a track named like anything else counts apart from the cohort
+	if seats, err := repo.GetSeats(ctx, "fall"); err != nil || seats.Total != 2 || seats.Tracks["*"] != 1 {
+		t.Fatalf("unexpected seats %+v, %v", seats, err)
+	}
+
+	// only the frontend enrollment went through
+	user, err := users.CreateUser(ctx, model.User{Email: "jane@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Enrolled, user.CohortID, user.LearningTrack = true, "fall", "frontend"
+	if _, err := users.UpdateUser(ctx, *user, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	seats, err := repo.ReconcileSeats(ctx, "fall")
+	if err != nil {
+		t.Fatalf("unexpected error reconciling seats: %v", err)
+	}
+	if seats.Total != 1 || seats.Tracks["frontend"] != 1 || seats.Tracks["*"] != 0 {
+		t.Errorf("expected the seats of the enrolled user, got %+v", seats)
+	}
+	if ok, err := repo.ReserveSeat(ctx, cohort, "backend"); err != nil || !ok {
+		t.Errorf("expected the seat given back to be taken again, got %v, %v", ok, err)
+	}
+}
+
+func testSeats(t *testing.T, repo CohortRepositoryInterface) {
+	t.Helper()
+	ctx := context.Background()
+
+	cohort := model.Cohort{ID: "fall", Name: "Fall", Capacity: 3, TrackCapacity: map[string]int{"frontend": 2}}
+	if _, err := repo.SaveCohort(ctx, cohort); err != nil {
+		t.Fatalf("unexpected error saving cohort: %v", err)
+	}
+	saved, err := repo.GetCohort(ctx, "fall")
+	if err != nil || saved.TrackCapacity["frontend"] != 2 {
+		t.Fatalf("expected track capacity to be saved, got %+v, %v", saved, err)
+	}
+
+	reserve := func(track string, want bool) {
+		t.Helper()
+		ok, err := repo.ReserveSeat(ctx, cohort, track)
+		if err != nil {
+			t.Fatalf("unexpected error reserving seat: %v", err)
+		}
+		if ok != want {
+			t.Fatalf("expected reserving a seat on %s to give %v", track, want)
+		}
+	}
+
+	reserve("frontend", true)
+	reserve("frontend", true)
+	// the track is full, the cohort is not
+	reserve("frontend", false)
+	reserve("backend", true)
+	// now the cohort is full
+	reserve("backend", false)
+
+	seats, err := repo.GetSeats(ctx, "fall")
+	if err != nil {
+		t.Fatalf("unexpected error getting seats: %v", err)
+	}
+	if seats.Total != 3 || seats.Tracks["frontend"] != 2 || seats.Tracks["backend"] != 1 {
+		t.Errorf("unexpected seats %+v", seats)
+	}
+
+	if err := repo.ReleaseSeat(ctx, "fall", "frontend"); err != nil {
+		t.Fatalf("unexpected error releasing seat: %v", err)
+	}
+	reserve("backend", true)
+	reserve("frontend", false)
+
+	// an unlimited cohort never runs out
+	unlimited := model.Cohort{ID: "open"}
+	for i := 0; i < 5; i++ {
+		if ok, err := repo.ReserveSeat(ctx, unlimited, "frontend"); err != nil || !ok {
+			t.Fatalf("expected a seat in an unlimited cohort, got %v, %v", ok, err)
+		}
+	}
+}
+
+func testCohortRepository(t *testing.T, repo CohortRepositoryInterface) {
+	t.Helper()
+	ctx := context.Background()
+
+	day := func(d int) time.Time { return time.Date(2022, 9, d, 0, 0, 0, 0, time.UTC) }
+	cohorts := []model.Cohort{
+		{ID: "fall", Name: "Fall", StartDate: day(30), EnrollmentOpen: day(1), EnrollmentClose: day(20), Tracks: []string{"frontend", "backend"}},
+		{ID: "early", Name: "Early", StartDate: day(25), EnrollmentOpen: day(10), EnrollmentClose: day(15)},
+	}
+	for _, c := range cohorts {
+		if _, err := repo.SaveCohort(ctx, c); err != nil {
+			t.Fatalf("unexpected error saving cohort: %v", err)
+		}
+	}
+
+	if _, err := repo.OpenCohort(ctx, day(25)); errors.CodeFrom(err) != 404 {
+		t.Errorf("expected 404 when no cohort is open, got %v", err)
+	}
+
+	open, err := repo.OpenCohort(ctx, day(5))
+	if err != nil {
+		t.Fatalf("unexpected error getting open cohort: %v", err)
+	}
+	if open.ID != "fall" || !open.StartDate.Equal(day(30)) || len(open.Tracks) != 2 {
+		t.Errorf("unexpected open cohort %+v", open)
+	}
+
+	open, err = repo.OpenCohort(ctx, day(12))
+	if err != nil {
+		t.Fatalf("unexpected error getting open cohort: %v", err)
+	}
+	if open.ID != "early" {
+		t.Errorf("expected the cohort starting first to win, got %s", open.ID)
+	}
+
+	all, err := repo.ListCohorts(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error listing cohorts: %v", err)
+	}
+	if len(all) != 2 || all[0].ID != "early" {
+		t.Errorf("expected cohorts ordered by start date, got %+v", all)
+	}
+
+	if _, err := repo.GetCohort(ctx, "missing"); errors.CodeFrom(err) != 404 {
+		t.Errorf("expected 404 for missing cohort, got %v", err)
+	}
+}
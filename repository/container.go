@@ -1,13 +1,98 @@
 package repository
 
-import "github.com/rs/zerolog"
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/thealamu/linkedinsignin/config"
+	"github.com/thealamu/linkedinsignin/model"
+)
 
 type Container struct {
-	UserRepository *UserRepository
+	UserRepository   UserRepositoryInterface
+	CohortRepository CohortRepositoryInterface
+
+	// close stops what the repositories run in the background and releases
+	// their connections
+	close func()
 }
 
-func NewContainer(logger zerolog.Logger) *Container {
-	return &Container{
-		UserRepository: NewUserRepository(logger),
+// Close stops the container's background work, such as the replica sync,
+// and closes its connections. The repositories can't be used afterwards.
+func (c *Container) Close() {
+	if c.close != nil {
+		c.close()
 	}
 }
+
+func NewContainer(logger zerolog.Logger, env config.Environment) *Container {
+	keys := EmailPolicyFrom(env)
+
+	switch env[config.UserStore] {
+	case config.UserStoreMemory:
+		logger.Warn().Msg("Using in-memory user store, data will not survive a restart")
+		users := NewMemoryUserRepository(logger, keys)
+		return &Container{
+			UserRepository:   users,
+			CohortRepository: NewMemoryCohortRepository(logger, users),
+		}
+	case config.UserStoreSQL:
+		users := newSQLUserRepository(logger, keys, env)
+		return &Container{
+			UserRepository:   users,
+			CohortRepository: NewSQLCohortRepository(logger, users.db),
+			close: func() {
+				if err := users.db.Close(); err != nil {
+					logger.Err(err).Msg("Failed to close database")
+				}
+			},
+		}
+	default:
+		users, stopSync := newFirestoreUserRepository(logger, keys, env)
+		return &Container{
+			UserRepository:   users,
+			CohortRepository: NewCohortRepository(logger, users),
+			close: func() {
+				stopSync()
+				users.Close()
+			},
+		}
+	}
+}
+
+// EmailPolicyFrom reads the email key and tombstone policy from env.
+func EmailPolicyFrom(env config.Environment) model.EmailPolicy {
+	// config.New has already validated the flag
+	fold, _ := strconv.ParseBool(env[config.EmailFoldGmail])
+	return model.EmailPolicy{FoldGmail: fold, TombstoneSecret: []byte(env[config.TombstoneSecret])}
+}
+
+func newSQLUserRepository(logger zerolog.Logger, keys model.EmailPolicy, env config.Environment) *SQLUserRepository {
+	db, err := sql.Open(env[config.DatabaseDriver], env[config.DatabaseURL])
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to open database")
+	}
+
+	r, err := NewSQLUserRepository(context.Background(), logger, keys, db)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to migrate database")
+	}
+	return r
+}
+
+// newFirestoreUserRepository returns the repository with its replica sync
+// started, and the func that stops the sync.
+func newFirestoreUserRepository(logger zerolog.Logger, keys model.EmailPolicy, env config.Environment) (*UserRepository, func()) {
+	// config.New has already validated the replicas
+	replicas, _ := config.Replicas(env)
+	r := NewUserRepository(logger, keys, replicas)
+
+	// config.New has already validated the interval
+	interval, _ := time.ParseDuration(env[config.ReplicaSyncInterval])
+	stop := r.StartReplicaSync(interval)
+
+	return r, stop
+}
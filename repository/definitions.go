@@ -2,25 +2,135 @@ package repository
 
 import (
 	"context"
+	"time"
+
+	"github.com/thealamu/linkedinsignin/errors"
 	"github.com/thealamu/linkedinsignin/model"
 )
 
+// errConcurrentUpdate is returned by UpdateUser when the user has changed
+// since it was read.
+var errConcurrentUpdate = errors.New("User Was Changed by Another Request, Please Try Again", 409)
+
 type (
 	UserCreator interface {
 		CreateUser(ctx context.Context, user model.User) (*model.User, error)
 	}
 
+	// UserUpdater writes user, which carries the revision it was read at.
+	// When edit is given, the changes are added to the user's history in
+	// the same write, so neither is ever kept without the other.
 	UserUpdater interface {
-		UpdateUser(ctx context.Context, user model.User) (*model.User, error)
+		UpdateUser(ctx context.Context, user model.User, edit *UserEdit) (*model.User, error)
 	}
 
 	UserGetter interface {
 		GetUser(ctx context.Context, email string) (*model.User, error)
 	}
 
+	// UserImporter creates users in bulk, each call in one write. Users
+	// must not share an email key. When dryRun is set nothing is written.
+	UserImporter interface {
+		ImportUsers(ctx context.Context, users []model.User, dryRun bool) ([]UserImport, error)
+	}
+
+	// UserLister pages through users matching filter, oldest first. The
+	// cursor comes from the previous page and is empty for the first one;
+	// limit must be positive.
+	UserLister interface {
+		ListUsers(ctx context.Context, filter UserFilter, cursor string, limit int) (*UserPage, error)
+	}
+
+	// UserDeleter erases a user along with their history and leaves a
+	// tombstone in their place. Erasing a user again returns the tombstone.
+	UserDeleter interface {
+		DeleteUser(ctx context.Context, email string) (*model.Tombstone, error)
+	}
+
+	// UserHistoryRecorder appends to a user's history. Entries are never
+	// changed once written.
+	UserHistoryRecorder interface {
+		RecordUserChange(ctx context.Context, email string, change model.UserChange) error
+	}
+
+	// UserHistoryGetter returns a user's history, oldest first.
+	UserHistoryGetter interface {
+		GetUserHistory(ctx context.Context, email string) ([]model.UserChange, error)
+	}
+
+	// UserReplicaGetter reads a user from every replica of the store, for
+	// when the individual copies matter rather than a single answer.
+	UserReplicaGetter interface {
+		GetUserReplicas(ctx context.Context, email string) ([]model.ReplicaCopy, error)
+	}
+
+	EmailRecorder interface {
+		RecordEmail(ctx context.Context, email string, record model.EmailRecord) error
+	}
+
+	// EmailRecordGetter returns the emails sent to a user, oldest first.
+	EmailRecordGetter interface {
+		GetEmailRecords(ctx context.Context, email string) ([]model.EmailRecord, error)
+	}
+
+	// UserMerger is implemented by the stores that may hold users from
+	// before email keys, see UserMerge.
+	UserMerger interface {
+		MergeDuplicateUsers(ctx context.Context, dryRun bool) ([]UserMerge, error)
+	}
+
 	UserRepositoryInterface interface {
 		UserCreator
 		UserUpdater
 		UserGetter
+		UserImporter
+		UserLister
+		UserDeleter
+		UserHistoryRecorder
+		UserHistoryGetter
+		UserReplicaGetter
+		EmailRecorder
+		EmailRecordGetter
+	}
+
+	// CohortSaver creates the cohort, or replaces it when its ID is taken.
+	CohortSaver interface {
+		SaveCohort(ctx context.Context, cohort model.Cohort) (*model.Cohort, error)
+	}
+
+	CohortGetter interface {
+		GetCohort(ctx context.Context, id string) (*model.Cohort, error)
+		// OpenCohort returns the cohort accepting enrollments at t. When
+		// several are open, the one starting first wins.
+		OpenCohort(ctx context.Context, t time.Time) (*model.Cohort, error)
+	}
+
+	CohortLister interface {
+		ListCohorts(ctx context.Context) ([]model.Cohort, error)
+	}
+
+	// CohortSeatCounter keeps count of the seats taken in each cohort,
+	// overall and per learning track, so that concurrent enrollments can't
+	// take more seats than there are.
+	CohortSeatCounter interface {
+		// ReserveSeat takes a seat on track in cohort. It reports false,
+		// taking nothing, when the track or the cohort is full.
+		ReserveSeat(ctx context.Context, cohort model.Cohort, track string) (bool, error)
+		// ReleaseSeat gives back a seat taken by ReserveSeat.
+		ReleaseSeat(ctx context.Context, cohortID, track string) error
+		GetSeats(ctx context.Context, cohortID string) (*model.Seats, error)
+		// ReconcileSeats recounts the seats taken in the cohort from the
+		// users enrolled in it, giving back seats that were reserved for
+		// an enrollment that never went through. Seats reserved for one
+		// still in flight are given back too, so it is meant for when
+		// enrollment into the cohort is quiet.
+		ReconcileSeats(ctx context.Context, cohortID string) (*model.Seats, error)
+	}
+
+	CohortRepositoryInterface interface {
+		CohortSaver
+		CohortGetter
+		CohortLister
+		CohortSeatCounter
 	}
 )
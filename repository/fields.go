@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/thealamu/linkedinsignin/linkedin"
+	"github.com/thealamu/linkedinsignin/model"
+	"github.com/thealamu/linkedinsignin/phone"
+)
+
+// applyUpdates sets the fields of user named by the firestore tags in
+// updates, leaving every other field untouched.
+func applyUpdates(user *model.User, updates []firestore.Update) error {
+	v := reflect.ValueOf(user).Elem()
+	fields := userFieldsByTag()
+
+	for _, update := range updates {
+		i, ok := fields[update.Path]
+		if !ok {
+			return fmt.Errorf("unknown user field '%s'", update.Path)
+		}
+		v.Field(i).Set(reflect.ValueOf(update.Value))
+	}
+	return nil
+}
+
+// userFieldsByTag maps the firestore tag of each model.User field to the
+// field's index.
+func userFieldsByTag() map[string]int {
+	t := reflect.TypeOf(model.User{})
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if tag := firestoreTag(t.Field(i)); tag != "" {
+			fields[tag] = i
+		}
+	}
+	return fields
+}
+
+// firestoreTag returns the document field name of f, or "" when f is not
+// persisted.
+func firestoreTag(f reflect.StructField) string {
+	tag := strings.Split(f.Tag.Get("firestore"), ",")[0]
+	if tag == "-" {
+		return ""
+	}
+	return tag
+}
+
+// UserUpdateDiff lists the fields an update from before to after changes,
+// considering only the fields UpdateUser writes.
+func UserUpdateDiff(before, after model.User) []model.FieldChange {
+	old := userUpdates(before)
+
+	var changes []model.FieldChange
+	for i, update := range userUpdates(after) {
+		if !reflect.DeepEqual(old[i].Value, update.Value) {
+			changes = append(changes, model.FieldChange{Path: update.Path, Before: old[i].Value, After: update.Value})
+		}
+	}
+	return changes
+}
+
+// UserEdit attributes an update, for the user's history.
+type UserEdit struct {
+	// Before is the user as it was read for the update
+	Before model.User
+	Actor  string
+	Source string
+}
+
+// change returns the history entry for an update of the edit's user to
+// after at t, or nil when there is no edit or nothing changes.
+func (e *UserEdit) change(after model.User, t time.Time) *model.UserChange {
+	if e == nil {
+		return nil
+	}
+	changes := UserUpdateDiff(e.Before, after)
+	if len(changes) == 0 {
+		return nil
+	}
+	return &model.UserChange{
+		Timestamp: t,
+		Actor:     e.Actor,
+		Source:    e.Source,
+		Changes:   changes,
+	}
+}
+
+// storeTime is the current time at the microsecond precision every backend
+// keeps, so a user reads back exactly as it was written.
+func storeTime() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// stampCreate sets the timestamps the repository owns on a new user. A
+// CreatedAt given by the caller, e.g. from an import, is kept.
+func stampCreate(user *model.User, now time.Time) {
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	stampUpdate(user, now)
+}
+
+// stampUpdate sets the timestamps and derived fields the repository owns on
+// an updated user and returns the fields to write, userUpdates plus
+// updated_at.
+func stampUpdate(user *model.User, now time.Time) []firestore.Update {
+	user.UpdatedAt = now
+	if user.Enrolled && user.EnrolledAt == nil {
+		user.EnrolledAt = &now
+	}
+	if user.Waitlisted && user.WaitlistedAt == nil {
+		user.WaitlistedAt = &now
+	}
+	deriveUserFields(user)
+	return append(userUpdates(*user), firestore.Update{Path: "updated_at", Value: user.UpdatedAt})
+}
+
+// deriveUserFields sets the fields kept in step with others of user.
+func deriveUserFields(user *model.User) {
+	_, user.PhoneE164 = phone.Normalize(user.Phone)
+	_, user.LinkedInSlug = linkedin.CanonicalProfileURL(user.LinkedInURL)
+}
@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/thealamu/linkedinsignin/model"
+)
+
+func TestUserUpdateDiff(t *testing.T) {
+	before := model.User{Email: "jane@example.com", Name: "Jane", City: "Austin"}
+	after := model.User{Email: "jane@example.com", Name: "Janet", City: "Dallas", Enrolled: true}
+
+	changes := UserUpdateDiff(before, after)
+
+	got := make(map[string]model.FieldChange)
+	for _, c := range changes {
+		got[c.Path] = c
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected changes to city and enrolled only, got %+v", changes)
+	}
+	if c := got["city"]; c.Before != "Austin" || c.After != "Dallas" {
+		t.Errorf("unexpected city change %+v", c)
+	}
+	if c := got["enrolled"]; c.Before != false || c.After != true {
+		t.Errorf("unexpected enrolled change %+v", c)
+	}
+}
@@ -0,0 +1,644 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/rs/zerolog"
+	"github.com/thealamu/linkedinsignin/model"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeFirestore is an in-process Firestore holding documents in a map. It
+// implements the calls the repository makes: document reads and commits
+// with preconditions and transforms, collection queries with simple filters,
+// orders and cursors, document listing and transactions, which don't isolate
+// anything as the tests don't race.
+type fakeFirestore struct {
+	pb.UnimplementedFirestoreServer
+
+	mu       sync.Mutex
+	docs     map[string]*pb.Document
+	now      time.Time
+	failures map[string][]codes.Code
+	down     codes.Code
+}
+
+// newFakeFirestore starts a fake project and returns it with a client
+// connected to it.
+func newFakeFirestore(t *testing.T) (*fakeFirestore, *firestore.Client) {
+	t.Helper()
+
+	f := &fakeFirestore{
+		docs:     make(map[string]*pb.Document),
+		now:      time.Now().UTC().Truncate(time.Microsecond),
+		failures: make(map[string][]codes.Code),
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	pb.RegisterFirestoreServer(srv, f)
+	go srv.Serve(lis)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := firestore.NewClient(context.Background(), "test", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Close()
+		srv.Stop()
+	})
+	return f, client
+}
+
+// newFakeUserRepository returns a repository over a fake primary and the
+// given number of fake secondaries, primary first in the fakes returned.
+func newFakeUserRepository(t *testing.T, secondaries int) (*UserRepository, []*fakeFirestore) {
+	t.Helper()
+
+	f, client := newFakeFirestore(t)
+	u := &UserRepository{
+		logger:  zerolog.Nop(),
+		keys:    model.EmailPolicy{},
+		primary: &replica{name: "primary", client: client, collection: "users"},
+	}
+	fakes := []*fakeFirestore{f}
+	for i := 0; i < secondaries; i++ {
+		f, client := newFakeFirestore(t)
+		u.secondaries = append(u.secondaries, &replica{name: "secondary" + string(rune('1'+i)), client: client, collection: "users"})
+		fakes = append(fakes, f)
+	}
+	return u, fakes
+}
+
+// fail makes the next n calls to method fail with code.
+func (f *fakeFirestore) fail(method string, code codes.Code, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := 0; i < n; i++ {
+		f.failures[method] = append(f.failures[method], code)
+	}
+}
+
+// setDown makes every call fail with code, or none again when code is OK.
+func (f *fakeFirestore) setDown(code codes.Code) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = code
+}
+
+// injected must be called with mu held.
+func (f *fakeFirestore) injected(method string) error {
+	if f.down != codes.OK {
+		return status.Error(f.down, "fake firestore is down")
+	}
+	if queue := f.failures[method]; len(queue) > 0 {
+		f.failures[method] = queue[1:]
+		return status.Errorf(queue[0], "fake %s failure", method)
+	}
+	return nil
+}
+
+// tick must be called with mu held. Every commit gets a later time.
+func (f *fakeFirestore) tick() *timestamppb.Timestamp {
+	f.now = f.now.Add(time.Microsecond)
+	return timestamppb.New(f.now)
+}
+
+// doc returns a copy of the document at path, which is relative to the
+// database, e.g. "users/jane@example.com", or nil.
+func (f *fakeFirestore) doc(path string) *pb.Document {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, d := range f.docs {
+		if strings.HasSuffix(name, "/documents/"+path) {
+			return proto.Clone(d).(*pb.Document)
+		}
+	}
+	return nil
+}
+
+// put stores fields at path as they are, bypassing the client, e.g. for
+// documents in a shape this build no longer writes.
+func (f *fakeFirestore) put(path string, fields map[string]*pb.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.tick()
+	name := "projects/test/databases/(default)/documents/" + path
+	f.docs[name] = &pb.Document{Name: name, Fields: fields, CreateTime: now, UpdateTime: now}
+}
+
+// count returns the number of documents directly in the collection at path.
+func (f *fakeFirestore) count(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for name := range f.docs {
+		if inCollection(name, "projects/test/databases/(default)/documents", path) {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeFirestore) BatchGetDocuments(req *pb.BatchGetDocumentsRequest, stream pb.Firestore_BatchGetDocumentsServer) error {
+	f.mu.Lock()
+	if err := f.injected("BatchGetDocuments"); err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	var resps []*pb.BatchGetDocumentsResponse
+	readTime := timestamppb.New(f.now)
+	for _, name := range req.Documents {
+		resp := &pb.BatchGetDocumentsResponse{ReadTime: readTime}
+		if d, ok := f.docs[name]; ok {
+			resp.Result = &pb.BatchGetDocumentsResponse_Found{Found: proto.Clone(d).(*pb.Document)}
+		} else {
+			resp.Result = &pb.BatchGetDocumentsResponse_Missing{Missing: name}
+		}
+		resps = append(resps, resp)
+	}
+	f.mu.Unlock()
+
+	for _, resp := range resps {
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeFirestore) BeginTransaction(ctx context.Context, req *pb.BeginTransactionRequest) (*pb.BeginTransactionResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.injected("BeginTransaction"); err != nil {
+		return nil, err
+	}
+	return &pb.BeginTransactionResponse{Transaction: []byte("tx")}, nil
+}
+
+func (f *fakeFirestore) Rollback(ctx context.Context, req *pb.RollbackRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+// Commit applies every write or none, like Firestore.
+func (f *fakeFirestore) Commit(ctx context.Context, req *pb.CommitRequest) (*pb.CommitResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.injected("Commit"); err != nil {
+		return nil, err
+	}
+
+	docs := make(map[string]*pb.Document, len(f.docs))
+	for name, d := range f.docs {
+		docs[name] = d
+	}
+	now := f.tick()
+
+	resp := &pb.CommitResponse{CommitTime: now}
+	for _, w := range req.Writes {
+		var name string
+		switch op := w.Operation.(type) {
+		case *pb.Write_Update:
+			name = op.Update.Name
+		case *pb.Write_Delete:
+			name = op.Delete
+		case *pb.Write_Transform:
+			name = op.Transform.Document
+		}
+
+		current := docs[name]
+		if err := checkPrecondition(w.CurrentDocument, current); err != nil {
+			return nil, err
+		}
+
+		if _, ok := w.Operation.(*pb.Write_Delete); ok {
+			delete(docs, name)
+			resp.WriteResults = append(resp.WriteResults, &pb.WriteResult{UpdateTime: now})
+			continue
+		}
+
+		if op, ok := w.Operation.(*pb.Write_Transform); ok {
+			next := &pb.Document{Name: name, Fields: map[string]*pb.Value{}, CreateTime: now, UpdateTime: now}
+			if current != nil {
+				next = proto.Clone(current).(*pb.Document)
+				next.UpdateTime = now
+			}
+			for _, t := range op.Transform.FieldTransforms {
+				if err := applyTransform(next.Fields, t, now); err != nil {
+					return nil, err
+				}
+			}
+			docs[name] = next
+			resp.WriteResults = append(resp.WriteResults, &pb.WriteResult{UpdateTime: now})
+			continue
+		}
+
+		update := w.Operation.(*pb.Write_Update).Update
+		next := &pb.Document{Name: name, Fields: map[string]*pb.Value{}, CreateTime: now, UpdateTime: now}
+		if current != nil {
+			next.CreateTime = current.CreateTime
+		}
+		if w.UpdateMask == nil || current == nil {
+			for k, v := range update.Fields {
+				next.Fields[k] = v
+			}
+		}
+		if w.UpdateMask != nil {
+			if current != nil {
+				next.Fields = proto.Clone(current).(*pb.Document).Fields
+			}
+			for _, path := range w.UpdateMask.FieldPaths {
+				if v, ok := fieldAt(update.Fields, path); ok {
+					setFieldAt(next.Fields, path, v)
+				} else {
+					deleteFieldAt(next.Fields, path)
+				}
+			}
+		}
+		for _, t := range w.UpdateTransforms {
+			if err := applyTransform(next.Fields, t, now); err != nil {
+				return nil, err
+			}
+		}
+		docs[name] = next
+		resp.WriteResults = append(resp.WriteResults, &pb.WriteResult{UpdateTime: now})
+	}
+
+	f.docs = docs
+	return resp, nil
+}
+
+func checkPrecondition(pre *pb.Precondition, current *pb.Document) error {
+	if pre == nil {
+		return nil
+	}
+	switch c := pre.ConditionType.(type) {
+	case *pb.Precondition_Exists:
+		if c.Exists && current == nil {
+			return status.Error(codes.NotFound, "no document to update")
+		}
+		if !c.Exists && current != nil {
+			return status.Error(codes.AlreadyExists, "document already exists")
+		}
+	case *pb.Precondition_UpdateTime:
+		if current == nil {
+			return status.Error(codes.NotFound, "no document to update")
+		}
+		if !proto.Equal(current.UpdateTime, c.UpdateTime) {
+			return status.Error(codes.FailedPrecondition, "document was updated since")
+		}
+	}
+	return nil
+}
+
+func applyTransform(fields map[string]*pb.Value, t *pb.DocumentTransform_FieldTransform, now *timestamppb.Timestamp) error {
+	switch tt := t.TransformType.(type) {
+	case *pb.DocumentTransform_FieldTransform_Increment:
+		old, _ := fieldAt(fields, t.FieldPath)
+		var sum int64
+		if iv, ok := old.GetValueType().(*pb.Value_IntegerValue); ok {
+			sum = iv.IntegerValue
+		}
+		sum += tt.Increment.GetIntegerValue()
+		setFieldAt(fields, t.FieldPath, &pb.Value{ValueType: &pb.Value_IntegerValue{IntegerValue: sum}})
+	case *pb.DocumentTransform_FieldTransform_SetToServerValue:
+		setFieldAt(fields, t.FieldPath, &pb.Value{ValueType: &pb.Value_TimestampValue{TimestampValue: now}})
+	default:
+		return status.Error(codes.Unimplemented, "fake firestore doesn't support this transform")
+	}
+	return nil
+}
+
+func (f *fakeFirestore) RunQuery(req *pb.RunQueryRequest, stream pb.Firestore_RunQueryServer) error {
+	q := req.GetStructuredQuery()
+	if q == nil || len(q.From) != 1 || q.From[0].AllDescendants {
+		return status.Error(codes.Unimplemented, "fake firestore only queries single collections")
+	}
+
+	f.mu.Lock()
+	if err := f.injected("RunQuery"); err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	var docs []*pb.Document
+	for name, d := range f.docs {
+		if inCollection(name, req.Parent, q.From[0].CollectionId) && matchesFilter(d, q.Where) {
+			docs = append(docs, proto.Clone(d).(*pb.Document))
+		}
+	}
+	readTime := timestamppb.New(f.now)
+	f.mu.Unlock()
+
+	orders := q.OrderBy
+	sort.Slice(docs, func(i, j int) bool { return compareDocs(docs[i], docs[j], orders) < 0 })
+
+	var results []*pb.Document
+	for _, d := range docs {
+		if q.StartAt != nil && !pastCursor(d, q.StartAt, orders, true) {
+			continue
+		}
+		if q.EndAt != nil && pastCursor(d, q.EndAt, orders, false) {
+			continue
+		}
+		results = append(results, d)
+	}
+	if q.Offset > 0 {
+		if int(q.Offset) >= len(results) {
+			results = nil
+		} else {
+			results = results[q.Offset:]
+		}
+	}
+	if q.Limit != nil && int(q.Limit.Value) < len(results) {
+		results = results[:q.Limit.Value]
+	}
+
+	if len(results) == 0 {
+		return stream.Send(&pb.RunQueryResponse{ReadTime: readTime})
+	}
+	for _, d := range results {
+		if err := stream.Send(&pb.RunQueryResponse{Document: d, ReadTime: readTime}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeFirestore) ListDocuments(ctx context.Context, req *pb.ListDocumentsRequest) (*pb.ListDocumentsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.injected("ListDocuments"); err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ListDocumentsResponse{}
+	for name, d := range f.docs {
+		if inCollection(name, req.Parent, req.CollectionId) {
+			resp.Documents = append(resp.Documents, proto.Clone(d).(*pb.Document))
+		}
+	}
+	sort.Slice(resp.Documents, func(i, j int) bool { return resp.Documents[i].Name < resp.Documents[j].Name })
+	return resp, nil
+}
+
+// inCollection reports whether the document name is directly in the
+// collection id under parent.
+func inCollection(name, parent, id string) bool {
+	prefix := parent + "/" + id + "/"
+	return strings.HasPrefix(name, prefix) && !strings.Contains(name[len(prefix):], "/")
+}
+
+func splitFieldPath(path string) []string {
+	parts := strings.Split(path, ".")
+	for i, p := range parts {
+		parts[i] = strings.Trim(p, "`")
+	}
+	return parts
+}
+
+func fieldAt(fields map[string]*pb.Value, path string) (*pb.Value, bool) {
+	parts := splitFieldPath(path)
+	for i, p := range parts {
+		v, ok := fields[p]
+		if !ok {
+			return nil, false
+		}
+		if i == len(parts)-1 {
+			return v, true
+		}
+		m := v.GetMapValue()
+		if m == nil {
+			return nil, false
+		}
+		fields = m.Fields
+	}
+	return nil, false
+}
+
+func setFieldAt(fields map[string]*pb.Value, path string, v *pb.Value) {
+	parts := splitFieldPath(path)
+	for _, p := range parts[:len(parts)-1] {
+		next := fields[p].GetMapValue()
+		if next == nil {
+			next = &pb.MapValue{Fields: map[string]*pb.Value{}}
+			fields[p] = &pb.Value{ValueType: &pb.Value_MapValue{MapValue: next}}
+		}
+		if next.Fields == nil {
+			next.Fields = map[string]*pb.Value{}
+		}
+		fields = next.Fields
+	}
+	fields[parts[len(parts)-1]] = v
+}
+
+func deleteFieldAt(fields map[string]*pb.Value, path string) {
+	parts := splitFieldPath(path)
+	for _, p := range parts[:len(parts)-1] {
+		next := fields[p].GetMapValue()
+		if next == nil {
+			return
+		}
+		fields = next.Fields
+	}
+	delete(fields, parts[len(parts)-1])
+}
+
+func docValue(d *pb.Document, path string) (*pb.Value, bool) {
+	if path == firestore.DocumentID {
+		return &pb.Value{ValueType: &pb.Value_ReferenceValue{ReferenceValue: d.Name}}, true
+	}
+	return fieldAt(d.Fields, path)
+}
+
+func matchesFilter(d *pb.Document, filter *pb.StructuredQuery_Filter) bool {
+	if filter == nil {
+		return true
+	}
+	switch ft := filter.FilterType.(type) {
+	case *pb.StructuredQuery_Filter_CompositeFilter:
+		for _, sub := range ft.CompositeFilter.Filters {
+			if !matchesFilter(d, sub) {
+				return false
+			}
+		}
+		return true
+	case *pb.StructuredQuery_Filter_FieldFilter:
+		ff := ft.FieldFilter
+		v, ok := docValue(d, ff.Field.FieldPath)
+		if !ok {
+			return false
+		}
+		// Firestore only compares values of the same type
+		if typeOrder(v) != typeOrder(ff.Value) {
+			return ff.Op == pb.StructuredQuery_FieldFilter_NOT_EQUAL
+		}
+		c := compareValues(v, ff.Value)
+		switch ff.Op {
+		case pb.StructuredQuery_FieldFilter_EQUAL:
+			return c == 0
+		case pb.StructuredQuery_FieldFilter_NOT_EQUAL:
+			return c != 0
+		case pb.StructuredQuery_FieldFilter_LESS_THAN:
+			return c < 0
+		case pb.StructuredQuery_FieldFilter_LESS_THAN_OR_EQUAL:
+			return c <= 0
+		case pb.StructuredQuery_FieldFilter_GREATER_THAN:
+			return c > 0
+		case pb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL:
+			return c >= 0
+		}
+	case *pb.StructuredQuery_Filter_UnaryFilter:
+		v, ok := docValue(d, ft.UnaryFilter.GetField().FieldPath)
+		switch ft.UnaryFilter.Op {
+		case pb.StructuredQuery_UnaryFilter_IS_NULL:
+			return ok && typeOrder(v) == 0
+		case pb.StructuredQuery_UnaryFilter_IS_NOT_NULL:
+			return ok && typeOrder(v) != 0
+		}
+	}
+	return false
+}
+
+// compareDocs orders documents by orders, then by name as Firestore does.
+func compareDocs(a, b *pb.Document, orders []*pb.StructuredQuery_Order) int {
+	for _, o := range orders {
+		va, _ := docValue(a, o.Field.FieldPath)
+		vb, _ := docValue(b, o.Field.FieldPath)
+		c := compareValues(va, vb)
+		if o.Direction == pb.StructuredQuery_DESCENDING {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a.Name, b.Name)
+}
+
+// pastCursor reports whether d comes after the cursor position, or at it
+// when the cursor is before its values and the position is a start.
+func pastCursor(d *pb.Document, cursor *pb.Cursor, orders []*pb.StructuredQuery_Order, start bool) bool {
+	c := 0
+	for i, v := range cursor.Values {
+		if i >= len(orders) {
+			break
+		}
+		dv, _ := docValue(d, orders[i].Field.FieldPath)
+		c = compareValues(dv, v)
+		if orders[i].Direction == pb.StructuredQuery_DESCENDING {
+			c = -c
+		}
+		if c != 0 {
+			break
+		}
+	}
+	if c != 0 {
+		return c > 0
+	}
+	// at the cursor position
+	if start {
+		return cursor.Before
+	}
+	return !cursor.Before
+}
+
+func typeOrder(v *pb.Value) int {
+	switch v.GetValueType().(type) {
+	case nil, *pb.Value_NullValue:
+		return 0
+	case *pb.Value_BooleanValue:
+		return 1
+	case *pb.Value_IntegerValue, *pb.Value_DoubleValue:
+		return 2
+	case *pb.Value_TimestampValue:
+		return 3
+	case *pb.Value_StringValue:
+		return 4
+	case *pb.Value_BytesValue:
+		return 5
+	case *pb.Value_ReferenceValue:
+		return 6
+	case *pb.Value_GeoPointValue:
+		return 7
+	case *pb.Value_ArrayValue:
+		return 8
+	default:
+		return 9
+	}
+}
+
+func compareValues(a, b *pb.Value) int {
+	if ta, tb := typeOrder(a), typeOrder(b); ta != tb {
+		return ta - tb
+	}
+	switch av := a.GetValueType().(type) {
+	case *pb.Value_BooleanValue:
+		return boolInt(av.BooleanValue) - boolInt(b.GetBooleanValue())
+	case *pb.Value_IntegerValue, *pb.Value_DoubleValue:
+		x, y := number(a), number(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case *pb.Value_TimestampValue:
+		x, y := av.TimestampValue.AsTime(), b.GetTimestampValue().AsTime()
+		switch {
+		case x.Before(y):
+			return -1
+		case x.After(y):
+			return 1
+		}
+		return 0
+	case *pb.Value_StringValue:
+		return strings.Compare(av.StringValue, b.GetStringValue())
+	case *pb.Value_BytesValue:
+		return bytes.Compare(av.BytesValue, b.GetBytesValue())
+	case *pb.Value_ReferenceValue:
+		return strings.Compare(av.ReferenceValue, b.GetReferenceValue())
+	}
+	if proto.Equal(a, b) {
+		return 0
+	}
+	return strings.Compare(a.String(), b.String())
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func number(v *pb.Value) float64 {
+	if iv, ok := v.GetValueType().(*pb.Value_IntegerValue); ok {
+		return float64(iv.IntegerValue)
+	}
+	return v.GetDoubleValue()
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+)
+
+// MemoryCohortRepository keeps cohorts in process memory, alongside
+// MemoryUserRepository.
+type MemoryCohortRepository struct {
+	logger zerolog.Logger
+	users  *MemoryUserRepository
+
+	mu      sync.RWMutex
+	cohorts map[string]model.Cohort
+	seats   map[string]*model.Seats
+}
+
+var _ CohortRepositoryInterface = (*MemoryCohortRepository)(nil)
+
+func NewMemoryCohortRepository(logger zerolog.Logger, users *MemoryUserRepository) *MemoryCohortRepository {
+	return &MemoryCohortRepository{
+		logger:  logger,
+		users:   users,
+		cohorts: make(map[string]model.Cohort),
+		seats:   make(map[string]*model.Seats),
+	}
+}
+
+func (m *MemoryCohortRepository) SaveCohort(ctx context.Context, cohort model.Cohort) (*model.Cohort, error) {
+	m.logger.Debug().Msgf("Memory: saving cohort: %s", cohort.ID)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cohorts[cohort.ID] = cohort
+	return &cohort, nil
+}
+
+func (m *MemoryCohortRepository) GetCohort(ctx context.Context, id string) (*model.Cohort, error) {
+	m.logger.Debug().Msgf("Memory: getting cohort: %s", id)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cohort, ok := m.cohorts[id]
+	if !ok {
+		return nil, errors.New("Cohort Not Found", 404)
+	}
+	return &cohort, nil
+}
+
+func (m *MemoryCohortRepository) OpenCohort(ctx context.Context, t time.Time) (*model.Cohort, error) {
+	cohorts, err := m.ListCohorts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return openCohort(cohorts, t)
+}
+
+func (m *MemoryCohortRepository) ListCohorts(ctx context.Context) ([]model.Cohort, error) {
+	m.logger.Debug().Msg("Memory: listing cohorts")
+
+	m.mu.RLock()
+	cohorts := make([]model.Cohort, 0, len(m.cohorts))
+	for _, c := range m.cohorts {
+		cohorts = append(cohorts, c)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(cohorts, func(i, j int) bool {
+		return cohorts[i].StartDate.Before(cohorts[j].StartDate)
+	})
+	return cohorts, nil
+}
+
+func (m *MemoryCohortRepository) ReserveSeat(ctx context.Context, cohort model.Cohort, track string) (bool, error) {
+	m.logger.Debug().Msgf("Memory: reserving seat on %s in cohort: %s", track, cohort.ID)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seats := m.seatsOf(cohort.ID)
+	if !cohort.HasSeat(track, *seats) {
+		return false, nil
+	}
+	seats.Total++
+	seats.Tracks[track]++
+	return true, nil
+}
+
+func (m *MemoryCohortRepository) ReleaseSeat(ctx context.Context, cohortID, track string) error {
+	m.logger.Debug().Msgf("Memory: releasing seat on %s in cohort: %s", track, cohortID)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	releaseSeat(m.seatsOf(cohortID), track)
+	return nil
+}
+
+func (m *MemoryCohortRepository) ReconcileSeats(ctx context.Context, cohortID string) (*model.Seats, error) {
+	m.logger.Debug().Msgf("Memory: reconciling seats of cohort: %s", cohortID)
+
+	m.mu.Lock()
+	seats := &model.Seats{Tracks: make(map[string]int)}
+	m.users.mu.RLock()
+	for _, user := range m.users.users {
+		if user.Enrolled && user.CohortID == cohortID {
+			seats.Total++
+			seats.Tracks[user.LearningTrack]++
+		}
+	}
+	m.users.mu.RUnlock()
+	m.seats[cohortID] = seats
+	m.mu.Unlock()
+
+	return m.GetSeats(ctx, cohortID)
+}
+
+func (m *MemoryCohortRepository) GetSeats(ctx context.Context, cohortID string) (*model.Seats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seats := *m.seatsOf(cohortID)
+	seats.Tracks = make(map[string]int, len(seats.Tracks))
+	for track, n := range m.seats[cohortID].Tracks {
+		seats.Tracks[track] = n
+	}
+	return &seats, nil
+}
+
+// seatsOf must be called with mu held.
+func (m *MemoryCohortRepository) seatsOf(cohortID string) *model.Seats {
+	seats, ok := m.seats[cohortID]
+	if !ok {
+		seats = &model.Seats{Tracks: make(map[string]int)}
+		m.seats[cohortID] = seats
+	}
+	return seats
+}
@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+)
+
+// MemoryUserRepository keeps users in process memory. It mirrors the
+// behaviour of UserRepository and is meant for local development and tests.
+type MemoryUserRepository struct {
+	logger zerolog.Logger
+	keys   model.EmailPolicy
+
+	mu         sync.RWMutex
+	users      map[string]model.User
+	history    map[string][]model.UserChange
+	emails     map[string][]model.EmailRecord
+	tombstones map[string]model.Tombstone
+}
+
+var _ UserRepositoryInterface = (*MemoryUserRepository)(nil)
+
+func NewMemoryUserRepository(logger zerolog.Logger, keys model.EmailPolicy) *MemoryUserRepository {
+	return &MemoryUserRepository{
+		logger:     logger,
+		keys:       keys,
+		users:      make(map[string]model.User),
+		history:    make(map[string][]model.UserChange),
+		emails:     make(map[string][]model.EmailRecord),
+		tombstones: make(map[string]model.Tombstone),
+	}
+}
+
+func (m *MemoryUserRepository) CreateUser(ctx context.Context, user model.User) (*model.User, error) {
+	m.logger.Debug().Msgf("Memory: creating user with email: %s", user.Email)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := m.keys.Key(user.Email)
+	if got, ok := m.users[key]; ok {
+		return &got, nil
+	}
+
+	user.Email = strings.TrimSpace(user.Email)
+	user.EmailKey = key
+	user.SchemaVersion = UserSchemaVersion
+	stampCreate(&user, storeTime())
+	m.users[key] = user
+	delete(m.tombstones, m.keys.TombstoneHash(user.Email))
+	return &user, nil
+}
+
+func (m *MemoryUserRepository) UpdateUser(ctx context.Context, user model.User, edit *UserEdit) (*model.User, error) {
+	m.logger.Debug().Msgf("Memory: updating user with email: %s", user.Email)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := m.keys.Key(user.Email)
+	stored, ok := m.users[key]
+	if !ok {
+		return nil, errors.New("User Account Not Found", 404)
+	}
+	if stored.Revision != user.Revision {
+		return nil, errConcurrentUpdate
+	}
+
+	now := storeTime()
+	if err := applyUpdates(&stored, stampUpdate(&user, now)); err != nil {
+		return nil, errors.From(err, "failed to update user data", 500)
+	}
+	stored.Revision++
+	m.users[key] = stored
+	if change := edit.change(user, now); change != nil {
+		m.history[key] = append(m.history[key], *change)
+	}
+
+	user.Revision = stored.Revision
+
+	return &user, nil
+}
+
+func (m *MemoryUserRepository) GetUser(ctx context.Context, email string) (*model.User, error) {
+	m.logger.Debug().Msgf("Memory: getting user with email: %s", email)
+	key := m.keys.Key(email)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[key]
+	if !ok {
+		return nil, errors.New("User Account Not Found", 404)
+	}
+
+	return &user, nil
+}
+
+func (m *MemoryUserRepository) ListUsers(ctx context.Context, filter UserFilter, cursor string, limit int) (*UserPage, error) {
+	m.logger.Debug().Msgf("Memory: listing users with filter: %+v", filter)
+
+	after, err := decodeUserCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	users := make([]model.User, 0, len(m.users))
+	for _, user := range m.users {
+		if filter.matches(user) && (after == nil || after.after(user)) {
+			users = append(users, user)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.Before(users[j].CreatedAt)
+		}
+		return users[i].EmailKey < users[j].EmailKey
+	})
+
+	if len(users) > limit+1 {
+		users = users[:limit+1]
+	}
+	return newUserPage(users, limit), nil
+}
+
+func (m *MemoryUserRepository) DeleteUser(ctx context.Context, email string) (*model.Tombstone, error) {
+	m.logger.Debug().Msgf("Memory: deleting user with email: %s", email)
+	key := m.keys.Key(email)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[key]; !ok {
+		if tombstone, ok := m.tombstones[m.keys.EmailHash(key)]; ok {
+			return &tombstone, nil
+		}
+		return nil, errors.New("User Account Not Found", 404)
+	}
+
+	tombstone := m.keys.NewTombstone(key, time.Now().UTC())
+	delete(m.users, key)
+	delete(m.history, key)
+	delete(m.emails, key)
+	m.tombstones[tombstone.EmailHash] = tombstone
+
+	return &tombstone, nil
+}
+
+func (m *MemoryUserRepository) RecordUserChange(ctx context.Context, email string, change model.UserChange) error {
+	m.logger.Debug().Msgf("Memory: recording change to user with email: %s", email)
+	key := m.keys.Key(email)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.history[key] = append(m.history[key], change)
+	return nil
+}
+
+func (m *MemoryUserRepository) GetUserHistory(ctx context.Context, email string) ([]model.UserChange, error) {
+	m.logger.Debug().Msgf("Memory: getting history of user with email: %s", email)
+	key := m.keys.Key(email)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	history := make([]model.UserChange, len(m.history[key]))
+	copy(history, m.history[key])
+	return history, nil
+}
+
+// GetUserReplicas reports the single in-memory copy as replica "memory".
+func (m *MemoryUserRepository) GetUserReplicas(ctx context.Context, email string) ([]model.ReplicaCopy, error) {
+	m.logger.Debug().Msgf("Memory: getting every copy of user with email: %s", email)
+	key := m.keys.Key(email)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cp := model.ReplicaCopy{Replica: "memory"}
+	if user, ok := m.users[key]; ok {
+		cp.User = &user
+	}
+	return []model.ReplicaCopy{cp}, nil
+}
+
+func (m *MemoryUserRepository) RecordEmail(ctx context.Context, email string, record model.EmailRecord) error {
+	m.logger.Debug().Msgf("Memory: recording email to user with email: %s", email)
+	key := m.keys.Key(email)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.emails[key] = append(m.emails[key], record)
+	return nil
+}
+
+func (m *MemoryUserRepository) GetEmailRecords(ctx context.Context, email string) ([]model.EmailRecord, error) {
+	m.logger.Debug().Msgf("Memory: getting emails to user with email: %s", email)
+	key := m.keys.Key(email)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	records := make([]model.EmailRecord, len(m.emails[key]))
+	copy(records, m.emails[key])
+	return records, nil
+}
@@ -0,0 +1,297 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+)
+
+func TestMemoryUserRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{})
+
+	if _, err := repo.GetUser(ctx, "jane@example.com"); errors.CodeFrom(err) != 404 {
+		t.Fatalf("expected 404 for missing user, got %v", err)
+	}
+
+	if _, err := repo.UpdateUser(ctx, model.User{Email: "jane@example.com"}, nil); errors.CodeFrom(err) != 404 {
+		t.Fatalf("expected 404 updating missing user, got %v", err)
+	}
+
+	created, err := repo.CreateUser(ctx, model.User{Email: "jane@example.com", Name: "Jane Doe"})
+	if err != nil {
+		t.Fatalf("unexpected error creating user: %v", err)
+	}
+	if created.Name != "Jane Doe" {
+		t.Errorf("expected created name 'Jane Doe', got '%s'", created.Name)
+	}
+
+	again, err := repo.CreateUser(ctx, model.User{Email: "jane@example.com", Name: "Someone Else"})
+	if err != nil {
+		t.Fatalf("unexpected error re-creating user: %v", err)
+	}
+	if again.Name != "Jane Doe" {
+		t.Errorf("expected create to return the existing user, got name '%s'", again.Name)
+	}
+
+	_, err = repo.UpdateUser(ctx, model.User{Email: "jane@example.com", Name: "Ignored", City: "Austin", Enrolled: true}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error updating user: %v", err)
+	}
+
+	got, err := repo.GetUser(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error getting user: %v", err)
+	}
+	if got.City != "Austin" || !got.Enrolled {
+		t.Errorf("expected update to set city and enrolled, got %+v", got)
+	}
+	if got.Name != "Jane Doe" {
+		t.Errorf("expected update to leave name untouched, got '%s'", got.Name)
+	}
+}
+
+func TestMemoryListUsers(t *testing.T) {
+	testListUsers(t, NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{}))
+}
+
+// testListUsers checks filtering and paging of any UserRepositoryInterface.
+func testListUsers(t *testing.T, repo UserRepositoryInterface) {
+	t.Helper()
+	ctx := context.Background()
+
+	base := time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC)
+	for i, email := range []string{"e@x.com", "d@x.com", "c@x.com", "b@x.com", "a@x.com"} {
+		user := model.User{Email: email, CreatedAt: base.Add(time.Duration(i/2) * time.Hour)}
+		if _, err := repo.CreateUser(ctx, user); err != nil {
+			t.Fatalf("unexpected error creating user: %v", err)
+		}
+		if i%2 == 0 {
+			user.Enrolled = true
+			if _, err := repo.UpdateUser(ctx, user, nil); err != nil {
+				t.Fatalf("unexpected error updating user: %v", err)
+			}
+		}
+	}
+
+	var (
+		got    []string
+		cursor string
+	)
+	for {
+		page, err := repo.ListUsers(ctx, UserFilter{}, cursor, 2)
+		if err != nil {
+			t.Fatalf("unexpected error listing users: %v", err)
+		}
+		for _, user := range page.Users {
+			got = append(got, user.Email)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if want := "d@x.com,e@x.com,b@x.com,c@x.com,a@x.com"; strings.Join(got, ",") != want {
+		t.Errorf("expected users in order %s, got %s", want, strings.Join(got, ","))
+	}
+
+	enrolled := true
+	page, err := repo.ListUsers(ctx, UserFilter{Enrolled: &enrolled, CreatedAfter: base.Add(time.Hour)}, "", 10)
+	if err != nil {
+		t.Fatalf("unexpected error listing users: %v", err)
+	}
+	if len(page.Users) != 2 || page.Users[0].Email != "c@x.com" || page.Users[1].Email != "a@x.com" {
+		t.Errorf("expected enrolled users c@x.com and a@x.com, got %+v", page.Users)
+	}
+
+	if _, err := repo.ListUsers(ctx, UserFilter{}, "not a cursor", 10); errors.CodeFrom(err) != 400 {
+		t.Errorf("expected 400 for invalid cursor, got %v", err)
+	}
+}
+
+func TestMemoryDeleteUser(t *testing.T) {
+	testDeleteUser(t, NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{}))
+}
+
+func testDeleteUser(t *testing.T, repo UserRepositoryInterface) {
+	t.Helper()
+	ctx := context.Background()
+
+	if _, err := repo.DeleteUser(ctx, "jane@example.com"); errors.CodeFrom(err) != 404 {
+		t.Fatalf("expected 404 deleting missing user, got %v", err)
+	}
+
+	if _, err := repo.CreateUser(ctx, model.User{Email: "jane@example.com", City: "Austin"}); err != nil {
+		t.Fatalf("unexpected error creating user: %v", err)
+	}
+	if err := repo.RecordUserChange(ctx, "jane@example.com", model.UserChange{Actor: "jane@example.com"}); err != nil {
+		t.Fatalf("unexpected error recording change: %v", err)
+	}
+
+	tombstone, err := repo.DeleteUser(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error deleting user: %v", err)
+	}
+	if tombstone.EmailHash != (model.EmailPolicy{}).EmailHash("jane@example.com") {
+		t.Errorf("unexpected tombstone %+v", tombstone)
+	}
+
+	if _, err := repo.GetUser(ctx, "jane@example.com"); errors.CodeFrom(err) != 404 {
+		t.Errorf("expected deleted user to be gone, got %v", err)
+	}
+	if again, err := repo.DeleteUser(ctx, "Jane@Example.com"); err != nil || again.EmailHash != tombstone.EmailHash {
+		t.Errorf("expected erasing again to return the tombstone, got %+v, %v", again, err)
+	}
+	if history, err := repo.GetUserHistory(ctx, "jane@example.com"); err != nil || len(history) != 0 {
+		t.Errorf("expected history to be erased, got %+v, %v", history, err)
+	}
+
+	again, err := repo.CreateUser(ctx, model.User{Email: "jane@example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error re-creating user: %v", err)
+	}
+	if again.City != "" {
+		t.Errorf("expected a fresh user after erasure, got %+v", again)
+	}
+}
+
+func TestMemoryImportUsers(t *testing.T) {
+	testImportUsers(t, NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{}))
+}
+
+func testImportUsers(t *testing.T, repo UserRepositoryInterface) {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, email := range []string{"old@example.com", "erased@example.com"} {
+		if _, err := repo.CreateUser(ctx, model.User{Email: email, City: "Austin"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.DeleteUser(ctx, "erased@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	users := []model.User{
+		{Email: "Jane@example.com", FirstName: "Jane"},
+		{Email: "old@example.com", City: "Dallas"},
+		{Email: "erased@example.com"},
+	}
+	want := []UserImport{UserImported, UserExists, UserErased}
+
+	outcomes, err := repo.ImportUsers(ctx, users, true)
+	if err != nil {
+		t.Fatalf("unexpected error on a dry run: %v", err)
+	}
+	if !reflect.DeepEqual(outcomes, want) {
+		t.Errorf("expected %v on a dry run, got %v", want, outcomes)
+	}
+	if _, err := repo.GetUser(ctx, "jane@example.com"); errors.CodeFrom(err) != 404 {
+		t.Fatalf("expected a dry run to create nobody, got %v", err)
+	}
+
+	outcomes, err = repo.ImportUsers(ctx, users, false)
+	if err != nil {
+		t.Fatalf("unexpected error importing users: %v", err)
+	}
+	if !reflect.DeepEqual(outcomes, want) {
+		t.Errorf("expected %v, got %v", want, outcomes)
+	}
+	if user, err := repo.GetUser(ctx, "jane@example.com"); err != nil || user.FirstName != "Jane" || user.EmailKey != "jane@example.com" {
+		t.Errorf("expected jane to be imported, got %+v, %v", user, err)
+	}
+	if user, err := repo.GetUser(ctx, "old@example.com"); err != nil || user.City != "Austin" {
+		t.Errorf("expected the existing user to be left alone, got %+v, %v", user, err)
+	}
+	if _, err := repo.GetUser(ctx, "erased@example.com"); errors.CodeFrom(err) != 404 {
+		t.Errorf("expected the erased user to stay erased, got %v", err)
+	}
+}
+
+func TestMemoryConcurrentUpdate(t *testing.T) {
+	testConcurrentUpdate(t, NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{}))
+}
+
+// testConcurrentUpdate has two requests read the same user and update it;
+// only the first write may go through.
+func testConcurrentUpdate(t *testing.T, repo UserRepositoryInterface) {
+	t.Helper()
+	ctx := context.Background()
+
+	if _, err := repo.CreateUser(ctx, model.User{Email: "jane@example.com"}); err != nil {
+		t.Fatalf("unexpected error creating user: %v", err)
+	}
+
+	first, err := repo.GetUser(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error getting user: %v", err)
+	}
+	second := *first
+
+	first.Enrolled = true
+	updated, err := repo.UpdateUser(ctx, *first, nil)
+	if err != nil {
+		t.Fatalf("unexpected error updating user: %v", err)
+	}
+
+	second.Enrolled = true
+	if _, err := repo.UpdateUser(ctx, second, nil); errors.CodeFrom(err) != 409 {
+		t.Fatalf("expected 409 updating a stale user, got %v", err)
+	}
+
+	updated.City = "Austin"
+	if _, err := repo.UpdateUser(ctx, *updated, nil); err != nil {
+		t.Fatalf("unexpected error updating with the latest revision: %v", err)
+	}
+
+	if _, err := repo.UpdateUser(ctx, model.User{Email: "nobody@example.com"}, nil); errors.CodeFrom(err) != 404 {
+		t.Fatalf("expected 404 updating missing user, got %v", err)
+	}
+}
+
+func TestMemoryEmailKeys(t *testing.T) {
+	testEmailKeys(t, NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{FoldGmail: true}))
+}
+
+// testEmailKeys checks that differently written addresses of one applicant
+// reach the same user. repo must fold Gmail addresses.
+func testEmailKeys(t *testing.T, repo UserRepositoryInterface) {
+	t.Helper()
+	ctx := context.Background()
+
+	created, err := repo.CreateUser(ctx, model.User{Email: " Jane.Doe@Gmail.com", Name: "Jane Doe"})
+	if err != nil {
+		t.Fatalf("unexpected error creating user: %v", err)
+	}
+	if created.Email != "Jane.Doe@Gmail.com" {
+		t.Errorf("expected the address to be kept as written, got '%s'", created.Email)
+	}
+
+	again, err := repo.CreateUser(ctx, model.User{Email: "janedoe+apply@gmail.com", Name: "Someone Else"})
+	if err != nil {
+		t.Fatalf("unexpected error re-creating user: %v", err)
+	}
+	if again.Name != "Jane Doe" {
+		t.Errorf("expected create to return the existing user, got name '%s'", again.Name)
+	}
+
+	for _, email := range []string{"jane.doe@gmail.com", "JANEDOE@GMAIL.COM", "jane.doe+x@googlemail.com"} {
+		if _, err := repo.GetUser(ctx, email); err != nil {
+			t.Errorf("expected %s to find the user, got %v", email, err)
+		}
+	}
+
+	if err := repo.RecordEmail(ctx, "JaneDoe@gmail.com", model.EmailRecord{Template: "welcome", SentAt: time.Now().UTC()}); err != nil {
+		t.Fatalf("unexpected error recording email: %v", err)
+	}
+	records, err := repo.GetEmailRecords(ctx, "jane.doe@gmail.com")
+	if err != nil || len(records) != 1 {
+		t.Errorf("expected one email record through another spelling, got %d, %v", len(records), err)
+	}
+}
@@ -0,0 +1,216 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"cloud.google.com/go/firestore"
+	"github.com/thealamu/linkedinsignin/model"
+	"google.golang.org/api/iterator"
+)
+
+// WinnerPolicy decides which copy of a conflicting user document is kept
+// when reconciling a secondary replica with the primary.
+type WinnerPolicy string
+
+const (
+	// PrimaryWins always keeps the primary document.
+	PrimaryWins WinnerPolicy = "primary-wins"
+	// NewestWins keeps whichever document was written last.
+	NewestWins WinnerPolicy = "newest"
+)
+
+type (
+	// FieldConflict is a user field whose value differs between replicas.
+	FieldConflict struct {
+		Field     string
+		Primary   interface{}
+		Secondary interface{}
+	}
+
+	UserConflict struct {
+		Email  string
+		Fields []FieldConflict
+	}
+
+	// ReconcileReport compares one secondary replica with the primary.
+	ReconcileReport struct {
+		Replica            string
+		Scanned            int
+		MissingInPrimary   []string
+		MissingInSecondary []string
+		// Erased users are missing in the primary because they were
+		// deleted there; repairing removes them from the secondary too.
+		Erased    []string
+		Conflicts []UserConflict
+		Repaired  int
+	}
+)
+
+// ValidWinnerPolicy reports whether p is a known WinnerPolicy.
+func ValidWinnerPolicy(p WinnerPolicy) bool {
+	return p == PrimaryWins || p == NewestWins
+}
+
+// Reconcile compares the users collection of every secondary with the
+// primary and reports each document that is missing from one side or differs
+// between them. When repair is set, the differences are fixed according to
+// policy; a document that only exists on one side is always copied to the
+// other.
+func (u *UserRepository) Reconcile(ctx context.Context, repair bool, policy WinnerPolicy) ([]*ReconcileReport, error) {
+	if !ValidWinnerPolicy(policy) {
+		return nil, fmt.Errorf("unknown winner policy '%s'", policy)
+	}
+
+	var reports []*ReconcileReport
+	for _, r := range u.secondaries {
+		report, err := u.reconcile(ctx, r, repair, policy)
+		if report != nil {
+			reports = append(reports, report)
+		}
+		if err != nil {
+			return reports, err
+		}
+	}
+	return reports, nil
+}
+
+func (u *UserRepository) reconcile(ctx context.Context, r *replica, repair bool, policy WinnerPolicy) (*ReconcileReport, error) {
+	primary := u.primary.users().OrderBy(firestore.DocumentID, firestore.Asc).Documents(ctx)
+	defer primary.Stop()
+	secondary := r.users().OrderBy(firestore.DocumentID, firestore.Asc).Documents(ctx)
+	defer secondary.Stop()
+
+	report := &ReconcileReport{Replica: r.name}
+
+	a, err := nextDoc(primary)
+	if err != nil {
+		return nil, err
+	}
+	b, err := nextDoc(secondary)
+	if err != nil {
+		return nil, err
+	}
+
+	// both iterators are ordered by document ID, so walk them side by side
+	for a != nil || b != nil {
+		report.Scanned++
+
+		switch {
+		case b == nil || (a != nil && a.Ref.ID < b.Ref.ID):
+			report.MissingInSecondary = append(report.MissingInSecondary, a.Ref.ID)
+			if repair {
+				if err := u.repairFrom(ctx, a, r, report); err != nil {
+					return report, err
+				}
+			}
+			if a, err = nextDoc(primary); err != nil {
+				return report, err
+			}
+
+		case a == nil || b.Ref.ID < a.Ref.ID:
+			erased, err := u.isTombstoned(ctx, b.Ref.ID)
+			if err != nil {
+				return report, err
+			}
+
+			if erased {
+				report.Erased = append(report.Erased, b.Ref.ID)
+				if repair {
+					if _, err := b.Ref.Delete(ctx); err != nil {
+						return report, fmt.Errorf("failed to erase user %s on %s: %w", b.Ref.ID, r.name, err)
+					}
+					report.Repaired++
+				}
+			} else {
+				report.MissingInPrimary = append(report.MissingInPrimary, b.Ref.ID)
+				if repair {
+					if err := u.repairFrom(ctx, b, u.primary, report); err != nil {
+						return report, err
+					}
+				}
+			}
+			if b, err = nextDoc(secondary); err != nil {
+				return report, err
+			}
+
+		default:
+			fields, err := diffUserDocs(a, b)
+			if err != nil {
+				return report, err
+			}
+			if len(fields) > 0 {
+				report.Conflicts = append(report.Conflicts, UserConflict{Email: a.Ref.ID, Fields: fields})
+				if repair {
+					winner, target := a, r
+					if policy == NewestWins && b.UpdateTime.After(a.UpdateTime) {
+						winner, target = b, u.primary
+					}
+					if err := u.repairFrom(ctx, winner, target, report); err != nil {
+						return report, err
+					}
+				}
+			}
+
+			if a, err = nextDoc(primary); err != nil {
+				return report, err
+			}
+			if b, err = nextDoc(secondary); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func (u *UserRepository) repairFrom(ctx context.Context, src *firestore.DocumentSnapshot, target *replica, report *ReconcileReport) error {
+	if _, err := target.users().Doc(src.Ref.ID).Set(ctx, src.Data()); err != nil {
+		return fmt.Errorf("failed to repair user %s on %s: %w", src.Ref.ID, target.name, err)
+	}
+	u.logger.Info().Msgf("Firestore: repaired user %s on %s", src.Ref.ID, target.name)
+	report.Repaired++
+	return nil
+}
+
+// nextDoc returns the next document of iter, or nil when it is exhausted.
+func nextDoc(iter *firestore.DocumentIterator) (*firestore.DocumentSnapshot, error) {
+	doc, err := iter.Next()
+	if err == iterator.Done {
+		return nil, nil
+	}
+	return doc, err
+}
+
+// diffUserDocs compares two user documents field by field, using the
+// firestore tags of model.User.
+func diffUserDocs(a, b *firestore.DocumentSnapshot) ([]FieldConflict, error) {
+	ua, err := userFromDoc(a)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind primary user %s: %w", a.Ref.ID, err)
+	}
+	ub, err := userFromDoc(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind secondary user %s: %w", b.Ref.ID, err)
+	}
+	return diffUsers(ua, ub), nil
+}
+
+func diffUsers(a, b model.User) []FieldConflict {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+
+	var conflicts []FieldConflict
+	for i := 0; i < va.NumField(); i++ {
+		tag := firestoreTag(va.Type().Field(i))
+		if tag == "" {
+			continue
+		}
+
+		fa, fb := va.Field(i).Interface(), vb.Field(i).Interface()
+		if !reflect.DeepEqual(fa, fb) {
+			conflicts = append(conflicts, FieldConflict{Field: tag, Primary: fa, Secondary: fb})
+		}
+	}
+	return conflicts
+}
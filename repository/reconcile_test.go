@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/thealamu/linkedinsignin/model"
+)
+
+func TestDiffUsers(t *testing.T) {
+	a := model.User{Email: "jane@example.com", City: "Austin", Enrolled: true}
+	b := model.User{Email: "jane@example.com", City: "Dallas", Enrolled: true}
+
+	conflicts := diffUsers(a, b)
+	if len(conflicts) != 1 {
+		t.Fatalf("expected 1 conflict, got %d: %+v", len(conflicts), conflicts)
+	}
+	if c := conflicts[0]; c.Field != "city" || c.Primary != "Austin" || c.Secondary != "Dallas" {
+		t.Errorf("unexpected conflict %+v", c)
+	}
+
+	if conflicts := diffUsers(a, a); len(conflicts) != 0 {
+		t.Errorf("expected identical users to have no conflicts, got %+v", conflicts)
+	}
+}
@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// outboxCollection lives in the primary project and holds one document per
+// user and secondary replica whose copy may differ from the primary.
+const outboxCollection = "replica_outbox"
+
+const (
+	replicaWritePending = "pending"
+	replicaWriteFailing = "failing"
+)
+
+// ReplicaWrite is committed in the same batch as the primary write it
+// describes, so a failure between projects can never go unnoticed. It is
+// deleted once the replica has been brought in line with the primary.
+type ReplicaWrite struct {
+	Replica       string    `firestore:"replica"`
+	Email         string    `firestore:"email"`
+	Op            string    `firestore:"op"`
+	Status        string    `firestore:"status"`
+	Attempts      int       `firestore:"attempts"`
+	LastError     string    `firestore:"last_error"`
+	CreatedAt     time.Time `firestore:"created_at"`
+	LastAttemptAt time.Time `firestore:"last_attempt_at"`
+}
+
+func outboxID(replica, email string) string {
+	return fmt.Sprintf("%s:%s", replica, email)
+}
+
+// queueReplicaWrites adds an outbox entry for every secondary to batch.
+func (u *UserRepository) queueReplicaWrites(batch *firestore.WriteBatch, email, op string) {
+	for _, r := range u.secondaries {
+		batch.Set(u.primary.client.Collection(outboxCollection).Doc(outboxID(r.name, email)), ReplicaWrite{
+			Replica:   r.name,
+			Email:     email,
+			Op:        op,
+			Status:    replicaWritePending,
+			CreatedAt: time.Now().UTC(),
+		})
+	}
+}
+
+// replicate tries to bring every secondary up to date for email straight
+// away. A failure is only logged, the write stays in the outbox for
+// SyncReplicas.
+func (u *UserRepository) replicate(ctx context.Context, email string) {
+	for _, r := range u.secondaries {
+		if err := u.syncReplica(ctx, r, email); err != nil {
+			u.logger.Warn().Err(err).Msgf("Firestore: write of %s to %s is pending, will retry", email, r.name)
+		}
+	}
+}
+
+func (u *UserRepository) syncReplica(ctx context.Context, r *replica, email string) error {
+	ref := u.primary.client.Collection(outboxCollection).Doc(outboxID(r.name, email))
+	entry, err := ref.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := u.copyToReplica(ctx, r, email); err != nil {
+		u.recordReplicaFailure(ctx, entry, err)
+		return err
+	}
+
+	// only clear the entry if no newer write has been queued since we read it
+	_, err = ref.Delete(ctx, firestore.LastUpdateTime(entry.UpdateTime))
+	if status.Code(err) == codes.FailedPrecondition {
+		return nil
+	}
+	return err
+}
+
+// copyToReplica overwrites the replica's document for email with the current
+// primary document, so retries are idempotent and always converge.
+func (u *UserRepository) copyToReplica(ctx context.Context, r *replica, email string) error {
+	snap, err := u.primary.users().Doc(email).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		_, err := r.users().Doc(email).Delete(ctx)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = r.users().Doc(email).Set(ctx, snap.Data())
+	return err
+}
+
+func (u *UserRepository) recordReplicaFailure(ctx context.Context, entry *firestore.DocumentSnapshot, cause error) {
+	_, err := entry.Ref.Update(ctx, []firestore.Update{
+		{Path: "status", Value: replicaWriteFailing},
+		{Path: "attempts", Value: firestore.Increment(1)},
+		{Path: "last_error", Value: cause.Error()},
+		{Path: "last_attempt_at", Value: time.Now().UTC()},
+	}, firestore.LastUpdateTime(entry.UpdateTime))
+	if err != nil && status.Code(err) != codes.FailedPrecondition {
+		u.logger.Err(err).Msgf("Firestore: failed to record replica failure for %s", entry.Ref.ID)
+	}
+}
+
+// OutOfSyncUsers returns the outstanding replica writes, i.e. the users
+// whose copy on some secondary is not yet known to match the primary.
+func (u *UserRepository) OutOfSyncUsers(ctx context.Context) ([]ReplicaWrite, error) {
+	docs, err := u.primary.client.Collection(outboxCollection).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	writes := make([]ReplicaWrite, 0, len(docs))
+	for _, doc := range docs {
+		var w ReplicaWrite
+		if err := doc.DataTo(&w); err != nil {
+			return nil, err
+		}
+		writes = append(writes, w)
+	}
+	return writes, nil
+}
+
+// SyncReplicas retries every outstanding replica write once.
+func (u *UserRepository) SyncReplicas(ctx context.Context) error {
+	writes, err := u.OutOfSyncUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	replicas := make(map[string]*replica, len(u.secondaries))
+	for _, r := range u.secondaries {
+		replicas[r.name] = r
+	}
+
+	failed := 0
+	for _, w := range writes {
+		r, ok := replicas[w.Replica]
+		if !ok {
+			// the replica has been retired, nothing left to sync
+			u.logger.Warn().Msgf("Firestore: dropping write of %s to unknown replica %s", w.Email, w.Replica)
+			if _, err := u.primary.client.Collection(outboxCollection).Doc(outboxID(w.Replica, w.Email)).Delete(ctx); err != nil {
+				u.logger.Err(err).Msgf("Firestore: failed to drop write of %s to %s", w.Email, w.Replica)
+			}
+			continue
+		}
+
+		if err := u.syncReplica(ctx, r, w.Email); err != nil {
+			failed++
+			u.logger.Warn().Err(err).Msgf("Firestore: write of %s to %s failed again (attempt %d)", w.Email, w.Replica, w.Attempts+1)
+		}
+	}
+
+	if failed > 0 {
+		u.logger.Warn().Msgf("Firestore: %d user writes still out of sync with the primary", failed)
+	}
+	return nil
+}
+
+// StartReplicaSync calls SyncReplicas every interval until stop is called.
+// stop waits for a sync in progress to give up before returning.
+func (u *UserRepository) StartReplicaSync(interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := u.SyncReplicas(ctx); err != nil && ctx.Err() == nil {
+					u.logger.Err(err).Msg("Firestore: failed to read replica outbox")
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/thealamu/linkedinsignin/model"
+	"google.golang.org/grpc/codes"
+)
+
+func TestReplicaWriteRetried(t *testing.T) {
+	ctx := context.Background()
+	u, fakes := newFakeUserRepository(t, 1)
+	primary, secondary := fakes[0], fakes[1]
+
+	// the secondary refuses the first copy, after the primary has committed
+	secondary.fail("Commit", codes.Internal, 1)
+	if _, err := u.CreateUser(ctx, model.User{Email: "jane@example.com", FirstName: "Jane"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if secondary.doc("users/jane@example.com") != nil {
+		t.Fatal("expected the failed copy to be missing from the secondary")
+	}
+	writes, err := u.OutOfSyncUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(writes) != 1 {
+		t.Fatalf("expected 1 outstanding write, got %+v", writes)
+	}
+	if w := writes[0]; w.Replica != "secondary1" || w.Status != replicaWriteFailing || w.Attempts != 1 || w.LastError == "" {
+		t.Errorf("unexpected outstanding write %+v", w)
+	}
+
+	if err := u.SyncReplicas(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if primary.count(outboxCollection) != 0 {
+		t.Error("expected the outbox to be empty after the retry")
+	}
+	doc := secondary.doc("users/jane@example.com")
+	if doc == nil {
+		t.Fatal("expected the retry to copy the user to the secondary")
+	}
+	if name := doc.Fields["first_name"].GetStringValue(); name != "Jane" {
+		t.Errorf("expected the secondary copy to be Jane, got %q", name)
+	}
+}
+
+func TestStartReplicaSyncStops(t *testing.T) {
+	u, _ := newFakeUserRepository(t, 1)
+
+	stop := u.StartReplicaSync(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected stop to return once the sync has stopped")
+	}
+}
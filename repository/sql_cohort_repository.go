@@ -0,0 +1,291 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+)
+
+// SQLCohortRepository keeps cohorts in the same database as
+// SQLUserRepository. The tracks are stored comma separated and their
+// capacities as JSON.
+type SQLCohortRepository struct {
+	logger zerolog.Logger
+	db     *sql.DB
+}
+
+var _ CohortRepositoryInterface = (*SQLCohortRepository)(nil)
+
+// NewSQLCohortRepository expects db to have been migrated by
+// NewSQLUserRepository.
+func NewSQLCohortRepository(logger zerolog.Logger, db *sql.DB) *SQLCohortRepository {
+	return &SQLCohortRepository{
+		logger: logger,
+		db:     db,
+	}
+}
+
+const cohortColumns = `id, name, start_date, enrollment_open, enrollment_close, capacity, tracks, track_capacity`
+
+func (s *SQLCohortRepository) SaveCohort(ctx context.Context, cohort model.Cohort) (*model.Cohort, error) {
+	s.logger.Debug().Msgf("SQL: saving cohort: %s", cohort.ID)
+
+	trackCapacity, err := json.Marshal(cohort.TrackCapacity)
+	if err != nil {
+		return nil, errors.From(err, "failed to save cohort", 500)
+	}
+
+	_, err = s.db.ExecContext(ctx, `INSERT INTO cohorts (`+cohortColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (id) DO UPDATE SET
+			name = excluded.name,
+			start_date = excluded.start_date,
+			enrollment_open = excluded.enrollment_open,
+			enrollment_close = excluded.enrollment_close,
+			capacity = excluded.capacity,
+			tracks = excluded.tracks,
+			track_capacity = excluded.track_capacity`,
+		cohort.ID, cohort.Name, cohort.StartDate.UTC(), cohort.EnrollmentOpen.UTC(), cohort.EnrollmentClose.UTC(),
+		cohort.Capacity, strings.Join(cohort.Tracks, ","), string(trackCapacity),
+	)
+	if err != nil {
+		return nil, errors.From(err, "failed to save cohort", 500)
+	}
+
+	return &cohort, nil
+}
+
+func (s *SQLCohortRepository) GetCohort(ctx context.Context, id string) (*model.Cohort, error) {
+	s.logger.Debug().Msgf("SQL: getting cohort: %s", id)
+
+	cohort, err := scanCohort(s.db.QueryRowContext(ctx, `SELECT `+cohortColumns+` FROM cohorts WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("Cohort Not Found", 404)
+	}
+	if err != nil {
+		return nil, errors.From(err, "failed to get cohort", 500)
+	}
+
+	return cohort, nil
+}
+
+func (s *SQLCohortRepository) OpenCohort(ctx context.Context, t time.Time) (*model.Cohort, error) {
+	s.logger.Debug().Msgf("SQL: getting cohort open at: %s", t)
+
+	cohort, err := scanCohort(s.db.QueryRowContext(ctx, `SELECT `+cohortColumns+` FROM cohorts
+		WHERE enrollment_open <= $1 AND enrollment_close > $1
+		ORDER BY start_date LIMIT 1`, t.UTC()))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("No Cohort is Open for Enrollment", 404)
+	}
+	if err != nil {
+		return nil, errors.From(err, "failed to get open cohort", 500)
+	}
+
+	return cohort, nil
+}
+
+func (s *SQLCohortRepository) ListCohorts(ctx context.Context) ([]model.Cohort, error) {
+	s.logger.Debug().Msg("SQL: listing cohorts")
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+cohortColumns+` FROM cohorts ORDER BY start_date`)
+	if err != nil {
+		return nil, errors.From(err, "failed to list cohorts", 500)
+	}
+	defer rows.Close()
+
+	cohorts := []model.Cohort{}
+	for rows.Next() {
+		cohort, err := scanCohort(rows)
+		if err != nil {
+			return nil, errors.From(err, "failed to bind cohort data", 500)
+		}
+		cohorts = append(cohorts, *cohort)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.From(err, "failed to list cohorts", 500)
+	}
+
+	return cohorts, nil
+}
+
+// ReserveSeat relies on the conditional updates of the track and the cohort
+// counters, which can't both go through unless seats are left on both. The
+// seats of the track are counted in cohort_seats, those of the whole cohort
+// in cohort_seat_totals.
+func (s *SQLCohortRepository) ReserveSeat(ctx context.Context, cohort model.Cohort, track string) (bool, error) {
+	s.logger.Debug().Msgf("SQL: reserving seat on %s in cohort: %s", track, cohort.ID)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, errors.From(err, "failed to reserve seat", 500)
+	}
+	defer tx.Rollback()
+
+	for _, counter := range []struct {
+		insert, update string
+		args           []interface{}
+		capacity       int
+	}{
+		{
+			insert:   `INSERT INTO cohort_seats (cohort_id, track, taken) VALUES ($1, $2, 0) ON CONFLICT (cohort_id, track) DO NOTHING`,
+			update:   `UPDATE cohort_seats SET taken = taken + 1 WHERE cohort_id = $1 AND track = $2`,
+			args:     []interface{}{cohort.ID, track},
+			capacity: cohort.TrackCapacity[track],
+		},
+		{
+			insert:   `INSERT INTO cohort_seat_totals (cohort_id, taken) VALUES ($1, 0) ON CONFLICT (cohort_id) DO NOTHING`,
+			update:   `UPDATE cohort_seat_totals SET taken = taken + 1 WHERE cohort_id = $1`,
+			args:     []interface{}{cohort.ID},
+			capacity: cohort.Capacity,
+		},
+	} {
+		if _, err := tx.ExecContext(ctx, counter.insert, counter.args...); err != nil {
+			return false, errors.From(err, "failed to reserve seat", 500)
+		}
+
+		query, args := counter.update, counter.args
+		if counter.capacity > 0 {
+			query += fmt.Sprintf(` AND taken < $%d`, len(args)+1)
+			args = append(args, counter.capacity)
+		}
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return false, errors.From(err, "failed to reserve seat", 500)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return false, errors.From(err, "failed to reserve seat", 500)
+		}
+		if n == 0 {
+			return false, nil
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, errors.From(err, "failed to reserve seat", 500)
+	}
+	return true, nil
+}
+
+func (s *SQLCohortRepository) ReleaseSeat(ctx context.Context, cohortID, track string) error {
+	s.logger.Debug().Msgf("SQL: releasing seat on %s in cohort: %s", track, cohortID)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.From(err, "failed to release seat", 500)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE cohort_seats SET taken = taken - 1
+		WHERE cohort_id = $1 AND track = $2 AND taken > 0`, cohortID, track); err != nil {
+		return errors.From(err, "failed to release seat", 500)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE cohort_seat_totals SET taken = taken - 1
+		WHERE cohort_id = $1 AND taken > 0`, cohortID); err != nil {
+		return errors.From(err, "failed to release seat", 500)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.From(err, "failed to release seat", 500)
+	}
+	return nil
+}
+
+// ReconcileSeats replaces the counters of the cohort with the counts of its
+// enrolled users.
+func (s *SQLCohortRepository) ReconcileSeats(ctx context.Context, cohortID string) (*model.Seats, error) {
+	s.logger.Debug().Msgf("SQL: reconciling seats of cohort: %s", cohortID)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.From(err, "failed to reconcile seats", 500)
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM cohort_seats WHERE cohort_id = $1`,
+		`DELETE FROM cohort_seat_totals WHERE cohort_id = $1`,
+		`INSERT INTO cohort_seats (cohort_id, track, taken)
+			SELECT cohort_id, learning_track, COUNT(*) FROM users
+			WHERE enrolled AND cohort_id = $1 GROUP BY cohort_id, learning_track`,
+		`INSERT INTO cohort_seat_totals (cohort_id, taken)
+			SELECT cohort_id, COUNT(*) FROM users
+			WHERE enrolled AND cohort_id = $1 GROUP BY cohort_id`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, cohortID); err != nil {
+			return nil, errors.From(err, "failed to reconcile seats", 500)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.From(err, "failed to reconcile seats", 500)
+	}
+	return s.GetSeats(ctx, cohortID)
+}
+
+func (s *SQLCohortRepository) GetSeats(ctx context.Context, cohortID string) (*model.Seats, error) {
+	s.logger.Debug().Msgf("SQL: getting seats of cohort: %s", cohortID)
+
+	seats := &model.Seats{Tracks: make(map[string]int)}
+	err := s.db.QueryRowContext(ctx, `SELECT taken FROM cohort_seat_totals WHERE cohort_id = $1`, cohortID).Scan(&seats.Total)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.From(err, "failed to get seats", 500)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT track, taken FROM cohort_seats WHERE cohort_id = $1`, cohortID)
+	if err != nil {
+		return nil, errors.From(err, "failed to get seats", 500)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			track string
+			taken int
+		)
+		if err := rows.Scan(&track, &taken); err != nil {
+			return nil, errors.From(err, "failed to bind seats", 500)
+		}
+		seats.Tracks[track] = taken
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.From(err, "failed to get seats", 500)
+	}
+
+	return seats, nil
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCohort(row rowScanner) (*model.Cohort, error) {
+	var (
+		cohort        model.Cohort
+		tracks        string
+		trackCapacity string
+	)
+	err := row.Scan(&cohort.ID, &cohort.Name, &cohort.StartDate, &cohort.EnrollmentOpen, &cohort.EnrollmentClose,
+		&cohort.Capacity, &tracks, &trackCapacity)
+	if err != nil {
+		return nil, err
+	}
+
+	if tracks != "" {
+		cohort.Tracks = strings.Split(tracks, ",")
+	}
+	if trackCapacity != "" {
+		if err := json.Unmarshal([]byte(trackCapacity), &cohort.TrackCapacity); err != nil {
+			return nil, err
+		}
+	}
+	return &cohort, nil
+}
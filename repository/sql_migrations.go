@@ -0,0 +1,508 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/thealamu/linkedinsignin/linkedin"
+	"github.com/thealamu/linkedinsignin/model"
+	"github.com/thealamu/linkedinsignin/phone"
+	"github.com/thealamu/linkedinsignin/places"
+)
+
+// sqlMigration is one step of the relational schema. Migrations are applied
+// in order and each is recorded in schema_migrations so it only runs once.
+// backfill, when set, runs in the same transaction after the statements for
+// data changes SQL alone can't express portably.
+type sqlMigration struct {
+	version    int
+	name       string
+	statements []string
+	backfill   func(ctx context.Context, tx *sql.Tx) error
+}
+
+// sqlMigrations must only ever be appended to. The statements are kept to
+// the subset of SQL understood by both SQLite and Postgres.
+var sqlMigrations = []sqlMigration{
+	{
+		version: 1,
+		name:    "create users",
+		statements: []string{
+			`CREATE TABLE users (
+				email TEXT NOT NULL,
+				name TEXT NOT NULL DEFAULT '',
+				phone TEXT NOT NULL DEFAULT '',
+				first_name TEXT NOT NULL DEFAULT '',
+				last_name TEXT NOT NULL DEFAULT '',
+				photo TEXT NOT NULL DEFAULT '',
+				linkedin_url TEXT NOT NULL DEFAULT '',
+				representation TEXT NOT NULL DEFAULT '',
+				gender TEXT NOT NULL DEFAULT '',
+				age_group TEXT NOT NULL DEFAULT '',
+				employment_status TEXT NOT NULL DEFAULT '',
+				highest_school TEXT NOT NULL DEFAULT '',
+				optional_major TEXT NOT NULL DEFAULT '',
+				can_work_in_usa TEXT NOT NULL DEFAULT '',
+				learning_track TEXT NOT NULL DEFAULT '',
+				hours_per_week TEXT NOT NULL DEFAULT '',
+				referral TEXT NOT NULL DEFAULT '',
+				referral_other TEXT NOT NULL DEFAULT '',
+				city TEXT NOT NULL DEFAULT '',
+				state TEXT NOT NULL DEFAULT '',
+				professional_experience TEXT NOT NULL DEFAULT '',
+				industries TEXT NOT NULL DEFAULT '',
+				prior_knowledge TEXT NOT NULL DEFAULT '',
+				enrolled BOOLEAN NOT NULL DEFAULT FALSE,
+				created_at TEXT NOT NULL DEFAULT '',
+				gitaccount TEXT NOT NULL DEFAULT '',
+				figmaaccount TEXT NOT NULL DEFAULT '',
+				git_yes TEXT NOT NULL DEFAULT '',
+				figma_yes TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE UNIQUE INDEX users_email_key ON users (email)`,
+		},
+	},
+	{
+		version: 2,
+		name:    "index users by creation",
+		statements: []string{
+			`CREATE INDEX users_created_at_idx ON users (created_at, email)`,
+		},
+	},
+	{
+		version: 3,
+		name:    "create cohorts",
+		statements: []string{
+			`CREATE TABLE cohorts (
+				id TEXT NOT NULL PRIMARY KEY,
+				name TEXT NOT NULL,
+				start_date TIMESTAMP NOT NULL,
+				enrollment_open TIMESTAMP NOT NULL,
+				enrollment_close TIMESTAMP NOT NULL,
+				capacity INTEGER NOT NULL DEFAULT 0,
+				tracks TEXT NOT NULL DEFAULT ''
+			)`,
+			`ALTER TABLE users ADD COLUMN cohort_id TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 4,
+		name:    "create user history",
+		statements: []string{
+			`CREATE TABLE user_history (
+				email TEXT NOT NULL,
+				timestamp TIMESTAMP NOT NULL,
+				actor TEXT NOT NULL,
+				source TEXT NOT NULL,
+				changes TEXT NOT NULL
+			)`,
+			`CREATE INDEX user_history_email_idx ON user_history (email, timestamp)`,
+		},
+	},
+	{
+		version: 5,
+		name:    "create tombstones",
+		statements: []string{
+			`CREATE TABLE tombstones (
+				email_hash TEXT NOT NULL PRIMARY KEY,
+				deleted_at TIMESTAMP NOT NULL
+			)`,
+		},
+	},
+	{
+		version: 6,
+		name:    "create email records",
+		statements: []string{
+			`CREATE TABLE email_records (
+				email TEXT NOT NULL,
+				template TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				status TEXT NOT NULL,
+				error TEXT NOT NULL,
+				sent_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX email_records_email_idx ON email_records (email, sent_at)`,
+		},
+	},
+	{
+		version: 7,
+		name:    "add user revisions",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN revision BIGINT NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 8,
+		name:    "add user schema versions",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN schema_version INTEGER NOT NULL DEFAULT 0`,
+			// rows never held the fields dropped by user migration 1
+			`UPDATE users SET schema_version = 1`,
+		},
+	},
+	{
+		version: 9,
+		name:    "add typed user timestamps",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN created_at_ts TIMESTAMP`,
+			`ALTER TABLE users ADD COLUMN updated_at TIMESTAMP`,
+			`ALTER TABLE users ADD COLUMN enrolled_at TIMESTAMP`,
+		},
+		backfill: backfillUserTimestamps,
+	},
+	{
+		version: 10,
+		name:    "replace created_at with its typed column",
+		statements: []string{
+			`DROP INDEX users_created_at_idx`,
+			`ALTER TABLE users DROP COLUMN created_at`,
+			`ALTER TABLE users RENAME COLUMN created_at_ts TO created_at`,
+			`CREATE INDEX users_created_at_idx ON users (created_at, email)`,
+			`UPDATE users SET schema_version = 2`,
+		},
+	},
+	{
+		version: 11,
+		name:    "add user email keys",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN email_key TEXT NOT NULL DEFAULT ''`,
+		},
+		// keys only fold case here, Gmail folding needs merge-users
+		backfill: func(ctx context.Context, tx *sql.Tx) error {
+			_, err := mergeSQLUsers(ctx, tx, model.EmailPolicy{})
+			return err
+		},
+	},
+	{
+		version: 12,
+		name:    "identify users by email key",
+		statements: []string{
+			`DROP INDEX users_email_key`,
+			`DROP INDEX users_created_at_idx`,
+			`CREATE UNIQUE INDEX users_email_key_idx ON users (email_key)`,
+			`CREATE INDEX users_created_at_idx ON users (created_at, email_key)`,
+		},
+	},
+	{
+		version: 13,
+		name:    "add track capacity and waitlist",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN waitlisted BOOLEAN NOT NULL DEFAULT FALSE`,
+			`ALTER TABLE users ADD COLUMN waitlisted_at TIMESTAMP`,
+			`ALTER TABLE cohorts ADD COLUMN track_capacity TEXT NOT NULL DEFAULT ''`,
+			`CREATE TABLE cohort_seats (
+				cohort_id TEXT NOT NULL,
+				track TEXT NOT NULL,
+				taken INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (cohort_id, track)
+			)`,
+			// seats are taken by whoever enrolled before they were counted
+			`INSERT INTO cohort_seats (cohort_id, track, taken)
+				SELECT cohort_id, learning_track, COUNT(*) FROM users
+				WHERE enrolled AND cohort_id <> '' GROUP BY cohort_id, learning_track`,
+			`INSERT INTO cohort_seats (cohort_id, track, taken)
+				SELECT cohort_id, '*', COUNT(*) FROM users
+				WHERE enrolled AND cohort_id <> '' GROUP BY cohort_id`,
+		},
+	},
+	{
+		version:    14,
+		name:       "add form answers",
+		statements: []string{`ALTER TABLE users ADD COLUMN answers TEXT NOT NULL DEFAULT ''`},
+	},
+	{
+		version: 15,
+		name:    "normalize phone numbers",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN phone_e164 TEXT NOT NULL DEFAULT ''`,
+			`UPDATE users SET schema_version = 3`,
+		},
+		backfill: backfillUserPhones,
+	},
+	{
+		version: 16,
+		name:    "canonical linkedin urls",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN linkedin_slug TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN linkedin_shared BOOLEAN NOT NULL DEFAULT FALSE`,
+			`CREATE INDEX users_linkedin_slug_idx ON users (linkedin_slug)`,
+			`UPDATE users SET schema_version = 4`,
+		},
+		backfill: backfillUserLinkedIn,
+	},
+	{
+		version: 17,
+		name:    "count cohort seats apart from track seats",
+		statements: []string{
+			`CREATE TABLE cohort_seat_totals (
+				cohort_id TEXT NOT NULL PRIMARY KEY,
+				taken INTEGER NOT NULL DEFAULT 0
+			)`,
+			`INSERT INTO cohort_seat_totals (cohort_id, taken)
+				SELECT cohort_id, taken FROM cohort_seats WHERE track = '*'`,
+			`DELETE FROM cohort_seats WHERE track = '*'`,
+			// the totals were kept as a track named "*", so a real track of
+			// that name shared their count and starts over from its users
+			`INSERT INTO cohort_seats (cohort_id, track, taken)
+				SELECT cohort_id, learning_track, COUNT(*) FROM users
+				WHERE enrolled AND cohort_id <> '' AND learning_track = '*' GROUP BY cohort_id, learning_track`,
+		},
+	},
+	{
+		version:    18,
+		name:       "canonical us states",
+		statements: []string{`UPDATE users SET schema_version = 5`},
+		backfill:   backfillUserStates,
+	},
+	{
+		version: 19,
+		name:    "flag waitlisted users sharing a linkedin profile",
+		statements: []string{
+			// migration 16 only counted enrolled users, while
+			// FlagSharedLinkedIn counts the waitlist as well
+			`UPDATE users SET linkedin_shared = TRUE
+				WHERE (enrolled OR waitlisted) AND linkedin_slug IN (
+					SELECT linkedin_slug FROM users WHERE (enrolled OR waitlisted) AND linkedin_slug <> ''
+					GROUP BY linkedin_slug HAVING COUNT(*) > 1
+				)`,
+		},
+	},
+}
+
+// backfillUserTimestamps parses the created_at strings into created_at_ts
+// and starts updated_at from them, as user migration 2 does for Firestore.
+func backfillUserTimestamps(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT email, created_at FROM users`)
+	if err != nil {
+		return err
+	}
+
+	// read everything first, Postgres can't run the updates while the
+	// rows are still open on the same connection
+	created := make(map[string]time.Time)
+	for rows.Next() {
+		var email, createdAt string
+		if err := rows.Scan(&email, &createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		t, err := parseLegacyTimestamp(createdAt)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("user %s: %w", email, err)
+		}
+		created[email] = t
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for email, t := range created {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE users SET created_at_ts = $1, updated_at = $1 WHERE email = $2`, t, email,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillUserPhones normalizes the stored phone numbers as user migration
+// 3 does for Firestore.
+func backfillUserPhones(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT email_key, phone FROM users WHERE phone <> ''`)
+	if err != nil {
+		return err
+	}
+
+	phones := make(map[string]string)
+	for rows.Next() {
+		var key, number string
+		if err := rows.Scan(&key, &number); err != nil {
+			rows.Close()
+			return err
+		}
+		phones[key] = number
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for key, number := range phones {
+		display, e164 := phone.Normalize(number)
+		if e164 == "" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE users SET phone = $1, phone_e164 = $2 WHERE email_key = $3`, display, e164, key,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillUserLinkedIn canonicalizes the stored profile URLs as user
+// migration 4 does for Firestore, then flags the enrolled users who share a
+// profile.
+func backfillUserLinkedIn(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT email_key, linkedin_url FROM users WHERE linkedin_url <> ''`)
+	if err != nil {
+		return err
+	}
+
+	urls := make(map[string]string)
+	for rows.Next() {
+		var key, url string
+		if err := rows.Scan(&key, &url); err != nil {
+			rows.Close()
+			return err
+		}
+		urls[key] = url
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for key, url := range urls {
+		canonical, slug := linkedin.CanonicalProfileURL(url)
+		if slug == "" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE users SET linkedin_url = $1, linkedin_slug = $2 WHERE email_key = $3`, canonical, slug, key,
+		); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET linkedin_shared = TRUE
+		WHERE enrolled AND linkedin_slug IN (
+			SELECT linkedin_slug FROM users WHERE enrolled AND linkedin_slug <> ''
+			GROUP BY linkedin_slug HAVING COUNT(*) > 1
+		)`)
+	return err
+}
+
+// backfillUserStates rewrites the states the gazetteer knows by their code,
+// as user migration 5 does for Firestore.
+func backfillUserStates(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT email_key, state FROM users WHERE state <> ''`)
+	if err != nil {
+		return err
+	}
+
+	states := make(map[string]string)
+	for rows.Next() {
+		var key, state string
+		if err := rows.Scan(&key, &state); err != nil {
+			rows.Close()
+			return err
+		}
+		states[key] = state
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for key, s := range states {
+		state, ok := places.US.State(s)
+		if !ok || state.Code == s {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET state = $1 WHERE email_key = $2`, state.Code, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateSQL brings the schema of db up to date with sqlMigrations.
+func migrateSQL(ctx context.Context, db *sql.DB) error {
+	_, err := MigrateSQL(ctx, db, false)
+	return err
+}
+
+// SQLMigration names a step of the relational schema, for reports.
+type SQLMigration struct {
+	Version int
+	Name    string
+}
+
+// MigrateSQL applies the migrations db has not seen yet and returns them,
+// up to the one that failed. A dry run only returns them.
+func MigrateSQL(ctx context.Context, db *sql.DB, dryRun bool) ([]SQLMigration, error) {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	applied := make(map[int]bool)
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+
+	var pending []SQLMigration
+	for _, m := range sqlMigrations {
+		if applied[m.version] {
+			continue
+		}
+		if !dryRun {
+			if err := applySQLMigration(ctx, db, m); err != nil {
+				return pending, fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+			}
+		}
+		pending = append(pending, SQLMigration{Version: m.version, Name: m.name})
+	}
+	return pending, nil
+}
+
+func applySQLMigration(ctx context.Context, db *sql.DB, m sqlMigration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if m.backfill != nil {
+		if err := m.backfill(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+		m.version, m.name, time.Now().UTC().Format(time.RFC3339),
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
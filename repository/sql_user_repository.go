@@ -0,0 +1,442 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+)
+
+// SQLUserRepository stores users in a relational database through
+// database/sql. Columns are named after the firestore tags of model.User so
+// both backends share one vocabulary.
+type SQLUserRepository struct {
+	logger zerolog.Logger
+	keys   model.EmailPolicy
+	db     *sql.DB
+}
+
+var _ UserRepositoryInterface = (*SQLUserRepository)(nil)
+
+// NewSQLUserRepository migrates db to the latest schema and returns a
+// repository backed by it. Users are identified by the email_key column.
+func NewSQLUserRepository(ctx context.Context, logger zerolog.Logger, keys model.EmailPolicy, db *sql.DB) (*SQLUserRepository, error) {
+	if err := migrateSQL(ctx, db); err != nil {
+		return nil, err
+	}
+
+	return &SQLUserRepository{
+		logger: logger,
+		keys:   keys,
+		db:     db,
+	}, nil
+}
+
+func (s *SQLUserRepository) CreateUser(ctx context.Context, user model.User) (*model.User, error) {
+	s.logger.Debug().Msgf("SQL: creating user with email: %s", user.Email)
+
+	user.Email = strings.TrimSpace(user.Email)
+	user.EmailKey = s.keys.Key(user.Email)
+	user.SchemaVersion = UserSchemaVersion
+	stampCreate(&user, storeTime())
+	columns := userColumns()
+	values := userValues(&user)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.From(err, "failed to create user", 500)
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`INSERT INTO users (%s) VALUES (%s) ON CONFLICT (email_key) DO NOTHING`,
+		strings.Join(columns, ", "), placeholders(1, len(columns)))
+	res, err := tx.ExecContext(ctx, query, values...)
+	if err != nil {
+		return nil, errors.From(err, "failed to create user", 500)
+	}
+
+	// Signing in again after an erasure is a fresh application: the
+	// tombstone goes and nothing from before the erasure comes back.
+	if n, _ := res.RowsAffected(); n > 0 {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM tombstones WHERE email_hash = $1`, s.keys.TombstoneHash(user.Email)); err != nil {
+			return nil, errors.From(err, "failed to create user", 500)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.From(err, "failed to create user", 500)
+	}
+
+	return s.GetUser(ctx, user.Email)
+}
+
+func (s *SQLUserRepository) UpdateUser(ctx context.Context, user model.User, edit *UserEdit) (*model.User, error) {
+	s.logger.Debug().Msgf("SQL: updating user with email: %s", user.Email)
+
+	now := storeTime()
+	updates := stampUpdate(&user, now)
+	sets := make([]string, len(updates))
+	args := make([]interface{}, 0, len(updates)+1)
+	for i, update := range updates {
+		sets[i] = fmt.Sprintf("%s = $%d", update.Path, i+1)
+		args = append(args, update.Value)
+	}
+	args = append(args, s.keys.Key(user.Email), user.Revision)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.From(err, "failed to update user data", 500)
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`UPDATE users SET %s, revision = revision + 1 WHERE email_key = $%d AND revision = $%d`,
+		strings.Join(sets, ", "), len(args)-1, len(args))
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.From(err, "failed to update user data", 500)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, errors.From(err, "failed to update user data", 500)
+	}
+	if n == 0 {
+		tx.Rollback()
+		// either the user is gone or its revision has moved on
+		if _, err := s.GetUser(ctx, user.Email); err != nil {
+			return nil, err
+		}
+		return nil, errConcurrentUpdate
+	}
+
+	if change := edit.change(user, now); change != nil {
+		if err := insertUserChange(ctx, tx, s.keys.Key(user.Email), *change); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.From(err, "failed to update user data", 500)
+	}
+
+	user.Revision++
+	return &user, nil
+}
+
+func (s *SQLUserRepository) GetUser(ctx context.Context, email string) (*model.User, error) {
+	s.logger.Debug().Msgf("SQL: getting user with email: %s", email)
+
+	query := fmt.Sprintf(`SELECT %s FROM users WHERE email_key = $1`, strings.Join(userColumns(), ", "))
+
+	user := model.User{}
+	err := s.db.QueryRowContext(ctx, query, s.keys.Key(email)).Scan(userValues(&user)...)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("User Account Not Found", 404)
+	}
+	if err != nil {
+		return nil, errors.From(err, "failed to get user data", 500)
+	}
+
+	return &user, nil
+}
+
+func (s *SQLUserRepository) ListUsers(ctx context.Context, filter UserFilter, cursor string, limit int) (*UserPage, error) {
+	s.logger.Debug().Msgf("SQL: listing users with filter: %+v", filter)
+
+	after, err := decodeUserCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	conds := filterConditions(filter)
+	if after != nil {
+		conds.add("(created_at > ? OR (created_at = ? AND email_key > ?))", after.CreatedAt, after.CreatedAt, after.Key)
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM users%s ORDER BY created_at, email_key LIMIT %d`,
+		strings.Join(userColumns(), ", "), conds.where(), limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, conds.args...)
+	if err != nil {
+		return nil, errors.From(err, "failed to list users", 500)
+	}
+	defer rows.Close()
+
+	var users []model.User
+	for rows.Next() {
+		user := model.User{}
+		if err := rows.Scan(userValues(&user)...); err != nil {
+			return nil, errors.From(err, "failed to bind user data", 500)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.From(err, "failed to list users", 500)
+	}
+
+	return newUserPage(users, limit), nil
+}
+
+func (s *SQLUserRepository) DeleteUser(ctx context.Context, email string) (*model.Tombstone, error) {
+	s.logger.Debug().Msgf("SQL: deleting user with email: %s", email)
+	key := s.keys.Key(email)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.From(err, "failed to delete user", 500)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE email_key = $1`, key)
+	if err != nil {
+		return nil, errors.From(err, "failed to delete user", 500)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, errors.From(err, "failed to delete user", 500)
+	}
+	if n == 0 {
+		return s.getTombstone(ctx, tx, key)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_history WHERE email = $1`, key); err != nil {
+		return nil, errors.From(err, "failed to delete user history", 500)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM email_records WHERE email = $1`, key); err != nil {
+		return nil, errors.From(err, "failed to delete user emails", 500)
+	}
+
+	tombstone := s.keys.NewTombstone(key, time.Now().UTC())
+	if _, err := tx.ExecContext(ctx, `INSERT INTO tombstones (email_hash, deleted_at) VALUES ($1, $2)
+		ON CONFLICT (email_hash) DO UPDATE SET deleted_at = excluded.deleted_at`,
+		tombstone.EmailHash, tombstone.DeletedAt); err != nil {
+		return nil, errors.From(err, "failed to delete user", 500)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.From(err, "failed to delete user", 500)
+	}
+
+	return &tombstone, nil
+}
+
+// getTombstone returns the tombstone of the user stored under key, or a 404
+// when the user was never erased.
+func (s *SQLUserRepository) getTombstone(ctx context.Context, tx *sql.Tx, key string) (*model.Tombstone, error) {
+	tombstone := model.Tombstone{EmailHash: s.keys.EmailHash(key)}
+	err := tx.QueryRowContext(ctx, `SELECT deleted_at FROM tombstones WHERE email_hash = $1`, tombstone.EmailHash).
+		Scan(&tombstone.DeletedAt)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("User Account Not Found", 404)
+	}
+	if err != nil {
+		return nil, errors.From(err, "failed to get tombstone", 500)
+	}
+	return &tombstone, nil
+}
+
+// RecordUserChange stores the field changes of change as JSON.
+func (s *SQLUserRepository) RecordUserChange(ctx context.Context, email string, change model.UserChange) error {
+	s.logger.Debug().Msgf("SQL: recording change to user with email: %s", email)
+
+	return insertUserChange(ctx, s.db, s.keys.Key(email), change)
+}
+
+// execer is what *sql.DB and *sql.Tx have in common for writes.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertUserChange appends change to the history of the user stored under
+// key.
+func insertUserChange(ctx context.Context, db execer, key string, change model.UserChange) error {
+	changes, err := json.Marshal(change.Changes)
+	if err != nil {
+		return errors.From(err, "failed to record user change", 500)
+	}
+
+	_, err = db.ExecContext(ctx, `INSERT INTO user_history (email, timestamp, actor, source, changes) VALUES ($1, $2, $3, $4, $5)`,
+		key, change.Timestamp.UTC(), change.Actor, change.Source, string(changes))
+	if err != nil {
+		return errors.From(err, "failed to record user change", 500)
+	}
+	return nil
+}
+
+func (s *SQLUserRepository) GetUserHistory(ctx context.Context, email string) ([]model.UserChange, error) {
+	s.logger.Debug().Msgf("SQL: getting history of user with email: %s", email)
+
+	rows, err := s.db.QueryContext(ctx, `SELECT timestamp, actor, source, changes FROM user_history WHERE email = $1 ORDER BY timestamp`, s.keys.Key(email))
+	if err != nil {
+		return nil, errors.From(err, "failed to get user history", 500)
+	}
+	defer rows.Close()
+
+	history := []model.UserChange{}
+	for rows.Next() {
+		var (
+			change  model.UserChange
+			changes string
+		)
+		if err := rows.Scan(&change.Timestamp, &change.Actor, &change.Source, &changes); err != nil {
+			return nil, errors.From(err, "failed to bind user history", 500)
+		}
+		if err := json.Unmarshal([]byte(changes), &change.Changes); err != nil {
+			return nil, errors.From(err, "failed to bind user history", 500)
+		}
+		history = append(history, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.From(err, "failed to get user history", 500)
+	}
+
+	return history, nil
+}
+
+// GetUserReplicas reports the single database copy as replica "sql".
+func (s *SQLUserRepository) GetUserReplicas(ctx context.Context, email string) ([]model.ReplicaCopy, error) {
+	cp := model.ReplicaCopy{Replica: "sql"}
+
+	user, err := s.GetUser(ctx, email)
+	switch {
+	case err == nil:
+		cp.User = user
+	case errors.CodeFrom(err) != 404:
+		cp.Error = err.Error()
+	}
+	return []model.ReplicaCopy{cp}, nil
+}
+
+func (s *SQLUserRepository) RecordEmail(ctx context.Context, email string, record model.EmailRecord) error {
+	s.logger.Debug().Msgf("SQL: recording email to user with email: %s", email)
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO email_records (email, template, subject, status, error, sent_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		s.keys.Key(email), record.Template, record.Subject, record.Status, record.Error, record.SentAt.UTC())
+	if err != nil {
+		return errors.From(err, "failed to record email", 500)
+	}
+	return nil
+}
+
+func (s *SQLUserRepository) GetEmailRecords(ctx context.Context, email string) ([]model.EmailRecord, error) {
+	s.logger.Debug().Msgf("SQL: getting emails to user with email: %s", email)
+
+	rows, err := s.db.QueryContext(ctx, `SELECT template, subject, status, error, sent_at FROM email_records WHERE email = $1 ORDER BY sent_at`, s.keys.Key(email))
+	if err != nil {
+		return nil, errors.From(err, "failed to get email records", 500)
+	}
+	defer rows.Close()
+
+	records := []model.EmailRecord{}
+	for rows.Next() {
+		var record model.EmailRecord
+		if err := rows.Scan(&record.Template, &record.Subject, &record.Status, &record.Error, &record.SentAt); err != nil {
+			return nil, errors.From(err, "failed to bind email record", 500)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.From(err, "failed to get email records", 500)
+	}
+
+	return records, nil
+}
+
+// sqlConditions collects the clauses of a WHERE, numbering their ?
+// placeholders in order.
+type sqlConditions struct {
+	clauses []string
+	args    []interface{}
+}
+
+func (c *sqlConditions) add(expr string, values ...interface{}) {
+	for _, v := range values {
+		c.args = append(c.args, v)
+		expr = strings.Replace(expr, "?", fmt.Sprintf("$%d", len(c.args)), 1)
+	}
+	c.clauses = append(c.clauses, expr)
+}
+
+func (c *sqlConditions) where() string {
+	if len(c.clauses) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(c.clauses, " AND ")
+}
+
+func filterConditions(filter UserFilter) *sqlConditions {
+	conds := &sqlConditions{}
+	if filter.Enrolled != nil {
+		conds.add("enrolled = ?", *filter.Enrolled)
+	}
+	if filter.Waitlisted != nil {
+		conds.add("waitlisted = ?", *filter.Waitlisted)
+	}
+	if filter.LinkedInShared != nil {
+		conds.add("linkedin_shared = ?", *filter.LinkedInShared)
+	}
+	if filter.CohortID != "" {
+		conds.add("cohort_id = ?", filter.CohortID)
+	}
+	if filter.LinkedInSlug != "" {
+		conds.add("linkedin_slug = ?", filter.LinkedInSlug)
+	}
+	if filter.LearningTrack != "" {
+		conds.add("learning_track = ?", filter.LearningTrack)
+	}
+	if filter.State != "" {
+		conds.add("state = ?", filter.State)
+	}
+	if filter.Referral != "" {
+		conds.add("referral = ?", filter.Referral)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		conds.add("created_at >= ?", filter.CreatedAfter.UTC())
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conds.add("created_at < ?", filter.CreatedBefore.UTC())
+	}
+	return conds
+}
+
+// userColumns lists the firestore tags of model.User in declaration order,
+// followed by the revision column.
+func userColumns() []string {
+	t := reflect.TypeOf(model.User{})
+	columns := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if tag := firestoreTag(t.Field(i)); tag != "" {
+			columns = append(columns, tag)
+		}
+	}
+	return append(columns, "revision")
+}
+
+// userValues returns pointers to the fields of user in the order given by
+// userColumns, suitable for both query arguments and Scan.
+func userValues(user *model.User) []interface{} {
+	v := reflect.ValueOf(user).Elem()
+	values := make([]interface{}, 0, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		if firestoreTag(v.Type().Field(i)) != "" {
+			values = append(values, v.Field(i).Addr().Interface())
+		}
+	}
+	return append(values, &user.Revision)
+}
+
+// placeholders returns n comma separated positional parameters starting at
+// $from.
+func placeholders(from, n int) string {
+	p := make([]string, n)
+	for i := range p {
+		p[i] = fmt.Sprintf("$%d", from+i)
+	}
+	return strings.Join(p, ", ")
+}
@@ -0,0 +1,431 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog"
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+)
+
+func newTestSQLUserRepository(t *testing.T) *SQLUserRepository {
+	t.Helper()
+	return newTestSQLUserRepositoryWith(t, model.EmailPolicy{})
+}
+
+func newTestSQLUserRepositoryWith(t *testing.T, keys model.EmailPolicy) *SQLUserRepository {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repo, err := NewSQLUserRepository(context.Background(), zerolog.Nop(), keys, db)
+	if err != nil {
+		t.Fatalf("failed to create repository: %v", err)
+	}
+	return repo
+}
+
+func TestSQLUserRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestSQLUserRepository(t)
+
+	if _, err := repo.GetUser(ctx, "jane@example.com"); errors.CodeFrom(err) != 404 {
+		t.Fatalf("expected 404 for missing user, got %v", err)
+	}
+
+	if _, err := repo.UpdateUser(ctx, model.User{Email: "jane@example.com"}, nil); errors.CodeFrom(err) != 404 {
+		t.Fatalf("expected 404 updating missing user, got %v", err)
+	}
+
+	if _, err := repo.CreateUser(ctx, model.User{Email: "jane@example.com", Name: "Jane Doe"}); err != nil {
+		t.Fatalf("unexpected error creating user: %v", err)
+	}
+
+	again, err := repo.CreateUser(ctx, model.User{Email: "jane@example.com", Name: "Someone Else"})
+	if err != nil {
+		t.Fatalf("unexpected error re-creating user: %v", err)
+	}
+	if again.Name != "Jane Doe" {
+		t.Errorf("expected create to return the existing user, got name '%s'", again.Name)
+	}
+
+	_, err = repo.UpdateUser(ctx, model.User{Email: "jane@example.com", Name: "Ignored", City: "Austin", Enrolled: true,
+		Answers: model.Answers{"pronouns": "she/her"}}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error updating user: %v", err)
+	}
+
+	got, err := repo.GetUser(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error getting user: %v", err)
+	}
+	if got.City != "Austin" || !got.Enrolled || got.Answers["pronouns"] != "she/her" {
+		t.Errorf("expected update to set city, enrolled and answers, got %+v", got)
+	}
+	if got.Name != "Jane Doe" {
+		t.Errorf("expected update to leave name untouched, got '%s'", got.Name)
+	}
+}
+
+func TestSQLMigrationsAreIdempotent(t *testing.T) {
+	repo := newTestSQLUserRepository(t)
+
+	if err := migrateSQL(context.Background(), repo.db); err != nil {
+		t.Fatalf("expected re-running migrations to succeed, got %v", err)
+	}
+}
+
+// openSQLAtVersion opens a database migrated up to version only, to test
+// later migrations against the data of older ones.
+func openSQLAtVersion(t *testing.T, version int) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(`CREATE TABLE schema_migrations (
+		version INTEGER NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range sqlMigrations[:version] {
+		if err := applySQLMigration(context.Background(), db, m); err != nil {
+			t.Fatalf("migration %d failed: %v", m.version, err)
+		}
+	}
+	return db
+}
+
+func TestSQLTimestampMigration(t *testing.T) {
+	ctx := context.Background()
+
+	// bring the schema to where created_at was still a string
+	db := openSQLAtVersion(t, 8)
+
+	created := time.Date(2022, 9, 1, 14, 30, 5, 123456000, time.UTC)
+	if _, err := db.ExecContext(ctx, `INSERT INTO users (email, created_at) VALUES ($1, $2)`, "jane@example.com", created.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := NewSQLUserRepository(ctx, zerolog.Nop(), model.EmailPolicy{}, db)
+	if err != nil {
+		t.Fatalf("unexpected error migrating: %v", err)
+	}
+	user, err := repo.GetUser(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error getting user: %v", err)
+	}
+	if !user.CreatedAt.Equal(created) || !user.UpdatedAt.Equal(created) {
+		t.Errorf("expected created_at and updated_at %v, got %v and %v", created, user.CreatedAt, user.UpdatedAt)
+	}
+	if user.EnrolledAt != nil || user.SchemaVersion != UserSchemaVersion {
+		t.Errorf("unexpected enrolled_at %v or schema version %d", user.EnrolledAt, user.SchemaVersion)
+	}
+}
+
+func TestSQLListUsers(t *testing.T) {
+	testListUsers(t, newTestSQLUserRepository(t))
+}
+
+func TestSQLUserHistory(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestSQLUserRepository(t)
+
+	first := model.UserChange{
+		Timestamp: time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC),
+		Actor:     "jane@example.com",
+		Source:    "PUT /api/users/:email",
+		Changes:   []model.FieldChange{{Path: "city", Before: "", After: "Austin"}},
+	}
+	second := first
+	second.Timestamp = first.Timestamp.Add(time.Hour)
+	second.Actor = "admin"
+
+	for _, c := range []model.UserChange{second, first} {
+		if err := repo.RecordUserChange(ctx, "jane@example.com", c); err != nil {
+			t.Fatalf("unexpected error recording change: %v", err)
+		}
+	}
+
+	history, err := repo.GetUserHistory(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error getting history: %v", err)
+	}
+	if len(history) != 2 || history[0].Actor != "jane@example.com" || history[1].Actor != "admin" {
+		t.Fatalf("expected history oldest first, got %+v", history)
+	}
+	if c := history[0].Changes; len(c) != 1 || c[0].Path != "city" || c[0].After != "Austin" {
+		t.Errorf("unexpected changes %+v", c)
+	}
+}
+
+func TestSQLDeleteUser(t *testing.T) {
+	testDeleteUser(t, newTestSQLUserRepository(t))
+}
+
+func TestSQLImportUsers(t *testing.T) {
+	testImportUsers(t, newTestSQLUserRepository(t))
+}
+
+func TestSQLConcurrentUpdate(t *testing.T) {
+	testConcurrentUpdate(t, newTestSQLUserRepository(t))
+}
+
+func TestSQLEmailKeys(t *testing.T) {
+	testEmailKeys(t, newTestSQLUserRepositoryWith(t, model.EmailPolicy{FoldGmail: true}))
+}
+
+func TestSQLEmailKeyMigration(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLAtVersion(t, 10)
+
+	signedIn := time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC)
+	for _, row := range []struct {
+		email    string
+		enrolled bool
+		city     string
+	}{
+		{"Jane@Example.com", true, ""},
+		{"jane@example.com", false, "Austin"},
+		{"john@example.com", false, ""},
+	} {
+		if _, err := db.ExecContext(ctx, `INSERT INTO users (email, enrolled, city, created_at, updated_at) VALUES ($1, $2, $3, $4, $4)`,
+			row.email, row.enrolled, row.city, signedIn); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.ExecContext(ctx, `INSERT INTO user_history (email, timestamp, actor, source, changes) VALUES ($1, $2, '', '', '[]')`,
+			row.email, signedIn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo, err := NewSQLUserRepository(ctx, zerolog.Nop(), model.EmailPolicy{}, db)
+	if err != nil {
+		t.Fatalf("unexpected error migrating: %v", err)
+	}
+
+	// the migration merges the duplicates that only differ in case
+	user, err := repo.GetUser(ctx, "JANE@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error getting user: %v", err)
+	}
+	if user.Email != "Jane@Example.com" || !user.Enrolled || user.City != "Austin" {
+		t.Errorf("expected the enrolled duplicate to be kept with the city of the other, got %+v", user)
+	}
+
+	history, err := repo.GetUserHistory(ctx, "jane@example.com")
+	if err != nil || len(history) != 3 {
+		t.Fatalf("expected the history of both duplicates and the merge, got %+v, %v", history, err)
+	}
+	if merge := history[2]; merge.Actor != "merge-users" || len(merge.Changes) != 2 || merge.Changes[0].Path != "city" {
+		t.Errorf("unexpected merge entry %+v", merge)
+	}
+
+	page, err := repo.ListUsers(ctx, UserFilter{}, "", 10)
+	if err != nil || len(page.Users) != 2 {
+		t.Errorf("expected two users left, got %+v, %v", page, err)
+	}
+	merges, err := repo.MergeDuplicateUsers(ctx, false)
+	if err != nil || len(merges) != 0 {
+		t.Errorf("expected nothing left to merge, got %+v, %v", merges, err)
+	}
+}
+
+func TestSQLSeatMigration(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLAtVersion(t, 12)
+
+	for _, row := range []struct {
+		email, cohort, track string
+		enrolled             bool
+	}{
+		{"a@example.com", "fall", "frontend", true},
+		{"b@example.com", "fall", "frontend", true},
+		{"c@example.com", "fall", "backend", true},
+		{"d@example.com", "fall", "backend", false},
+		{"e@example.com", "", "", false},
+	} {
+		if _, err := db.ExecContext(ctx, `INSERT INTO users (email, email_key, cohort_id, learning_track, enrolled) VALUES ($1, $1, $2, $3, $4)`,
+			row.email, row.cohort, row.track, row.enrolled); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo, err := NewSQLUserRepository(ctx, zerolog.Nop(), model.EmailPolicy{}, db)
+	if err != nil {
+		t.Fatalf("unexpected error migrating: %v", err)
+	}
+
+	seats, err := NewSQLCohortRepository(zerolog.Nop(), repo.db).GetSeats(ctx, "fall")
+	if err != nil {
+		t.Fatalf("unexpected error getting seats: %v", err)
+	}
+	if seats.Total != 3 || seats.Tracks["frontend"] != 2 || seats.Tracks["backend"] != 1 {
+		t.Errorf("expected seats of the enrolled users, got %+v", seats)
+	}
+}
+
+func TestSQLPhoneMigration(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLAtVersion(t, 14)
+
+	for email, number := range map[string]string{
+		"a@example.com": "512.555.0100",
+		"b@example.com": "call me",
+	} {
+		if _, err := db.ExecContext(ctx, `INSERT INTO users (email, email_key, phone, created_at, updated_at) VALUES ($1, $1, $2, $3, $3)`,
+			email, number, time.Now().UTC()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo, err := NewSQLUserRepository(ctx, zerolog.Nop(), model.EmailPolicy{}, db)
+	if err != nil {
+		t.Fatalf("unexpected error migrating: %v", err)
+	}
+
+	a, err := repo.GetUser(ctx, "a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Phone != "(512) 555-0100" || a.PhoneE164 != "+15125550100" || a.SchemaVersion != UserSchemaVersion {
+		t.Errorf("expected a normalized phone, got %+v", a)
+	}
+	b, err := repo.GetUser(ctx, "b@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Phone != "call me" || b.PhoneE164 != "" {
+		t.Errorf("expected an invalid phone to be kept, got %+v", b)
+	}
+}
+
+func TestSQLLinkedInMigration(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLAtVersion(t, 15)
+
+	for _, row := range []struct {
+		email, url           string
+		enrolled, waitlisted bool
+	}{
+		{"a@example.com", "https://www.linkedin.com/in/jane-doe", true, false},
+		{"b@example.com", "http://uk.linkedin.com/in/Jane-Doe/?originalSubdomain=uk", true, false},
+		{"c@example.com", "https://linkedin.com/in/jane-doe", false, false},
+		{"d@example.com", "https://www.linkedin.com/in/john-roe/", true, false},
+		{"e@example.com", "not a url", true, false},
+		{"f@example.com", "https://www.linkedin.com/in/jane-doe/", false, true},
+	} {
+		if _, err := db.ExecContext(ctx, `INSERT INTO users (email, email_key, linkedin_url, enrolled, waitlisted, created_at, updated_at) VALUES ($1, $1, $2, $3, $4, $5, $5)`,
+			row.email, row.url, row.enrolled, row.waitlisted, time.Now().UTC()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo, err := NewSQLUserRepository(ctx, zerolog.Nop(), model.EmailPolicy{}, db)
+	if err != nil {
+		t.Fatalf("unexpected error migrating: %v", err)
+	}
+
+	shared := true
+	page, err := repo.ListUsers(ctx, UserFilter{LinkedInShared: &shared}, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var emails []string
+	for _, user := range page.Users {
+		emails = append(emails, user.Email)
+	}
+	if want := []string{"a@example.com", "b@example.com", "f@example.com"}; !reflect.DeepEqual(emails, want) {
+		t.Fatalf("expected the enrolled and waitlisted users of jane-doe to be flagged, got %v", emails)
+	}
+	b := page.Users[1]
+	if b.LinkedInURL != "https://www.linkedin.com/in/jane-doe/" || b.LinkedInSlug != "jane-doe" {
+		t.Errorf("expected a canonical LinkedIn URL, got %s and %s", b.LinkedInURL, b.LinkedInSlug)
+	}
+
+	page, err = repo.ListUsers(ctx, UserFilter{LinkedInSlug: "jane-doe"}, "", 10)
+	if err != nil || len(page.Users) != 4 {
+		t.Fatalf("expected four users with the slug, got %v, %v", page, err)
+	}
+	e, err := repo.GetUser(ctx, "e@example.com")
+	if err != nil || e.LinkedInURL != "not a url" || e.LinkedInSlug != "" {
+		t.Errorf("expected an invalid URL to be kept, got %+v, %v", e, err)
+	}
+}
+
+func TestSQLStateMigration(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLAtVersion(t, 17)
+
+	for email, state := range map[string]string{
+		"a@example.com": "N.Y.",
+		"b@example.com": "Ontario",
+	} {
+		if _, err := db.ExecContext(ctx, `INSERT INTO users (email, email_key, state, created_at, updated_at) VALUES ($1, $1, $2, $3, $3)`,
+			email, state, time.Now().UTC()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo, err := NewSQLUserRepository(ctx, zerolog.Nop(), model.EmailPolicy{}, db)
+	if err != nil {
+		t.Fatalf("unexpected error migrating: %v", err)
+	}
+
+	a, err := repo.GetUser(ctx, "a@example.com")
+	if err != nil || a.State != "NY" || a.SchemaVersion != UserSchemaVersion {
+		t.Errorf("expected the state code, got %+v, %v", a, err)
+	}
+	b, err := repo.GetUser(ctx, "b@example.com")
+	if err != nil || b.State != "Ontario" {
+		t.Errorf("expected an unknown state to be kept, got %+v, %v", b, err)
+	}
+}
+
+func TestSQLUpdateUserRecordsHistory(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestSQLUserRepository(t)
+
+	user, err := repo.CreateUser(ctx, model.User{Email: "jane@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update := *user
+	update.LearningTrack = "data"
+	edit := &UserEdit{Before: *user, Actor: "jane@example.com", Source: "PUT /api/users/:email"}
+	if _, err := repo.UpdateUser(ctx, update, edit); err != nil {
+		t.Fatalf("unexpected error updating user: %v", err)
+	}
+	// the revision has moved on, so neither the update nor its history
+	// entry is kept
+	if _, err := repo.UpdateUser(ctx, update, edit); errors.CodeFrom(err) != 409 {
+		t.Fatalf("expected 409 for a stale update, got %v", err)
+	}
+
+	history, err := repo.GetUserHistory(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Actor != "jane@example.com" {
+		t.Fatalf("expected the one update in the history, got %+v", history)
+	}
+	if c := history[0].Changes; len(c) != 1 || c[0].Path != "learning_track" || c[0].After != "data" {
+		t.Errorf("unexpected changes %+v", c)
+	}
+}
@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+)
+
+// UserImport is what ImportUsers did with one user.
+type UserImport int
+
+const (
+	// UserImported was created, or would have been on a dry run.
+	UserImported UserImport = iota
+	// UserExists was left as it is.
+	UserExists
+	// UserErased was not created: an import is not the applicant signing
+	// in again, so it does not bring them back.
+	UserErased
+)
+
+// newImportedUser is user as CreateUser would store it.
+func newImportedUser(user model.User, keys model.EmailPolicy) model.User {
+	user.Email = strings.TrimSpace(user.Email)
+	user.EmailKey = keys.Key(user.Email)
+	user.SchemaVersion = UserSchemaVersion
+	stampCreate(&user, storeTime())
+	return user
+}
+
+// maxBatchWrites is the most writes a Firestore batch may hold.
+const maxBatchWrites = 500
+
+// MaxImportBatch is the most users ImportUsers takes at once. Every user
+// takes one write in the batch, plus one per secondary.
+func (u *UserRepository) MaxImportBatch() int {
+	return maxBatchWrites / (1 + len(u.secondaries))
+}
+
+// ImportUsers creates the users that neither exist nor were erased in a
+// single batch on the primary. It takes at most MaxImportBatch users.
+func (u *UserRepository) ImportUsers(ctx context.Context, users []model.User, dryRun bool) ([]UserImport, error) {
+	u.logger.Debug().Msgf("Firestore: importing %d users", len(users))
+
+	if n := u.MaxImportBatch(); len(users) > n {
+		return nil, errors.New(fmt.Sprintf("At Most %d Users Can Be Imported at Once", n), 400)
+	}
+
+	// the user document of every user, followed by its tombstone
+	refs := make([]*firestore.DocumentRef, 0, 2*len(users))
+	for _, user := range users {
+		refs = append(refs,
+			u.primary.users().Doc(u.keys.Key(user.Email)),
+			u.primary.client.Collection(tombstoneCollection).Doc(u.keys.TombstoneHash(user.Email)))
+	}
+
+	docs, err := u.primary.client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, errors.From(err, u.primary.name+" failed to get users", 500)
+	}
+
+	outcomes := make([]UserImport, len(users))
+	batch := u.primary.client.Batch()
+	var created []string
+	for i, user := range users {
+		outcomes[i] = importOutcome(docs[2*i], docs[2*i+1])
+		if outcomes[i] != UserImported {
+			continue
+		}
+
+		user = newImportedUser(user, u.keys)
+		batch.Create(u.primary.users().Doc(user.EmailKey), user)
+		u.queueReplicaWrites(batch, user.EmailKey, "create")
+		created = append(created, user.EmailKey)
+	}
+
+	if dryRun || len(created) == 0 {
+		return outcomes, nil
+	}
+	if _, err := batch.Commit(ctx); err != nil {
+		return nil, errors.From(err, u.primary.name+" failed to import users", 500)
+	}
+
+	for _, key := range created {
+		u.replicate(ctx, key)
+	}
+	return outcomes, nil
+}
+
+// importOutcome tells from the user document and tombstone of one user
+// what importing it does.
+func importOutcome(user, tombstone *firestore.DocumentSnapshot) UserImport {
+	if user.Exists() {
+		return UserExists
+	}
+	if tombstone.Exists() {
+		return UserErased
+	}
+	return UserImported
+}
+
+// ImportUsers creates the users that neither exist nor were erased in a
+// single transaction. When dryRun is set the transaction is rolled back.
+func (s *SQLUserRepository) ImportUsers(ctx context.Context, users []model.User, dryRun bool) ([]UserImport, error) {
+	s.logger.Debug().Msgf("SQL: importing %d users", len(users))
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.From(err, "failed to import users", 500)
+	}
+	defer tx.Rollback()
+
+	columns := userColumns()
+	insert := fmt.Sprintf(`INSERT INTO users (%s) VALUES (%s) ON CONFLICT (email_key) DO NOTHING`,
+		strings.Join(columns, ", "), placeholders(1, len(columns)))
+
+	outcomes := make([]UserImport, len(users))
+	for i, user := range users {
+		var erased int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM tombstones WHERE email_hash = $1`,
+			s.keys.TombstoneHash(user.Email)).Scan(&erased); err != nil {
+			return nil, errors.From(err, "failed to import users", 500)
+		}
+		if erased > 0 {
+			outcomes[i] = UserErased
+			continue
+		}
+
+		user = newImportedUser(user, s.keys)
+		res, err := tx.ExecContext(ctx, insert, userValues(&user)...)
+		if err != nil {
+			return nil, errors.From(err, "failed to import users", 500)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			outcomes[i] = UserExists
+		}
+	}
+
+	if dryRun {
+		return outcomes, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.From(err, "failed to import users", 500)
+	}
+	return outcomes, nil
+}
+
+func (m *MemoryUserRepository) ImportUsers(ctx context.Context, users []model.User, dryRun bool) ([]UserImport, error) {
+	m.logger.Debug().Msgf("Memory: importing %d users", len(users))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	outcomes := make([]UserImport, len(users))
+	for i, user := range users {
+		if _, ok := m.users[m.keys.Key(user.Email)]; ok {
+			outcomes[i] = UserExists
+			continue
+		}
+		if _, ok := m.tombstones[m.keys.TombstoneHash(user.Email)]; ok {
+			outcomes[i] = UserErased
+		}
+		if outcomes[i] == UserImported && !dryRun {
+			user = newImportedUser(user, m.keys)
+			m.users[user.EmailKey] = user
+		}
+	}
+	return outcomes, nil
+}
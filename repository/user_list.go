@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+)
+
+type (
+	// UserFilter narrows down ListUsers. Zero values match every user.
+	UserFilter struct {
+		Enrolled       *bool
+		Waitlisted     *bool
+		LinkedInShared *bool
+		CohortID       string
+		LinkedInSlug   string
+		LearningTrack  string
+		State          string
+		Referral       string
+		// CreatedAfter is inclusive, CreatedBefore is exclusive.
+		CreatedAfter  time.Time
+		CreatedBefore time.Time
+	}
+
+	// UserPage is one page of ListUsers. NextCursor is empty on the last
+	// page.
+	UserPage struct {
+		Users      []model.User `json:"users"`
+		NextCursor string       `json:"next_cursor,omitempty"`
+	}
+
+	// userCursor points at the last user of a page. Users are listed by
+	// creation time, with the email key breaking ties, so the order is
+	// stable. In Firestore the document ID breaks ties instead.
+	userCursor struct {
+		CreatedAt time.Time `json:"c"`
+		Key       string    `json:"e"`
+		// LegacyCreatedAt is the created_at string of a Firestore document
+		// from before user migration 2. Strings sort after every timestamp,
+		// so the next page has to start after the string itself.
+		LegacyCreatedAt string `json:"l,omitempty"`
+	}
+)
+
+func (f UserFilter) matches(user model.User) bool {
+	if f.Enrolled != nil && user.Enrolled != *f.Enrolled {
+		return false
+	}
+	if f.Waitlisted != nil && user.Waitlisted != *f.Waitlisted {
+		return false
+	}
+	if f.LinkedInShared != nil && user.LinkedInShared != *f.LinkedInShared {
+		return false
+	}
+	if f.CohortID != "" && user.CohortID != f.CohortID {
+		return false
+	}
+	if f.LinkedInSlug != "" && user.LinkedInSlug != f.LinkedInSlug {
+		return false
+	}
+	if f.LearningTrack != "" && user.LearningTrack != f.LearningTrack {
+		return false
+	}
+	if f.State != "" && user.State != f.State {
+		return false
+	}
+	if f.Referral != "" && user.Referral != f.Referral {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && user.CreatedAt.Before(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !user.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+func (c *userCursor) after(user model.User) bool {
+	if !user.CreatedAt.Equal(c.CreatedAt) {
+		return user.CreatedAt.After(c.CreatedAt)
+	}
+	return user.EmailKey > c.Key
+}
+
+func decodeUserCursor(cursor string) (*userCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.From(err, "Invalid Cursor", 400)
+	}
+
+	var c userCursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, errors.From(err, "Invalid Cursor", 400)
+	}
+	return &c, nil
+}
+
+func encodeUserCursor(user model.User) string {
+	return userCursor{CreatedAt: user.CreatedAt, Key: user.EmailKey}.encode()
+}
+
+func (c userCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// newUserPage builds a page from up to limit+1 ordered users, the extra one
+// only signalling that another page follows.
+func newUserPage(users []model.User, limit int) *UserPage {
+	page := &UserPage{Users: users}
+	if len(users) > limit {
+		page.Users = users[:limit]
+		page.NextCursor = encodeUserCursor(page.Users[limit-1])
+	}
+	if page.Users == nil {
+		page.Users = []model.User{}
+	}
+	return page
+}
@@ -0,0 +1,422 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+)
+
+// UserMerge is a set of users found to share an email key, folded into the
+// one that was kept. A merge without Merged users only moved the kept user
+// under its key.
+type UserMerge struct {
+	Key    string   `json:"key"`
+	Kept   string   `json:"kept"`
+	Merged []string `json:"merged"`
+}
+
+// storedUser is a user along with the identity it is stored under, which
+// predates email keys for old users.
+type storedUser struct {
+	id   string
+	user model.User
+}
+
+type userMergePlan struct {
+	key    string
+	kept   storedUser
+	merged []storedUser
+}
+
+func (p userMergePlan) report() UserMerge {
+	m := UserMerge{Key: p.key, Kept: p.kept.user.Email, Merged: []string{}}
+	for _, s := range p.merged {
+		m.Merged = append(m.Merged, s.user.Email)
+	}
+	return m
+}
+
+// mergedUser returns the kept user with the fields it left empty filled in
+// from the merged users, in the order they were ranked, and the history
+// entry of the merge, which is nil when nothing was merged.
+func (p userMergePlan) mergedUser(now time.Time) (model.User, *model.UserChange) {
+	kept := p.kept.user
+	kept.EmailKey = p.key
+	if len(p.merged) == 0 {
+		return kept, nil
+	}
+
+	var changes []model.FieldChange
+	for _, m := range p.merged {
+		changes = append(changes, fillUser(&kept, m.user)...)
+	}
+	deriveUserFields(&kept)
+
+	emails := make([]string, 0, len(p.merged))
+	for _, m := range p.merged {
+		emails = append(emails, m.user.Email)
+	}
+	changes = append(changes, model.FieldChange{Path: "merged_users", After: emails})
+
+	return kept, &model.UserChange{
+		Timestamp: now,
+		Actor:     "merge-users",
+		Source:    "merge-users",
+		Changes:   changes,
+	}
+}
+
+// mergeSkippedFields are not filled in by a merge: the identity of the kept
+// user, and the fields derived from others.
+var mergeSkippedFields = map[string]bool{
+	"email":         true,
+	"email_key":     true,
+	"phone_e164":    true,
+	"linkedin_slug": true,
+}
+
+// fillUser copies the text fields and answers kept has left empty from
+// from, and returns what it changed.
+func fillUser(kept *model.User, from model.User) []model.FieldChange {
+	var changes []model.FieldChange
+
+	kv, fv := reflect.ValueOf(kept).Elem(), reflect.ValueOf(from)
+	for i := 0; i < kv.NumField(); i++ {
+		tag := firestoreTag(kv.Type().Field(i))
+		if tag == "" || mergeSkippedFields[tag] || kv.Field(i).Kind() != reflect.String {
+			continue
+		}
+		if kv.Field(i).String() == "" && fv.Field(i).String() != "" {
+			kv.Field(i).SetString(fv.Field(i).String())
+			changes = append(changes, model.FieldChange{Path: tag, Before: "", After: fv.Field(i).String()})
+		}
+	}
+
+	for k, v := range from.Answers {
+		if _, ok := kept.Answers[k]; ok || v == "" {
+			continue
+		}
+		if kept.Answers == nil {
+			kept.Answers = model.Answers{}
+		}
+		kept.Answers[k] = v
+		changes = append(changes, model.FieldChange{Path: "answers." + k, Before: "", After: v})
+	}
+	return changes
+}
+
+// planUserMerges groups users by their email key and plans a merge for
+// every key that is shared by several users or not yet used as identity.
+func planUserMerges(users []storedUser, keys model.EmailPolicy) []userMergePlan {
+	groups := make(map[string][]storedUser)
+	for _, s := range users {
+		key := keys.Key(s.user.Email)
+		groups[key] = append(groups[key], s)
+	}
+
+	var plans []userMergePlan
+	for key, group := range groups {
+		if len(group) == 1 && group[0].id == key && group[0].user.EmailKey == key {
+			continue
+		}
+
+		sort.Slice(group, func(i, j int) bool { return keptBefore(group[i].user, group[j].user) })
+		plans = append(plans, userMergePlan{key: key, kept: group[0], merged: group[1:]})
+	}
+
+	sort.Slice(plans, func(i, j int) bool { return plans[i].key < plans[j].key })
+	return plans
+}
+
+// keptBefore orders users sharing a key by which should be kept: someone who
+// enrolled over someone who only signed in, then the latest to be updated.
+func keptBefore(a, b model.User) bool {
+	if a.Enrolled != b.Enrolled {
+		return a.Enrolled
+	}
+	if !a.UpdatedAt.Equal(b.UpdatedAt) {
+		return a.UpdatedAt.After(b.UpdatedAt)
+	}
+	return a.Email < b.Email
+}
+
+// MergeDuplicateUsers moves every user document of the primary under its
+// email key, folding users that share a key into one. The kept user takes
+// the fields it has left empty from the merged ones, the history and emails
+// of merged users move to the kept one, and the merge is added to its
+// history. The secondaries follow through the replica outbox. When dryRun
+// is set nothing is written.
+//
+// The users are paged through once to index the IDs of the documents under
+// each key. Only the users of a key that needs merging are read in full.
+func (u *UserRepository) MergeDuplicateUsers(ctx context.Context, dryRun bool) ([]UserMerge, error) {
+	ids := make(map[string][]string)
+	unkeyed := make(map[string]bool)
+	err := u.scanUserDocs(ctx, func(doc *firestore.DocumentSnapshot) error {
+		email, _ := doc.Data()["email"].(string)
+		stored, _ := doc.Data()["email_key"].(string)
+		key := u.keys.Key(email)
+		ids[key] = append(ids[key], doc.Ref.ID)
+		if doc.Ref.ID != key || stored != key {
+			unkeyed[key] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(unkeyed))
+	for key := range ids {
+		if len(ids[key]) > 1 || unkeyed[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var merges []UserMerge
+	for _, key := range keys {
+		users, err := u.getStoredUsers(ctx, ids[key])
+		if err != nil {
+			return merges, err
+		}
+		for _, plan := range planUserMerges(users, u.keys) {
+			if !dryRun {
+				if err := u.applyUserMerge(ctx, plan); err != nil {
+					return merges, err
+				}
+			}
+			merges = append(merges, plan.report())
+		}
+	}
+	return merges, nil
+}
+
+// getStoredUsers reads the user documents of the primary with ids.
+func (u *UserRepository) getStoredUsers(ctx context.Context, ids []string) ([]storedUser, error) {
+	refs := make([]*firestore.DocumentRef, len(ids))
+	for i, id := range ids {
+		refs[i] = u.primary.users().Doc(id)
+	}
+	docs, err := u.primary.client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, errors.From(err, "failed to read users", 500)
+	}
+
+	users := make([]storedUser, 0, len(docs))
+	for _, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		user, err := userFromDoc(doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to bind user %s: %w", doc.Ref.ID, err)
+		}
+		users = append(users, storedUser{id: doc.Ref.ID, user: user})
+	}
+	return users, nil
+}
+
+func (u *UserRepository) applyUserMerge(ctx context.Context, plan userMergePlan) error {
+	target := u.primary.users().Doc(plan.key)
+
+	// copy subcollections first under their own IDs, so a merge that is
+	// cut short can simply be run again
+	var moved []string
+	for _, s := range append([]storedUser{plan.kept}, plan.merged...) {
+		if s.id == plan.key {
+			continue
+		}
+		moved = append(moved, s.id)
+		for _, sub := range userSubcollections {
+			docs, err := u.primary.users().Doc(s.id).Collection(sub).Documents(ctx).GetAll()
+			if err != nil {
+				return fmt.Errorf("failed to read %s of %s: %w", sub, s.id, err)
+			}
+			for _, doc := range docs {
+				if _, err := target.Collection(sub).Doc(doc.Ref.ID).Set(ctx, doc.Data()); err != nil {
+					return fmt.Errorf("failed to move %s of %s: %w", sub, s.id, err)
+				}
+			}
+		}
+	}
+
+	kept, change := plan.mergedUser(storeTime())
+
+	batch := u.primary.client.Batch()
+	batch.Set(target, kept)
+	if change != nil {
+		batch.Create(target.Collection("history").NewDoc(), change)
+	}
+	u.queueReplicaWrites(batch, plan.key, "update")
+	for _, id := range moved {
+		batch.Delete(u.primary.users().Doc(id))
+		u.queueReplicaWrites(batch, id, "delete")
+	}
+	if _, err := batch.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to merge users into %s: %w", plan.key, err)
+	}
+	u.logger.Info().Msgf("Firestore: merged %d users into %s", len(plan.merged)+1, plan.key)
+
+	u.replicate(ctx, plan.key)
+	for _, id := range moved {
+		u.replicate(ctx, id)
+		for _, sub := range userSubcollections {
+			if err := deleteCollection(ctx, u.primary.users().Doc(id).Collection(sub)); err != nil {
+				return fmt.Errorf("failed to delete %s of %s: %w", sub, id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// MergeDuplicateUsers keys every row by its email key, folding users that
+// share a key into one as the Firestore repository does. When dryRun is set
+// the changes are rolled back.
+func (s *SQLUserRepository) MergeDuplicateUsers(ctx context.Context, dryRun bool) ([]UserMerge, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.From(err, "failed to merge users", 500)
+	}
+	defer tx.Rollback()
+
+	plans, err := mergeSQLUsers(ctx, tx, s.keys)
+	if err != nil {
+		return nil, errors.From(err, "failed to merge users", 500)
+	}
+
+	merges := make([]UserMerge, 0, len(plans))
+	for _, plan := range plans {
+		merges = append(merges, plan.report())
+	}
+
+	if dryRun {
+		return merges, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.From(err, "failed to merge users", 500)
+	}
+	return merges, nil
+}
+
+// sqlUserID is where the history and emails of a SQL user are stored:
+// under its key, or under its email before it had one.
+func sqlUserID(user model.User) string {
+	if user.EmailKey == "" {
+		return user.Email
+	}
+	return user.EmailKey
+}
+
+// mergeSQLUsers also backs SQL migration 11, so it only reads and writes the
+// columns the users table has, which may be fewer than model.User holds.
+func mergeSQLUsers(ctx context.Context, tx *sql.Tx, keys model.EmailPolicy) ([]userMergePlan, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT * FROM users`)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	has := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		has[c] = true
+	}
+
+	known := userColumns()
+	var users []storedUser
+	for rows.Next() {
+		var user model.User
+		values := make(map[string]interface{}, len(known))
+		for i, v := range userValues(&user) {
+			values[known[i]] = v
+		}
+		dest := make([]interface{}, len(columns))
+		for i, c := range columns {
+			if dest[i] = values[c]; dest[i] == nil {
+				dest[i] = new(interface{})
+			}
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		users = append(users, storedUser{id: sqlUserID(user), user: user})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	plans := planUserMerges(users, keys)
+	fields := userFieldsByTag()
+	for _, plan := range plans {
+		// drop the merged rows first so the kept one can take the key
+		for _, m := range plan.merged {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE email = $1`, m.user.Email); err != nil {
+				return nil, err
+			}
+		}
+
+		kept, change := plan.mergedUser(storeTime())
+		sets := []string{"email_key = $1"}
+		args := []interface{}{plan.key}
+		if change != nil {
+			v := reflect.ValueOf(kept)
+			derived := []string{"answers", "phone_e164", "linkedin_slug"}
+			for _, c := range change.Changes {
+				if strings.HasPrefix(c.Path, "answers.") {
+					continue
+				}
+				derived = append(derived, c.Path)
+			}
+			for _, column := range derived {
+				if i, ok := fields[column]; ok && has[column] {
+					args = append(args, v.Field(i).Interface())
+					sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+				}
+			}
+		}
+		args = append(args, plan.kept.user.Email)
+		query := fmt.Sprintf(`UPDATE users SET %s, revision = revision + 1 WHERE email = $%d`, strings.Join(sets, ", "), len(args))
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return nil, err
+		}
+
+		if err := moveSQLUserRecords(ctx, tx, plan); err != nil {
+			return nil, err
+		}
+		if change != nil {
+			if err := insertUserChange(ctx, tx, plan.key, *change); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return plans, nil
+}
+
+// moveSQLUserRecords moves the history and emails of every user of plan
+// under its key.
+func moveSQLUserRecords(ctx context.Context, tx *sql.Tx, plan userMergePlan) error {
+	for _, m := range append([]storedUser{plan.kept}, plan.merged...) {
+		if m.id == plan.key {
+			continue
+		}
+		for _, table := range []string{"user_history", "email_records"} {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET email = $1 WHERE email = $2`, table), plan.key, m.id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
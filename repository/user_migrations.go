@@ -0,0 +1,429 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/thealamu/linkedinsignin/linkedin"
+	"github.com/thealamu/linkedinsignin/model"
+	"github.com/thealamu/linkedinsignin/phone"
+	"github.com/thealamu/linkedinsignin/places"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// userMigration upgrades one user document from the previous schema version
+// to version. up works on the raw document so it can read and drop fields
+// model.User no longer declares, and it must be safe to run twice.
+type userMigration struct {
+	version int
+	name    string
+	up      func(doc map[string]interface{}) error
+}
+
+// userMigrations must only ever be appended to, with increasing versions.
+var userMigrations = []userMigration{
+	{
+		version: 1,
+		name:    "keep retired form fields under legacy",
+		up: legacyUserFields(
+			"location",
+			"timezone",
+			"tech_experience",
+			"will_change_job",
+			"will_change_job_role",
+			"open_to_meet",
+			"racial_demographic",
+		),
+	},
+	{
+		version: 2,
+		name:    "typed timestamps",
+		up:      typedUserTimestamps,
+	},
+	{
+		version: 3,
+		name:    "normalize phone numbers",
+		up:      normalizeUserPhone,
+	},
+	{
+		version: 4,
+		name:    "canonical linkedin urls",
+		up:      canonicalUserLinkedIn,
+	},
+	{
+		version: 5,
+		name:    "canonical us states",
+		up:      canonicalUserState,
+	},
+}
+
+// UserSchemaVersion is the schema_version of documents written by this
+// build.
+var UserSchemaVersion = userMigrations[len(userMigrations)-1].version
+
+// legacyUserFields moves fields model.User no longer declares into the
+// legacy map of the document, out of the way of the current form but not
+// lost. Fields already in legacy are kept.
+func legacyUserFields(fields ...string) func(map[string]interface{}) error {
+	return func(doc map[string]interface{}) error {
+		legacy, _ := doc["legacy"].(map[string]interface{})
+		for _, f := range fields {
+			v, ok := doc[f]
+			if !ok {
+				continue
+			}
+			if legacy == nil {
+				legacy = make(map[string]interface{})
+			}
+			if _, kept := legacy[f]; !kept {
+				legacy[f] = v
+			}
+			delete(doc, f)
+		}
+		if legacy != nil {
+			doc["legacy"] = legacy
+		}
+		return nil
+	}
+}
+
+// typedUserTimestamps turns the created_at string into a timestamp and
+// starts updated_at from it. Nobody recorded when users enrolled, so
+// enrolled_at is left unset. Until documents have been through it, reads
+// convert them the same way, see userFromDoc.
+func typedUserTimestamps(doc map[string]interface{}) error {
+	if s, ok := doc["created_at"].(string); ok {
+		t, err := parseLegacyTimestamp(s)
+		if err != nil {
+			return err
+		}
+		doc["created_at"] = t
+	}
+	if _, ok := doc["updated_at"]; !ok && doc["created_at"] != nil {
+		doc["updated_at"] = doc["created_at"]
+	}
+	return nil
+}
+
+// normalizeUserPhone rewrites phone numbers that parse in their display form
+// and records their E.164 form. Numbers that don't parse are kept as they
+// are, for someone to follow up.
+func normalizeUserPhone(doc map[string]interface{}) error {
+	s, _ := doc["phone"].(string)
+	display, e164 := phone.Normalize(s)
+	if e164 != "" {
+		doc["phone"] = display
+	}
+	doc["phone_e164"] = e164
+	return nil
+}
+
+// canonicalUserLinkedIn rewrites profile URLs in their canonical form and
+// records their vanity name. A single document can't tell whether its
+// profile is shared, so FlagSharedLinkedIn flags the users who already
+// enrolled with one once every document is migrated.
+func canonicalUserLinkedIn(doc map[string]interface{}) error {
+	s, _ := doc["linkedin_url"].(string)
+	canonical, slug := linkedin.CanonicalProfileURL(s)
+	doc["linkedin_url"] = canonical
+	doc["linkedin_slug"] = slug
+	if _, ok := doc["linkedin_shared"]; !ok {
+		doc["linkedin_shared"] = false
+	}
+	return nil
+}
+
+// canonicalUserState rewrites the states the gazetteer knows by their code,
+// as the enrollment form stores them. Others are kept as they are.
+func canonicalUserState(doc map[string]interface{}) error {
+	s, _ := doc["state"].(string)
+	if state, ok := places.US.State(s); ok {
+		doc["state"] = state.Code
+	}
+	return nil
+}
+
+// parseLegacyTimestamp parses created_at as it was stored before it became
+// a timestamp, i.e. time.Time.String() of a UTC time. An empty value gives
+// the zero time.
+func parseLegacyTimestamp(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	// drop the monotonic clock reading String() adds to local times
+	if i := strings.Index(s, " m="); i >= 0 {
+		s = s[:i]
+	}
+	t, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unrecognised timestamp '%s'", s)
+	}
+	return t.UTC(), nil
+}
+
+// docSchemaVersion reads schema_version off a raw document. Documents from
+// before versioning have none and count as version 0.
+func docSchemaVersion(doc map[string]interface{}) int {
+	switch v := doc["schema_version"].(type) {
+	case int64:
+		return int(v)
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// migrateUserDoc applies every migration doc has not seen yet, in order, and
+// reports whether anything was applied.
+func migrateUserDoc(doc map[string]interface{}) (bool, error) {
+	from := docSchemaVersion(doc)
+
+	migrated := false
+	for _, m := range userMigrations {
+		if m.version <= from {
+			continue
+		}
+		if err := m.up(doc); err != nil {
+			return migrated, fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+		doc["schema_version"] = int64(m.version)
+		migrated = true
+	}
+	return migrated, nil
+}
+
+// migrationCheckpointCollection lives in the primary project and holds one
+// document per replica recording how far MigrateUsers got.
+const migrationCheckpointCollection = "schema_migrations"
+
+type migrationCheckpoint struct {
+	Version   int       `firestore:"version"`
+	LastID    string    `firestore:"last_id"`
+	Scanned   int       `firestore:"scanned"`
+	Migrated  int       `firestore:"migrated"`
+	Done      bool      `firestore:"done"`
+	UpdatedAt time.Time `firestore:"updated_at"`
+}
+
+type (
+	// MigrateOptions controls a MigrateUsers run.
+	MigrateOptions struct {
+		// DryRun only counts the documents that would be migrated; nothing
+		// is written, checkpoints included.
+		DryRun    bool
+		BatchSize int
+	}
+
+	// MigrationReport sums up MigrateUsers on one replica. The counts
+	// include the work of an interrupted run it resumed.
+	MigrationReport struct {
+		Replica      string
+		Version      int
+		ResumedAfter string
+		Scanned      int
+		Migrated     int
+	}
+)
+
+// MigrateUsers brings every user document on every replica up to
+// UserSchemaVersion. Progress is checkpointed after each batch, so a run that
+// is interrupted picks up where it stopped.
+func (u *UserRepository) MigrateUsers(ctx context.Context, opts MigrateOptions) ([]*MigrationReport, error) {
+	if opts.BatchSize <= 0 {
+		return nil, fmt.Errorf("batch size must be positive")
+	}
+
+	var reports []*MigrationReport
+	for _, r := range append([]*replica{u.primary}, u.secondaries...) {
+		report, err := u.migrateReplica(ctx, r, opts)
+		if report != nil {
+			reports = append(reports, report)
+		}
+		if err != nil {
+			return reports, err
+		}
+	}
+	return reports, nil
+}
+
+func (u *UserRepository) migrateReplica(ctx context.Context, r *replica, opts MigrateOptions) (*MigrationReport, error) {
+	checkpoint := u.primary.client.Collection(migrationCheckpointCollection).Doc(r.name)
+
+	cp := migrationCheckpoint{Version: UserSchemaVersion}
+	if !opts.DryRun {
+		snap, err := checkpoint.Get(ctx)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return nil, fmt.Errorf("failed to read checkpoint of %s: %w", r.name, err)
+		}
+		if err == nil {
+			var saved migrationCheckpoint
+			if err := snap.DataTo(&saved); err != nil {
+				return nil, fmt.Errorf("failed to read checkpoint of %s: %w", r.name, err)
+			}
+			// a finished run or one towards another version starts over
+			if !saved.Done && saved.Version == UserSchemaVersion {
+				cp = saved
+			}
+		}
+	}
+
+	report := &MigrationReport{Replica: r.name, Version: UserSchemaVersion, ResumedAfter: cp.LastID}
+	for {
+		q := r.users().OrderBy(firestore.DocumentID, firestore.Asc).Limit(opts.BatchSize)
+		if cp.LastID != "" {
+			q = q.StartAfter(cp.LastID)
+		}
+		docs, err := q.Documents(ctx).GetAll()
+		if err != nil {
+			return report, fmt.Errorf("failed to read users of %s: %w", r.name, err)
+		}
+		if len(docs) == 0 {
+			break
+		}
+
+		for _, doc := range docs {
+			cp.Scanned++
+			changed, err := migrateUserDoc(doc.Data())
+			if err != nil {
+				return report, fmt.Errorf("user %s on %s: %w", doc.Ref.ID, r.name, err)
+			}
+			if changed {
+				if !opts.DryRun {
+					if err := migrateUser(ctx, r, doc.Ref); err != nil {
+						return report, fmt.Errorf("failed to migrate user %s on %s: %w", doc.Ref.ID, r.name, err)
+					}
+				}
+				cp.Migrated++
+			}
+			cp.LastID = doc.Ref.ID
+		}
+
+		report.Scanned, report.Migrated = cp.Scanned, cp.Migrated
+		if err := saveCheckpoint(ctx, checkpoint, cp, opts); err != nil {
+			return report, err
+		}
+		u.logger.Info().Msgf("Firestore: migrated %d of %d users on %s so far", cp.Migrated, cp.Scanned, r.name)
+	}
+
+	cp.Done = true
+	return report, saveCheckpoint(ctx, checkpoint, cp, opts)
+}
+
+// migrateUser re-reads the document in a transaction, so a write that
+// happened since it was scanned is migrated rather than overwritten.
+func migrateUser(ctx context.Context, r *replica, ref *firestore.DocumentRef) error {
+	return r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		doc := snap.Data()
+		changed, err := migrateUserDoc(doc)
+		if err != nil || !changed {
+			return err
+		}
+		return tx.Set(ref, doc)
+	})
+}
+
+func saveCheckpoint(ctx context.Context, ref *firestore.DocumentRef, cp migrationCheckpoint, opts MigrateOptions) error {
+	if opts.DryRun {
+		return nil
+	}
+	cp.UpdatedAt = time.Now().UTC()
+	if _, err := ref.Set(ctx, cp); err != nil {
+		return fmt.Errorf("failed to save checkpoint of %s: %w", ref.ID, err)
+	}
+	return nil
+}
+
+// FlagSharedLinkedIn flags the users on the primary who enrolled or joined
+// the waitlist with the same LinkedIn profile as someone else, as the SQL
+// migrations of their vanity names do, and returns them. Users already
+// flagged are left out. Documents not yet through user migration 4 are
+// grouped by the vanity name of their URL. Only the documents of each
+// vanity name are kept while the users are read, page by page. A dry run
+// only returns them.
+func (u *UserRepository) FlagSharedLinkedIn(ctx context.Context, dryRun bool) ([]model.User, error) {
+	bySlug := make(map[string][]sharedProfile)
+	err := u.scanUserDocs(ctx, func(doc *firestore.DocumentSnapshot) error {
+		data := doc.Data()
+		enrolled, _ := data["enrolled"].(bool)
+		waitlisted, _ := data["waitlisted"].(bool)
+		if !enrolled && !waitlisted {
+			return nil
+		}
+		slug, _ := data["linkedin_slug"].(string)
+		if slug == "" {
+			url, _ := data["linkedin_url"].(string)
+			_, slug = linkedin.CanonicalProfileURL(url)
+		}
+		if slug != "" {
+			shared, _ := data["linkedin_shared"].(bool)
+			bySlug[slug] = append(bySlug[slug], sharedProfile{id: doc.Ref.ID, shared: shared})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slugs := make([]string, 0, len(bySlug))
+	for slug, group := range bySlug {
+		if len(group) > 1 {
+			slugs = append(slugs, slug)
+		}
+	}
+	sort.Strings(slugs)
+
+	var ids []string
+	for _, slug := range slugs {
+		for _, p := range bySlug[slug] {
+			if !p.shared {
+				ids = append(ids, p.id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	stored, err := u.getStoredUsers(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	flagged := make([]model.User, len(stored))
+	for i, s := range stored {
+		flagged[i] = s.user
+	}
+	if dryRun {
+		return flagged, nil
+	}
+	for i, user := range flagged {
+		update := user
+		update.LinkedInShared = true
+		edit := &UserEdit{Before: user, Actor: "migrate", Source: "migrate"}
+		if _, err := u.UpdateUser(ctx, update, edit); err != nil {
+			return flagged[:i], fmt.Errorf("failed to flag %s: %w", user.Email, err)
+		}
+	}
+	return flagged, nil
+}
+
+// sharedProfile is a user document of a vanity name, as FlagSharedLinkedIn
+// keeps it.
+type sharedProfile struct {
+	id     string
+	shared bool
+}
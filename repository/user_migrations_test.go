@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUserMigrationsAreOrdered(t *testing.T) {
+	for i := 1; i < len(userMigrations); i++ {
+		if userMigrations[i].version <= userMigrations[i-1].version {
+			t.Fatalf("migration %d (%s) does not follow %d", userMigrations[i].version, userMigrations[i].name, userMigrations[i-1].version)
+		}
+	}
+}
+
+func TestMigrateUserDoc(t *testing.T) {
+	doc := map[string]interface{}{
+		"email":              "jane@example.com",
+		"timezone":           "America/Chicago",
+		"racial_demographic": "",
+		"phone":              "+1 512 555 0100",
+		"linkedin_url":       "http://uk.linkedin.com/in/Jane-Doe?trk=x",
+		"state":              "texas",
+	}
+
+	changed, err := migrateUserDoc(doc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !changed {
+		t.Fatal("expected an unversioned document to be migrated")
+	}
+	if _, ok := doc["timezone"]; ok {
+		t.Error("expected timezone to be moved out of the user")
+	}
+	legacy, _ := doc["legacy"].(map[string]interface{})
+	if legacy["timezone"] != "America/Chicago" || legacy["racial_demographic"] != "" {
+		t.Errorf("expected the retired fields to be kept under legacy, got %v", doc["legacy"])
+	}
+	if doc["email"] != "jane@example.com" {
+		t.Error("expected email to be kept")
+	}
+	if doc["phone"] != "(512) 555-0100" || doc["phone_e164"] != "+15125550100" {
+		t.Errorf("expected the phone to be normalized, got %v and %v", doc["phone"], doc["phone_e164"])
+	}
+	if doc["linkedin_url"] != "https://www.linkedin.com/in/jane-doe/" || doc["linkedin_slug"] != "jane-doe" {
+		t.Errorf("expected a canonical LinkedIn URL, got %v and %v", doc["linkedin_url"], doc["linkedin_slug"])
+	}
+	if doc["state"] != "TX" {
+		t.Errorf("expected the state code, got %v", doc["state"])
+	}
+	if v := docSchemaVersion(doc); v != UserSchemaVersion {
+		t.Errorf("expected schema version %d, got %d", UserSchemaVersion, v)
+	}
+
+	// running again must be a no-op
+	changed, err = migrateUserDoc(doc)
+	if err != nil || changed {
+		t.Fatalf("expected a current document to be left alone, got changed=%v err=%v", changed, err)
+	}
+}
+
+func TestParseLegacyTimestamp(t *testing.T) {
+	want := time.Date(2022, 9, 1, 14, 30, 5, 123456789, time.UTC)
+
+	for _, s := range []string{want.String(), want.String() + " m=+0.000123"} {
+		got, err := parseLegacyTimestamp(s)
+		if err != nil {
+			t.Fatalf("unexpected error parsing '%s': %v", s, err)
+		}
+		if !got.Equal(want) {
+			t.Errorf("expected %v parsing '%s', got %v", want, s, got)
+		}
+	}
+
+	if got, err := parseLegacyTimestamp(""); err != nil || !got.IsZero() {
+		t.Errorf("expected the zero time for an empty value, got %v, %v", got, err)
+	}
+	if _, err := parseLegacyTimestamp("yesterday"); err == nil {
+		t.Error("expected an error for an unrecognised value")
+	}
+}
@@ -2,40 +2,87 @@ package repository
 
 import (
 	"context"
+	"expvar"
+	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	firebase "firebase.google.com/go"
 	"github.com/rs/zerolog"
+	"github.com/thealamu/linkedinsignin/config"
 	"github.com/thealamu/linkedinsignin/errors"
 	"github.com/thealamu/linkedinsignin/model"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type UserRepository struct {
-	logger  zerolog.Logger
-	client1 *firestore.Client
-	client2 *firestore.Client
+	logger      zerolog.Logger
+	keys        model.EmailPolicy
+	primary     *replica
+	secondaries []*replica
+}
+
+// replica is one Firestore project holding a copy of the users collection.
+type replica struct {
+	name       string
+	client     *firestore.Client
+	collection string
+}
+
+func (r *replica) users() *firestore.CollectionRef {
+	return r.client.Collection(r.collection)
 }
 
 var _ UserRepositoryInterface = (*UserRepository)(nil)
 
-func NewUserRepository(logger zerolog.Logger) *UserRepository {
+// NewUserRepository connects to every replica. Their service accounts must
+// already have been written to config.Replica.CredentialsFile. User
+// documents are keyed by the EmailPolicy key of their email.
+func NewUserRepository(logger zerolog.Logger, keys model.EmailPolicy, replicas []config.Replica) *UserRepository {
 	r := &UserRepository{
 		logger: logger,
+		keys:   keys,
 	}
 
-	r.client1 = getClient("./service-account-1.json")
-	r.client2 = getClient("./service-account-2.json")
+	for _, rc := range replicas {
+		rep := &replica{
+			name:       rc.Name,
+			client:     getClient("./"+rc.CredentialsFile(), rc.ProjectID),
+			collection: rc.Collection,
+		}
+		if rc.Role == config.ReplicaPrimary {
+			r.primary = rep
+		} else {
+			r.secondaries = append(r.secondaries, rep)
+		}
+	}
 
 	return r
 }
 
-func getClient(saFile string) *firestore.Client {
+// Close closes the clients of every replica.
+func (u *UserRepository) Close() {
+	for _, r := range append([]*replica{u.primary}, u.secondaries...) {
+		if err := r.client.Close(); err != nil {
+			u.logger.Err(err).Msgf("Firestore: failed to close client of %s", r.name)
+		}
+	}
+}
+
+func getClient(saFile, projectID string) *firestore.Client {
 	ctx := context.Background()
 
+	var conf *firebase.Config
+	if projectID != "" {
+		conf = &firebase.Config{ProjectID: projectID}
+	}
+
 	sa := option.WithCredentialsFile(saFile)
-	app, err := firebase.NewApp(ctx, nil, sa)
+	app, err := firebase.NewApp(ctx, conf, sa)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -52,25 +99,77 @@ func (u *UserRepository) CreateUser(ctx context.Context, user model.User) (*mode
 	u.logger.Debug().Msgf("Firestore: creating user with email: %s", user.Email)
 
 	gotUser, err := u.GetUser(ctx, user.Email)
-	if err == nil || gotUser != nil {
+	if err == nil {
 		return gotUser, nil
 	}
-
-	if _, err := u.client1.Collection("users").Doc(user.Email).Set(ctx, user); err != nil {
-		return nil, errors.From(err, "client1 failed to create user", 500)
+	if errors.CodeFrom(err) != 404 {
+		return nil, err
 	}
 
-	if _, err := u.client2.Collection("users").Doc(user.Email).Set(ctx, user); err != nil {
-		return nil, errors.From(err, "client2 failed to create user", 500)
+	user.Email = strings.TrimSpace(user.Email)
+	user.EmailKey = u.keys.Key(user.Email)
+	user.SchemaVersion = UserSchemaVersion
+	stampCreate(&user, storeTime())
+
+	// Signing in again after an erasure is a fresh application: the
+	// tombstone goes and nothing from before the erasure comes back.
+	batch := u.primary.client.Batch()
+	batch.Set(u.primary.users().Doc(user.EmailKey), user)
+	batch.Delete(u.primary.client.Collection(tombstoneCollection).Doc(u.keys.TombstoneHash(user.Email)))
+	u.queueReplicaWrites(batch, user.EmailKey, "create")
+	results, err := batch.Commit(ctx)
+	if err != nil {
+		return nil, errors.From(err, u.primary.name+" failed to create user", 500)
 	}
+	user.Revision = results[0].UpdateTime.UnixNano()
+
+	u.replicate(ctx, user.EmailKey)
 
 	return &user, nil
 }
 
-func (u *UserRepository) UpdateUser(ctx context.Context, user model.User) (*model.User, error) {
+func (u *UserRepository) UpdateUser(ctx context.Context, user model.User, edit *UserEdit) (*model.User, error) {
 	u.logger.Debug().Msgf("Firestore: updating user with email: %s", user.Email)
 
-	updates := []firestore.Update{
+	// a user without a revision was read from a secondary while the
+	// primary was unreachable
+	if user.Revision == 0 {
+		return nil, errors.New("User Data Temporarily Unavailable", 503)
+	}
+
+	key := u.keys.Key(user.Email)
+	now := storeTime()
+	updates := stampUpdate(&user, now)
+
+	// the revision is the update time of the document the user was read
+	// from, so the write fails if anything has touched it since
+	batch := u.primary.client.Batch()
+	batch.Update(u.primary.users().Doc(key), updates,
+		firestore.LastUpdateTime(time.Unix(0, user.Revision).UTC()))
+	if change := edit.change(user, now); change != nil {
+		batch.Create(u.primary.users().Doc(key).Collection("history").NewDoc(), change)
+	}
+	u.queueReplicaWrites(batch, key, "update")
+	results, err := batch.Commit(ctx)
+	switch status.Code(err) {
+	case codes.OK:
+	case codes.NotFound:
+		return nil, errors.From(err, "User Account Not Found", 404)
+	case codes.FailedPrecondition:
+		return nil, errConcurrentUpdate
+	default:
+		return nil, errors.From(err, u.primary.name+" failed to update user data", 500)
+	}
+	user.Revision = results[0].UpdateTime.UnixNano()
+
+	u.replicate(ctx, key)
+
+	return &user, nil
+}
+
+// userUpdates lists the fields of user that an update is allowed to change.
+func userUpdates(user model.User) []firestore.Update {
+	return []firestore.Update{
 		{Path: "representation", Value: user.Representation},
 		{Path: "gender", Value: user.Gender},
 		{Path: "age_group", Value: user.AgeGroup},
@@ -84,8 +183,13 @@ func (u *UserRepository) UpdateUser(ctx context.Context, user model.User) (*mode
 		{Path: "referral", Value: user.Referral},
 		{Path: "referral_other", Value: user.ReferralOther},
 		{Path: "enrolled", Value: user.Enrolled},
+		{Path: "enrolled_at", Value: user.EnrolledAt},
+		{Path: "waitlisted", Value: user.Waitlisted},
+		{Path: "waitlisted_at", Value: user.WaitlistedAt},
+		{Path: "cohort_id", Value: user.CohortID},
 		// {Path: "timezone", Value: user.Timezone},
 		{Path: "phone", Value: user.Phone},
+		{Path: "phone_e164", Value: user.PhoneE164},
 		{Path: "photo", Value: user.Photo},
 		{Path: "gitaccount", Value: user.GitAccount},
 		{Path: "figmaaccount", Value: user.FigmaAccount},
@@ -101,32 +205,402 @@ func (u *UserRepository) UpdateUser(ctx context.Context, user model.User) (*mode
 		// {Path: "racial_demographic", Value: user.RacialDemographic},
 		{Path: "prior_knowledge", Value: user.PriorKnowledge},
 		{Path: "linkedin_url", Value: user.LinkedInURL},
+		{Path: "linkedin_slug", Value: user.LinkedInSlug},
+		{Path: "linkedin_shared", Value: user.LinkedInShared},
+		{Path: "answers", Value: user.Answers},
 	}
+}
 
-	if _, err := u.client1.Collection("users").Doc(user.Email).Update(ctx, updates); err != nil {
-		return nil, errors.From(err, "client1 failed to update user data", 500)
+// UpdatableUserFields lists the firestore names of the fields an update is
+// allowed to change.
+func UpdatableUserFields() []string {
+	updates := userUpdates(model.User{})
+	paths := make([]string, len(updates))
+	for i, update := range updates {
+		paths[i] = update.Path
+	}
+	return paths
+}
+
+func (u *UserRepository) GetUser(ctx context.Context, email string) (*model.User, error) {
+	u.logger.Debug().Msgf("Firestore: getting user with email: %s", email)
+
+	key := u.keys.Key(email)
+
+	user, err := u.primary.getUser(ctx, key)
+	if err == nil {
+		u.logger.Debug().Str("replica", u.primary.name).Msgf("Firestore: served user %s", email)
+		userReadsServed.Add(u.primary.name, 1)
+		return user, nil
+	}
+	if status.Code(err) == codes.NotFound {
+		return nil, errors.From(err, "User Account Not Found", 404)
+	}
+	if _, ok := err.(errors.Error); ok {
+		return nil, err
 	}
 
-	if _, err := u.client2.Collection("users").Doc(user.Email).Update(ctx, updates); err != nil {
-		return nil, errors.From(err, "client2 failed to update user data", 500)
+	for _, r := range u.secondaries {
+		u.logger.Warn().Err(err).Msgf("Firestore: failed to get user %s, falling back to %s", email, r.name)
+
+		userReadFailovers.Add(1)
+		user, err = r.getUser(ctx, key)
+		if err == nil {
+			u.logger.Info().Str("replica", r.name).Msgf("Firestore: served user %s", email)
+			userReadsServed.Add(r.name, 1)
+			// the update time of a secondary copy never matches the
+			// primary's, so the user can be read but not updated
+			user.Revision = 0
+			return user, nil
+		}
+		if _, ok := err.(errors.Error); ok {
+			return nil, err
+		}
 	}
 
-	return &user, nil
+	// a secondary may simply not have caught up yet, so a miss there does
+	// not prove the user doesn't exist
+	return nil, errors.From(err, "User Data Temporarily Unavailable", 503)
 }
 
-func (u *UserRepository) GetUser(ctx context.Context, email string) (*model.User, error) {
-	u.logger.Debug().Msgf("Firestore: getting user with email: %s", email)
+// Read counters, published with expvar.
+var (
+	// userReadsServed counts the user reads each replica has answered
+	userReadsServed = expvar.NewMap("firestore_user_reads_served")
+	// userReadFailovers counts the reads retried on a secondary after a
+	// failure of the replica before it
+	userReadFailovers = expvar.NewInt("firestore_user_read_failovers")
+)
 
-	data, err := u.client1.Collection("users").Doc(email).Get(ctx)
+func (r *replica) getUser(ctx context.Context, key string) (*model.User, error) {
+	data, err := r.users().Doc(key).Get(ctx)
 	if err != nil {
-		return nil, errors.From(err, "User Account Not Found", 404)
+		return nil, err
 	}
 
-	user := model.User{}
-	err = data.DataTo(&user)
+	user, err := userFromDoc(data)
 	if err != nil {
 		return nil, errors.From(err, "failed to bind user data", 500)
 	}
 
 	return &user, nil
 }
+
+// storedUserDoc binds a user document of any schema version. Documents from
+// before user migration 2 still hold created_at as a string.
+type storedUserDoc struct {
+	model.User
+	CreatedAt interface{} `firestore:"created_at"`
+}
+
+// userFromDoc binds a user document, reading a created_at string the way
+// user migration 2 does, so this build can serve documents MigrateUsers has
+// not got to yet.
+func userFromDoc(doc *firestore.DocumentSnapshot) (model.User, error) {
+	var stored storedUserDoc
+	if err := doc.DataTo(&stored); err != nil {
+		return model.User{}, err
+	}
+
+	user := stored.User
+	switch v := stored.CreatedAt.(type) {
+	case nil:
+	case time.Time:
+		user.CreatedAt = v
+	case string:
+		t, err := parseLegacyTimestamp(v)
+		if err != nil {
+			return model.User{}, err
+		}
+		user.CreatedAt = t
+		if user.UpdatedAt.IsZero() {
+			user.UpdatedAt = t
+		}
+	default:
+		return model.User{}, fmt.Errorf("created_at of %s is a %T", doc.Ref.ID, v)
+	}
+	user.Revision = doc.UpdateTime.UnixNano()
+	return user, nil
+}
+
+// ListUsers reads from the primary only. Combining an equality filter with
+// the created_at ordering needs a composite index in Firestore. Documents
+// not yet through user migration 2 are listed after all others, and never
+// match a creation date filter.
+func (u *UserRepository) ListUsers(ctx context.Context, filter UserFilter, cursor string, limit int) (*UserPage, error) {
+	u.logger.Debug().Msgf("Firestore: listing users with filter: %+v", filter)
+
+	after, err := decodeUserCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.primary.users().Query
+	if filter.Enrolled != nil {
+		q = q.Where("enrolled", "==", *filter.Enrolled)
+	}
+	if filter.Waitlisted != nil {
+		q = q.Where("waitlisted", "==", *filter.Waitlisted)
+	}
+	if filter.LinkedInShared != nil {
+		q = q.Where("linkedin_shared", "==", *filter.LinkedInShared)
+	}
+	if filter.CohortID != "" {
+		q = q.Where("cohort_id", "==", filter.CohortID)
+	}
+	if filter.LinkedInSlug != "" {
+		q = q.Where("linkedin_slug", "==", filter.LinkedInSlug)
+	}
+	if filter.LearningTrack != "" {
+		q = q.Where("learning_track", "==", filter.LearningTrack)
+	}
+	if filter.State != "" {
+		q = q.Where("state", "==", filter.State)
+	}
+	if filter.Referral != "" {
+		q = q.Where("referral", "==", filter.Referral)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		q = q.Where("created_at", ">=", filter.CreatedAfter.UTC())
+	}
+	if !filter.CreatedBefore.IsZero() {
+		q = q.Where("created_at", "<", filter.CreatedBefore.UTC())
+	}
+
+	q = q.OrderBy("created_at", firestore.Asc).OrderBy(firestore.DocumentID, firestore.Asc)
+	switch {
+	case after == nil:
+	case after.LegacyCreatedAt != "":
+		q = q.StartAfter(after.LegacyCreatedAt, after.Key)
+	default:
+		q = q.StartAfter(after.CreatedAt, after.Key)
+	}
+
+	docs, err := q.Limit(limit + 1).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, errors.From(err, "failed to list users", 500)
+	}
+
+	users := make([]model.User, 0, len(docs))
+	for _, doc := range docs {
+		user, err := userFromDoc(doc)
+		if err != nil {
+			return nil, errors.From(err, "failed to bind user data", 500)
+		}
+		users = append(users, user)
+	}
+
+	page := newUserPage(users, limit)
+	if page.NextCursor != "" {
+		page.NextCursor = docCursor(docs[limit-1]).encode()
+	}
+	return page, nil
+}
+
+// scanPageSize is how many user documents scanUserDocs reads at a time. It
+// is a variable for tests.
+var scanPageSize = 500
+
+// scanUserDocs calls fn with every user document of the primary, in the
+// created_at, DocumentID order of ListUsers, reading a page at a time.
+func (u *UserRepository) scanUserDocs(ctx context.Context, fn func(doc *firestore.DocumentSnapshot) error) error {
+	q := u.primary.users().OrderBy("created_at", firestore.Asc).OrderBy(firestore.DocumentID, firestore.Asc)
+	page := q
+	for {
+		docs, err := page.Limit(scanPageSize).Documents(ctx).GetAll()
+		if err != nil {
+			return errors.From(err, "failed to read users", 500)
+		}
+		for _, doc := range docs {
+			if err := fn(doc); err != nil {
+				return err
+			}
+		}
+		if len(docs) < scanPageSize {
+			return nil
+		}
+		c := docCursor(docs[len(docs)-1])
+		if c.LegacyCreatedAt != "" {
+			page = q.StartAfter(c.LegacyCreatedAt, c.Key)
+		} else {
+			page = q.StartAfter(c.CreatedAt, c.Key)
+		}
+	}
+}
+
+// docCursor points at doc in the created_at, DocumentID order of the users
+// collection. The ID is taken over email_key, which documents from before
+// email keys lack, and which needn't match the ID until merge-users ran.
+func docCursor(doc *firestore.DocumentSnapshot) userCursor {
+	c := userCursor{Key: doc.Ref.ID}
+	switch v := doc.Data()["created_at"].(type) {
+	case time.Time:
+		c.CreatedAt = v
+	case string:
+		c.LegacyCreatedAt = v
+	}
+	return c
+}
+
+// tombstoneCollection lives in the primary project and is keyed by
+// model.EmailPolicy.EmailHash.
+const tombstoneCollection = "tombstones"
+
+// DeleteUser clears the user's subcollections before the user document goes,
+// as they outlive it: a failure part way leaves the user in place for a
+// retry. A retry of an erasure that has already gone through clears whatever
+// is left and returns the tombstone again.
+func (u *UserRepository) DeleteUser(ctx context.Context, email string) (*model.Tombstone, error) {
+	u.logger.Debug().Msgf("Firestore: deleting user with email: %s", email)
+
+	key := u.keys.Key(email)
+	for _, sub := range userSubcollections {
+		if err := deleteCollection(ctx, u.primary.users().Doc(key).Collection(sub)); err != nil {
+			return nil, errors.From(err, "failed to delete user "+sub, 500)
+		}
+	}
+
+	tombstone := u.keys.NewTombstone(key, time.Now().UTC())
+
+	batch := u.primary.client.Batch()
+	batch.Delete(u.primary.users().Doc(key), firestore.Exists)
+	batch.Set(u.primary.client.Collection(tombstoneCollection).Doc(tombstone.EmailHash), tombstone)
+	u.queueReplicaWrites(batch, key, "delete")
+	_, err := batch.Commit(ctx)
+	switch status.Code(err) {
+	case codes.OK:
+	case codes.NotFound:
+		return u.getTombstone(ctx, email)
+	default:
+		return nil, errors.From(err, u.primary.name+" failed to delete user", 500)
+	}
+
+	u.replicate(ctx, key)
+
+	return &tombstone, nil
+}
+
+// getTombstone returns the tombstone of the user with email, or a 404 when
+// the user was never erased.
+func (u *UserRepository) getTombstone(ctx context.Context, email string) (*model.Tombstone, error) {
+	doc, err := u.primary.client.Collection(tombstoneCollection).Doc(u.keys.TombstoneHash(email)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, errors.New("User Account Not Found", 404)
+	}
+	if err != nil {
+		return nil, errors.From(err, "failed to get tombstone", 500)
+	}
+
+	var tombstone model.Tombstone
+	if err := doc.DataTo(&tombstone); err != nil {
+		return nil, errors.From(err, "failed to bind tombstone", 500)
+	}
+	return &tombstone, nil
+}
+
+// userSubcollections hang off a user document on the primary.
+var userSubcollections = []string{"history", "emails"}
+
+func (u *UserRepository) isTombstoned(ctx context.Context, email string) (bool, error) {
+	_, err := u.getTombstone(ctx, email)
+	if errors.CodeFrom(err) == 404 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func deleteCollection(ctx context.Context, col *firestore.CollectionRef) error {
+	refs, err := col.DocumentRefs(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		if _, err := ref.Delete(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordUserChange keeps the history in a subcollection of the user's
+// document on the primary.
+func (u *UserRepository) RecordUserChange(ctx context.Context, email string, change model.UserChange) error {
+	u.logger.Debug().Msgf("Firestore: recording change to user with email: %s", email)
+
+	if _, _, err := u.primary.users().Doc(u.keys.Key(email)).Collection("history").Add(ctx, change); err != nil {
+		return errors.From(err, "failed to record user change", 500)
+	}
+	return nil
+}
+
+func (u *UserRepository) GetUserHistory(ctx context.Context, email string) ([]model.UserChange, error) {
+	u.logger.Debug().Msgf("Firestore: getting history of user with email: %s", email)
+
+	docs, err := u.primary.users().Doc(u.keys.Key(email)).Collection("history").OrderBy("timestamp", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, errors.From(err, "failed to get user history", 500)
+	}
+
+	history := make([]model.UserChange, 0, len(docs))
+	for _, doc := range docs {
+		var change model.UserChange
+		if err := doc.DataTo(&change); err != nil {
+			return nil, errors.From(err, "failed to bind user history", 500)
+		}
+		history = append(history, change)
+	}
+	return history, nil
+}
+
+// GetUserReplicas reads the user from the primary and then every secondary.
+// A replica that can't be read is reported in its copy, not as an error.
+func (u *UserRepository) GetUserReplicas(ctx context.Context, email string) ([]model.ReplicaCopy, error) {
+	u.logger.Debug().Msgf("Firestore: getting every copy of user with email: %s", email)
+
+	replicas := append([]*replica{u.primary}, u.secondaries...)
+	copies := make([]model.ReplicaCopy, 0, len(replicas))
+	for _, r := range replicas {
+		cp := model.ReplicaCopy{Replica: r.name}
+
+		user, err := r.getUser(ctx, u.keys.Key(email))
+		switch {
+		case err == nil:
+			cp.User = user
+		case status.Code(err) != codes.NotFound:
+			cp.Error = err.Error()
+		}
+		copies = append(copies, cp)
+	}
+	return copies, nil
+}
+
+// RecordEmail keeps the record in a subcollection of the user's document on
+// the primary.
+func (u *UserRepository) RecordEmail(ctx context.Context, email string, record model.EmailRecord) error {
+	u.logger.Debug().Msgf("Firestore: recording email to user with email: %s", email)
+
+	if _, _, err := u.primary.users().Doc(u.keys.Key(email)).Collection("emails").Add(ctx, record); err != nil {
+		return errors.From(err, "failed to record email", 500)
+	}
+	return nil
+}
+
+func (u *UserRepository) GetEmailRecords(ctx context.Context, email string) ([]model.EmailRecord, error) {
+	u.logger.Debug().Msgf("Firestore: getting emails to user with email: %s", email)
+
+	docs, err := u.primary.users().Doc(u.keys.Key(email)).Collection("emails").OrderBy("sent_at", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, errors.From(err, "failed to get email records", 500)
+	}
+
+	records := make([]model.EmailRecord, 0, len(docs))
+	for _, doc := range docs {
+		var record model.EmailRecord
+		if err := doc.DataTo(&record); err != nil {
+			return nil, errors.From(err, "failed to bind email record", 500)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
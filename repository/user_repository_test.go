@@ -0,0 +1,358 @@
+package repository
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestGetUserFailover(t *testing.T) {
+	ctx := context.Background()
+	u, fakes := newFakeUserRepository(t, 1)
+	primary, secondary := fakes[0], fakes[1]
+
+	if _, err := u.CreateUser(ctx, model.User{Email: "jane@example.com", FirstName: "Jane"}); err != nil {
+		t.Fatal(err)
+	}
+	served := func(name string) int64 {
+		if v := userReadsServed.Get(name); v != nil {
+			return v.(*expvar.Int).Value()
+		}
+		return 0
+	}
+
+	primary.setDown(codes.Unavailable)
+	before := served("secondary1")
+	user, err := u.GetUser(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("expected the secondary to serve the user, got %v", err)
+	}
+	if user.FirstName != "Jane" {
+		t.Errorf("expected Jane from the secondary, got %+v", user)
+	}
+	if got := served("secondary1") - before; got != 1 {
+		t.Errorf("expected 1 read served by the secondary, got %d", got)
+	}
+	if user.Revision != 0 {
+		t.Errorf("expected no revision on a user read from the secondary, got %d", user.Revision)
+	}
+
+	// the primary is back, but the user read from the secondary is not
+	// good for an update
+	primary.setDown(codes.OK)
+	user.LearningTrack = "data"
+	if _, err := u.UpdateUser(ctx, *user, nil); errors.CodeFrom(err) != 503 {
+		t.Errorf("expected 503 updating a user read from the secondary, got %v", err)
+	}
+	primary.setDown(codes.Unavailable)
+
+	// a secondary that hasn't caught up can't prove the user is gone
+	_, err = u.GetUser(ctx, "john@example.com")
+	if code := errors.CodeFrom(err); code != 503 {
+		t.Errorf("expected 503 for a miss on the secondary, got %d: %v", code, err)
+	}
+
+	// the primary's answer is final, even when a secondary still has a copy
+	primary.setDown(codes.OK)
+	secondary.put("users/john@example.com", nil)
+	_, err = u.GetUser(ctx, "john@example.com")
+	if code := errors.CodeFrom(err); code != 404 {
+		t.Errorf("expected 404 for a miss on the primary, got %d: %v", code, err)
+	}
+}
+
+func TestUpdateUserRecordsHistory(t *testing.T) {
+	ctx := context.Background()
+	u, fakes := newFakeUserRepository(t, 0)
+
+	user, err := u.CreateUser(ctx, model.User{Email: "jane@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := *user
+	update.LearningTrack = "data"
+	edit := &UserEdit{Before: *user, Actor: "jane@example.com", Source: "PUT /api/users/:email"}
+
+	// a failed commit leaves neither the update nor its history entry
+	fakes[0].fail("Commit", codes.Internal, 1)
+	if _, err := u.UpdateUser(ctx, update, edit); err == nil {
+		t.Fatal("expected the failed commit to fail the update")
+	}
+	if n := fakes[0].count("users/jane@example.com/history"); n != 0 {
+		t.Fatalf("expected no history after a failed update, got %d entries", n)
+	}
+
+	if _, err := u.UpdateUser(ctx, update, edit); err != nil {
+		t.Fatalf("unexpected error updating user: %v", err)
+	}
+	history, err := u.GetUserHistory(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || len(history[0].Changes) != 1 || history[0].Changes[0].Path != "learning_track" {
+		t.Errorf("expected the update in the history, got %+v", history)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	u, _ := newFakeUserRepository(t, 1)
+	testDeleteUser(t, u)
+}
+
+func TestImportUsers(t *testing.T) {
+	u, fakes := newFakeUserRepository(t, 1)
+	testImportUsers(t, u)
+
+	// the import reached the secondary along with the primary
+	if fakes[1].doc("users/jane@example.com") == nil {
+		t.Error("expected the imported user on the secondary")
+	}
+
+	// a user takes two of the 500 writes of a batch with one secondary
+	if n := u.MaxImportBatch(); n != 250 {
+		t.Fatalf("expected at most 250 users at once, got %d", n)
+	}
+	users := make([]model.User, 251)
+	for i := range users {
+		users[i].Email = fmt.Sprintf("user%d@example.com", i)
+	}
+	if _, err := u.ImportUsers(context.Background(), users, false); errors.CodeFrom(err) != 400 {
+		t.Errorf("expected 400 importing more users than a batch holds, got %v", err)
+	}
+}
+
+func TestDeleteUserRetried(t *testing.T) {
+	ctx := context.Background()
+	u, fakes := newFakeUserRepository(t, 0)
+	primary := fakes[0]
+
+	if _, err := u.CreateUser(ctx, model.User{Email: "jane@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.RecordUserChange(ctx, "jane@example.com", model.UserChange{Actor: "jane@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the history is gone by the time the user document fails to go, and
+	// the user is still there to be erased again
+	primary.fail("Commit", codes.Internal, 1)
+	if _, err := u.DeleteUser(ctx, "jane@example.com"); errors.CodeFrom(err) != 500 {
+		t.Fatalf("expected the failed commit to fail the erasure, got %v", err)
+	}
+	if primary.doc("users/jane@example.com") == nil {
+		t.Fatal("expected the user to survive the failed erasure")
+	}
+
+	tombstone, err := u.DeleteUser(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error retrying the erasure: %v", err)
+	}
+	again, err := u.DeleteUser(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("expected erasing an erased user to return its tombstone, got %v", err)
+	}
+	if again.EmailHash != tombstone.EmailHash {
+		t.Errorf("expected tombstone %+v, got %+v", tombstone, again)
+	}
+	if n := primary.count("users/jane@example.com/history"); n != 0 {
+		t.Errorf("expected the history to be erased, got %d entries", n)
+	}
+}
+
+func TestReadUnmigratedUsers(t *testing.T) {
+	ctx := context.Background()
+	u, fakes := newFakeUserRepository(t, 0)
+
+	created := time.Date(2022, 9, 1, 14, 30, 5, 123456000, time.UTC)
+	for _, email := range []string{"jane@example.com", "john@example.com"} {
+		fakes[0].put("users/"+email, map[string]*pb.Value{
+			"email":      {ValueType: &pb.Value_StringValue{StringValue: email}},
+			"email_key":  {ValueType: &pb.Value_StringValue{StringValue: email}},
+			"created_at": {ValueType: &pb.Value_StringValue{StringValue: created.String()}},
+		})
+	}
+	if _, err := u.CreateUser(ctx, model.User{Email: "new@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := u.GetUser(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("expected an unmigrated user to be read, got %v", err)
+	}
+	if !user.CreatedAt.Equal(created) || !user.UpdatedAt.Equal(created) {
+		t.Errorf("expected created_at and updated_at %v, got %v and %v", created, user.CreatedAt, user.UpdatedAt)
+	}
+
+	// unmigrated users come last, and paging through them ends
+	var emails []string
+	cursor := ""
+	for i := 0; i < 5; i++ {
+		page, err := u.ListUsers(ctx, UserFilter{}, cursor, 1)
+		if err != nil {
+			t.Fatalf("unexpected error listing users: %v", err)
+		}
+		for _, user := range page.Users {
+			emails = append(emails, user.Email)
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if want := []string{"new@example.com", "jane@example.com", "john@example.com"}; !reflect.DeepEqual(emails, want) {
+		t.Errorf("expected %v, got %v", want, emails)
+	}
+}
+
+func TestListUsersWithoutEmailKeys(t *testing.T) {
+	ctx := context.Background()
+	u, fakes := newFakeUserRepository(t, 0)
+
+	// documents from before email keys, all created at once and stored
+	// under the address as it was typed
+	created := timestamppb.New(time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC))
+	ids := []string{"Jane@Example.com", "amy@example.com", "john@example.com", "zed@example.com"}
+	for _, id := range ids {
+		fakes[0].put("users/"+id, map[string]*pb.Value{
+			"email":      {ValueType: &pb.Value_StringValue{StringValue: id}},
+			"created_at": {ValueType: &pb.Value_TimestampValue{TimestampValue: created}},
+		})
+	}
+
+	var emails []string
+	cursor := ""
+	for i := 0; i < len(ids)+1; i++ {
+		page, err := u.ListUsers(ctx, UserFilter{}, cursor, 1)
+		if err != nil {
+			t.Fatalf("unexpected error listing users: %v", err)
+		}
+		for _, user := range page.Users {
+			emails = append(emails, user.Email)
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if !reflect.DeepEqual(emails, ids) {
+		t.Errorf("expected every user once in document order %v, got %v", ids, emails)
+	}
+}
+
+func TestMergeDuplicateUsers(t *testing.T) {
+	ctx := context.Background()
+	u, fakes := newFakeUserRepository(t, 0)
+	defer func(n int) { scanPageSize = n }(scanPageSize)
+	scanPageSize = 1
+
+	created := timestamppb.New(time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC))
+	for _, doc := range []struct {
+		id, city string
+		enrolled bool
+	}{
+		{"Jane@Example.com", "", true},
+		{"jane@example.com", "Austin", false},
+		{"john@example.com", "", false},
+	} {
+		fakes[0].put("users/"+doc.id, map[string]*pb.Value{
+			"email":      {ValueType: &pb.Value_StringValue{StringValue: doc.id}},
+			"city":       {ValueType: &pb.Value_StringValue{StringValue: doc.city}},
+			"enrolled":   {ValueType: &pb.Value_BooleanValue{BooleanValue: doc.enrolled}},
+			"created_at": {ValueType: &pb.Value_TimestampValue{TimestampValue: created}},
+		})
+	}
+	if _, err := u.CreateUser(ctx, model.User{Email: "new@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	merges, err := u.MergeDuplicateUsers(ctx, true)
+	if err != nil {
+		t.Fatalf("unexpected error planning merges: %v", err)
+	}
+	want := []UserMerge{
+		{Key: "jane@example.com", Kept: "Jane@Example.com", Merged: []string{"jane@example.com"}},
+		{Key: "john@example.com", Kept: "john@example.com", Merged: []string{}},
+	}
+	if !reflect.DeepEqual(merges, want) {
+		t.Fatalf("expected %+v, got %+v", want, merges)
+	}
+	if fakes[0].doc("users/Jane@Example.com") == nil {
+		t.Fatal("expected a dry run to leave the users alone")
+	}
+
+	if _, err := u.MergeDuplicateUsers(ctx, false); err != nil {
+		t.Fatalf("unexpected error merging: %v", err)
+	}
+	if fakes[0].doc("users/Jane@Example.com") != nil {
+		t.Error("expected the merged document to be gone")
+	}
+	user, err := u.GetUser(ctx, "JANE@example.com")
+	if err != nil || !user.Enrolled || user.City != "Austin" || user.EmailKey != "jane@example.com" {
+		t.Errorf("expected the enrolled user with the city of the merged one, got %+v, %v", user, err)
+	}
+	if merges, err := u.MergeDuplicateUsers(ctx, false); err != nil || len(merges) != 0 {
+		t.Errorf("expected nothing left to merge, got %+v, %v", merges, err)
+	}
+}
+
+func TestFlagSharedLinkedIn(t *testing.T) {
+	ctx := context.Background()
+	u, _ := newFakeUserRepository(t, 0)
+	defer func(n int) { scanPageSize = n }(scanPageSize)
+	scanPageSize = 1
+
+	for _, row := range []struct {
+		email, url, slug     string
+		enrolled, waitlisted bool
+	}{
+		{"a@example.com", "https://www.linkedin.com/in/jane-doe/", "jane-doe", true, false},
+		// not migrated yet, so grouped by the vanity name of its URL
+		{"b@example.com", "http://uk.linkedin.com/in/Jane-Doe", "", false, true},
+		{"c@example.com", "https://www.linkedin.com/in/jane-doe/", "jane-doe", false, false},
+		{"d@example.com", "https://www.linkedin.com/in/john-roe/", "john-roe", true, false},
+	} {
+		user, err := u.CreateUser(ctx, model.User{Email: row.email})
+		if err != nil {
+			t.Fatal(err)
+		}
+		update := *user
+		update.LinkedInURL, update.LinkedInSlug = row.url, row.slug
+		update.Enrolled, update.Waitlisted = row.enrolled, row.waitlisted
+		if _, err := u.UpdateUser(ctx, update, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flagged, err := u.FlagSharedLinkedIn(ctx, true)
+	if err != nil || len(flagged) != 2 {
+		t.Fatalf("expected two users to flag, got %+v, %v", flagged, err)
+	}
+	if user, _ := u.GetUser(ctx, "a@example.com"); user.LinkedInShared {
+		t.Fatal("expected a dry run to flag nobody")
+	}
+
+	if _, err := u.FlagSharedLinkedIn(ctx, false); err != nil {
+		t.Fatalf("unexpected error flagging: %v", err)
+	}
+	for email, want := range map[string]bool{
+		"a@example.com": true,
+		"b@example.com": true,
+		"c@example.com": false,
+		"d@example.com": false,
+	} {
+		if user, err := u.GetUser(ctx, email); err != nil || user.LinkedInShared != want {
+			t.Errorf("expected %s to have linkedin_shared=%v, got %+v, %v", email, want, user, err)
+		}
+	}
+
+	if flagged, err := u.FlagSharedLinkedIn(ctx, false); err != nil || len(flagged) != 0 {
+		t.Errorf("expected flagged users to be left out, got %+v, %v", flagged, err)
+	}
+}
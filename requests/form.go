@@ -0,0 +1,335 @@
+package requests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/form"
+	"github.com/thealamu/linkedinsignin/model"
+	"github.com/thealamu/linkedinsignin/options"
+)
+
+type (
+	// Form decodes, validates and stores the enrollment form as its schema
+	// describes it.
+	Form struct {
+		schema     form.Schema
+		order      []string
+		rules      Rules
+		validators map[string][]boundValidator
+		fields     map[string]int
+		answers    map[string]string
+	}
+
+	// boundValidator is a validator named by a question, with the message
+	// of that question and the key of the question it is related to.
+	boundValidator struct {
+		validator
+		message string
+		related string
+	}
+)
+
+// ownedUserFields are kept by the repository and the controllers, and can't
+// be the store of a question even though an update may change them. So are
+// the timestamps, whose names end in _at.
+var ownedUserFields = map[string]bool{
+	"email":           true,
+	"email_key":       true,
+	"cohort_id":       true,
+	"enrolled":        true,
+	"waitlisted":      true,
+	"phone_e164":      true,
+	"linkedin_slug":   true,
+	"linkedin_shared": true,
+	"answers":         true,
+	"schema_version":  true,
+}
+
+// NewForm checks schema against what can be stored and builds its rules.
+// writable lists the firestore names of the model.User fields an update may
+// change; questions can only be stored in one of them that isn't owned by
+// the application, or in Answers when it is writable itself. The choices of
+// catalog, which may be nil, replace those of the schema, and every field it
+// lists must be a question. Every choice question must get its choices from
+// one or the other.
+func NewForm(schema *form.Schema, catalog *options.Catalog, writable []string) (*Form, error) {
+	allowed := make(map[string]bool, len(writable))
+	for _, path := range writable {
+		allowed[path] = true
+	}
+	tags := userStringFields()
+
+	f := &Form{
+		schema:     *schema,
+		rules:      make(Rules, len(schema.Questions)),
+		validators: make(map[string][]boundValidator),
+		fields:     make(map[string]int),
+		answers:    make(map[string]string),
+	}
+	f.schema.Questions = make([]form.Question, len(schema.Questions))
+
+	// the question each validator is named by, for the validators that
+	// need the answer to another question
+	checkedBy := make(map[string]string)
+	for _, q := range schema.Questions {
+		for _, name := range q.Validate {
+			checkedBy[name] = q.Key
+		}
+	}
+
+	for i, q := range schema.Questions {
+		if name, ok := q.Answer(); ok {
+			if !allowed["answers"] {
+				return nil, fmt.Errorf("question '%s' can't be stored in the answers", q.Key)
+			}
+			f.answers[q.Key] = name
+		} else {
+			index, ok := tags[q.Store]
+			if !ok || !allowed[q.Store] || ownedUserFields[q.Store] || strings.HasSuffix(q.Store, "_at") {
+				return nil, fmt.Errorf("question '%s' can't be stored in '%s'", q.Key, q.Store)
+			}
+			f.fields[q.Key] = index
+		}
+
+		if catalog.Has(q.Key) {
+			q.Options = catalog.Fields[q.Key]
+		}
+		if q.Type == form.TypeChoice && len(q.Options) == 0 {
+			return nil, fmt.Errorf("question '%s' is a choice, but neither the form schema nor the option catalog lists its choices", q.Key)
+		}
+		rules, err := questionRules(q)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range q.Validate {
+			v, ok := validators[name]
+			if !ok {
+				return nil, fmt.Errorf("question '%s' names unknown validator '%s'", q.Key, name)
+			}
+			bound := boundValidator{validator: v, message: invalidMessage(q, v.message)}
+			if v.related != "" {
+				if bound.related, ok = checkedBy[v.related]; !ok {
+					return nil, fmt.Errorf("question '%s' is checked with '%s', which needs a question checked with '%s'", q.Key, name, v.related)
+				}
+			}
+			f.validators[q.Key] = append(f.validators[q.Key], bound)
+		}
+
+		f.schema.Questions[i] = q
+		f.order = append(f.order, q.Key)
+		f.rules[q.Key] = rules
+	}
+
+	if catalog != nil {
+		for field := range catalog.Fields {
+			if _, ok := f.rules[field]; !ok {
+				return nil, fmt.Errorf("option catalog lists '%s', which the form doesn't ask", field)
+			}
+		}
+	}
+	return f, nil
+}
+
+// Schema returns the schema with the choices of the option catalog filled
+// in, as the frontend should render it.
+func (f *Form) Schema() form.Schema {
+	return f.schema
+}
+
+// Decode reads the answers of the form from a JSON object. Keys that aren't
+// questions are ignored and null counts as no answer; any other value that
+// isn't a string is reported in a 422.
+func (f *Form) Decode(r io.Reader) (map[string]string, error) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&body); err != nil {
+		return nil, errors.From(err, "Invalid Request Body", 400)
+	}
+
+	answers := make(map[string]string, len(f.order))
+	var failed []errors.FieldError
+	for _, q := range f.schema.Questions {
+		switch v := body[q.Key].(type) {
+		case nil:
+		case string:
+			// the frontend sends the string null when no picture was
+			// uploaded
+			if q.Type == form.TypePhoto && v == "null" {
+				v = ""
+			}
+			answers[q.Key] = v
+		default:
+			failed = append(failed, errors.FieldError{Field: q.Key, Code: CodeType, Message: invalidMessage(q, "")})
+		}
+	}
+	if len(failed) > 0 {
+		return nil, errors.Invalid(failed)
+	}
+	return answers, nil
+}
+
+// Answers reads the answers of the form back from user.
+func (f *Form) Answers(user *model.User) map[string]string {
+	v := reflect.ValueOf(user).Elem()
+	answers := make(map[string]string, len(f.order))
+	for _, key := range f.order {
+		if name, ok := f.answers[key]; ok {
+			answers[key] = user.Answers[name]
+		} else {
+			answers[key] = v.Field(f.fields[key]).String()
+		}
+	}
+	return answers
+}
+
+// Apply validates answers and stores them in user, normalized. Every invalid
+// answer is reported at once, in a 422, and nothing is stored unless all
+// are valid. Questions left unanswered leave what user holds as it is.
+func (f *Form) Apply(answers map[string]string, user *model.User) error {
+	if failed := f.validate(answers); len(failed) > 0 {
+		return errors.Invalid(failed)
+	}
+
+	v := reflect.ValueOf(user).Elem()
+	for i, key := range f.order {
+		answer := answers[key]
+		if answer == "" {
+			continue
+		}
+		answer = canonicalAnswer(f.schema.Questions[i], answer)
+		for _, bound := range f.validators[key] {
+			if bound.normalize != nil {
+				answer = bound.normalize(answer, answers[bound.related])
+			}
+		}
+
+		if name, ok := f.answers[key]; ok {
+			if user.Answers == nil {
+				user.Answers = make(model.Answers)
+			}
+			user.Answers[name] = answer
+			continue
+		}
+		v.Field(f.fields[key]).SetString(answer)
+	}
+	return nil
+}
+
+// validate checks each answer with the rules of its question, then with
+// its validators. Answers left empty skip the validators.
+func (f *Form) validate(answers map[string]string) []errors.FieldError {
+	var failed []errors.FieldError
+	for _, key := range f.order {
+		answer := answers[key]
+		if fe := checkField(key, answer, f.rules[key]); fe != nil {
+			failed = append(failed, *fe)
+			continue
+		}
+		if answer == "" {
+			continue
+		}
+
+		for _, bound := range f.validators[key] {
+			ok, suggestions := bound.check(answer, answers[bound.related])
+			if ok {
+				continue
+			}
+			message := bound.message
+			if len(suggestions) > 0 {
+				message = fmt.Sprintf("%s. Did you mean %s?", message, strings.Join(suggestions, " or "))
+			}
+			failed = append(failed, errors.FieldError{Field: key, Code: bound.code, Message: message, Suggestions: suggestions})
+			break
+		}
+	}
+	return failed
+}
+
+// questionRules builds the rules of q that are given in the schema itself.
+// Apart from Required, they only check answers that were given, so an
+// optional question may be left empty.
+func questionRules(q form.Question) ([]Rule, error) {
+	var rules []Rule
+	if q.Required {
+		message := q.RequiredMessage
+		if message == "" {
+			message = fmt.Sprintf("Missing Fields! %s is required", q.Label)
+		}
+		rules = append(rules, Required(message))
+	}
+
+	if len(q.Options) > 0 {
+		choices := q.Options
+		rules = append(rules, Custom(CodeEnum, "Please Choose One of the Listed Options", func(v string) bool {
+			_, ok := options.Find(choices, v)
+			return ok
+		}))
+	}
+	if len(q.Accept) > 0 {
+		rules = append(rules, OneOf(invalidMessage(q, ""), q.Accept...))
+	}
+	if q.Pattern != "" {
+		re, err := regexp.Compile(q.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("question '%s' has an invalid pattern: %w", q.Key, err)
+		}
+		rules = append(rules, Matches(invalidMessage(q, ""), re))
+	}
+
+	for i, rule := range rules {
+		if rule.Code == CodeRequired {
+			continue
+		}
+		check := rule.Check
+		rules[i].Check = func(v string) bool {
+			return v == "" || check(v)
+		}
+	}
+	return rules, nil
+}
+
+// canonicalAnswer spells a valid answer to q the way its choices do, as
+// choices are matched regardless of case.
+func canonicalAnswer(q form.Question, answer string) string {
+	if o, ok := options.Find(q.Options, answer); ok {
+		return o.Value
+	}
+	for _, accepted := range q.Accept {
+		if strings.EqualFold(answer, accepted) {
+			return accepted
+		}
+	}
+	return answer
+}
+
+// invalidMessage is the message of a failed check of q, preferring the
+// question's own over fallback.
+func invalidMessage(q form.Question, fallback string) string {
+	if q.InvalidMessage != "" {
+		return q.InvalidMessage
+	}
+	if fallback != "" {
+		return fallback
+	}
+	return "Invalid " + q.Label
+}
+
+// userStringFields maps the firestore tag of each string field of
+// model.User to the field's index.
+func userStringFields() map[string]int {
+	t := reflect.TypeOf(model.User{})
+	fields := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("firestore"), ",")[0]
+		if tag != "" && tag != "-" && t.Field(i).Type.Kind() == reflect.String {
+			fields[tag] = i
+		}
+	}
+	return fields
+}
@@ -0,0 +1,250 @@
+package requests
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/form"
+	"github.com/thealamu/linkedinsignin/model"
+	"github.com/thealamu/linkedinsignin/options"
+	"github.com/thealamu/linkedinsignin/repository"
+)
+
+func TestApplyReportsEveryField(t *testing.T) {
+	f := newTestForm(t, nil)
+	answers := validAnswers()
+	answers["linkedin_url"] = "https://example.com/jane"
+	answers["city"] = "Austin 2"
+	answers["gender"] = ""
+	answers["can_work_in_usa"] = "No"
+	answers["phone"] = "555-0100"
+
+	var user model.User
+	err := f.Apply(answers, &user)
+	if errors.CodeFrom(err) != 422 {
+		t.Fatalf("expected 422, got %v", err)
+	}
+
+	want := []errors.FieldError{
+		{Field: "linkedin_url", Code: "linkedin_url", Message: "Invalid LinkedIn URL"},
+		{Field: "phone", Code: CodePhone, Message: "Invalid Phone Number"},
+		{Field: "gender", Code: CodeRequired, Message: "Missing Fields! Gender is required"},
+		{Field: "can_work_in_usa", Code: CodeEnum, Message: "It is Required that You can Work in the USA"},
+		{Field: "city", Code: CodeNoDigits, Message: "Invalid City"},
+	}
+	got := err.(errors.Error).Fields
+	if len(got) != len(want) {
+		t.Fatalf("expected %d field errors, got %+v", len(want), got)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("expected %+v, got %+v", want[i], got[i])
+		}
+	}
+	if user.City != "" {
+		t.Errorf("expected nothing applied from an invalid form, got %+v", user)
+	}
+
+	if err := f.Apply(validAnswers(), &user); err != nil {
+		t.Fatalf("unexpected error applying a valid form: %v", err)
+	}
+	if user.City != "Austin" || user.OptionalMajor != "Biology" || user.Phone != "(512) 555-0100" ||
+		user.LinkedInURL != "https://www.linkedin.com/in/jane/" {
+		t.Errorf("expected the form to be applied, got %+v", user)
+	}
+}
+
+func TestApplyCatalog(t *testing.T) {
+	catalog, err := options.Parse([]byte(`{"version": "1", "fields": {"hours_per_week": [{"value": "15-20"}, {"value": "20+"}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newTestForm(t, catalog)
+
+	answers := validAnswers()
+	answers["hours_per_week"] = "15-20"
+	answers["can_work_in_usa"] = "yes"
+	var user model.User
+	if err := f.Apply(answers, &user); err != nil {
+		t.Fatalf("unexpected error applying catalog options: %v", err)
+	}
+	if user.HoursPerWeek != "15-20" || user.CanWorkInUSA != "Yes" {
+		t.Errorf("expected answers spelled as their choices, got %+v", user)
+	}
+
+	answers["hours_per_week"] = "a lot"
+	answers["referral"] = ""
+	err = f.Apply(answers, &model.User{})
+	fields := err.(errors.Error).Fields
+	if len(fields) != 2 || fields[0].Field != "hours_per_week" || fields[0].Code != CodeEnum || fields[1].Code != CodeRequired {
+		t.Fatalf("expected an enum and a required error, got %+v", fields)
+	}
+
+	schema, _ := form.Load("")
+	unknown := &options.Catalog{Version: "1", Fields: map[string][]options.Option{"gendre": {{Value: "Female"}}}}
+	if _, err := NewForm(schema, unknown, repository.UpdatableUserFields()); err == nil {
+		t.Fatal("expected an unknown catalog field to be refused")
+	}
+	if _, err := NewForm(schema, nil, repository.UpdatableUserFields()); err == nil {
+		t.Fatal("expected choice questions without choices to be refused")
+	}
+}
+
+func TestFormStorage(t *testing.T) {
+	schema, err := form.Parse([]byte(`{"version": "1", "questions": [
+		{"key": "city", "label": "City", "type": "text", "required": true, "store": "city"},
+		{"key": "pronouns", "label": "Pronouns", "type": "text", "store": "answers.pronouns"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writable := repository.UpdatableUserFields()
+	f, err := NewForm(schema, nil, writable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answers, err := f.Decode(strings.NewReader(`{"city": "Austin", "pronouns": "she/her", "timezone": "CST"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user model.User
+	if err := f.Apply(answers, &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.City != "Austin" || user.Answers["pronouns"] != "she/her" || len(user.Answers) != 1 {
+		t.Fatalf("unexpected user %+v", user)
+	}
+	if got := f.Answers(&user); got["pronouns"] != "she/her" || got["city"] != "Austin" {
+		t.Fatalf("unexpected answers read back %+v", got)
+	}
+
+	// an optional question left unanswered keeps what was stored
+	if err := f.Apply(map[string]string{"city": "Dallas"}, &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.City != "Dallas" || user.Answers["pronouns"] != "she/her" {
+		t.Fatalf("expected the stored pronouns to be kept, got %+v", user)
+	}
+
+	_, err = f.Decode(strings.NewReader(`{"city": 12}`))
+	if fields := err.(errors.Error).Fields; len(fields) != 1 || fields[0].Code != CodeType {
+		t.Fatalf("expected a type error, got %v", err)
+	}
+
+	for _, store := range []string{"email", "enrolled", "cohort_id", "phone_e164", "linkedin_slug", "nonexistent"} {
+		schema.Questions[0].Store = store
+		if _, err := NewForm(schema, nil, writable); err == nil {
+			t.Errorf("expected storing in '%s' to be refused", store)
+		}
+	}
+	schema.Questions[0].Store = "city"
+	schema.Questions[0].Validate = []string{"astrology"}
+	if _, err := NewForm(schema, nil, writable); err == nil {
+		t.Error("expected an unknown validator to be refused")
+	}
+}
+
+// testChoices are the catalog choices of the default schema's questions that
+// list none of their own.
+const testChoices = `{"version": "1", "fields": {
+	"gender": [{"value": "Female"}, {"value": "Male"}],
+	"age_group": [{"value": "18-24"}, {"value": "25-34"}],
+	"employment_status": [{"value": "Employed"}, {"value": "Unemployed"}],
+	"highest_school": [{"value": "High School"}, {"value": "Bachelor"}],
+	"learning_track": [{"value": "Frontend"}, {"value": "Backend"}],
+	"hours_per_week": [{"value": "10"}, {"value": "15"}],
+	"referral": [{"value": "Friend"}, {"value": "Social Media"}],
+	"professional_experience": [{"value": "None"}, {"value": "1-3 years"}],
+	"prior_knowledge": [{"value": "None"}, {"value": "Some"}]
+}}`
+
+// newTestForm builds the default schema with the test choices, those of
+// catalog taking their place.
+func newTestForm(t *testing.T, catalog *options.Catalog) *Form {
+	schema, err := form.Load("")
+	if err != nil {
+		t.Fatalf("unexpected error loading the default schema: %v", err)
+	}
+	choices, err := options.Parse([]byte(testChoices))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if catalog != nil {
+		for field, opts := range catalog.Fields {
+			choices.Fields[field] = opts
+		}
+	}
+	f, err := NewForm(schema, choices, repository.UpdatableUserFields())
+	if err != nil {
+		t.Fatalf("default schema does not fit: %v", err)
+	}
+	return f
+}
+
+func validAnswers() map[string]string {
+	return map[string]string{
+		"linkedin_url":            "https://www.linkedin.com/in/jane",
+		"phone":                   "+1 (512) 555-0100",
+		"representation":          "Woman",
+		"gender":                  "Female",
+		"age_group":               "25-34",
+		"employment_status":       "Unemployed",
+		"highest_school":          "Bachelor",
+		"field_of_study":          "Biology",
+		"can_work_in_usa":         "Yes",
+		"learning_track":          "Frontend",
+		"hours_per_week":          "15",
+		"referral":                "Friend",
+		"photo":                   "https://example.com/jane.png",
+		"city":                    "Austin",
+		"state":                   "TX",
+		"professional_experience": "None",
+		"industries":              "Retail, Healthcare",
+		"prior_knowledge":         "Some",
+	}
+}
+
+func TestApplyPlaces(t *testing.T) {
+	f := newTestForm(t, nil)
+
+	answers := validAnswers()
+	answers["state"] = "N.Y."
+	answers["city"] = "brooklyn"
+	var user model.User
+	if err := f.Apply(answers, &user); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.State != "NY" || user.City != "Brooklyn" {
+		t.Errorf("expected the state and city to be canonical, got %s, %s", user.State, user.City)
+	}
+
+	answers["city"] = " Cooperstown "
+	if err := f.Apply(answers, &user); err != nil {
+		t.Fatalf("expected a town the gazetteer doesn't know to be accepted, got %v", err)
+	}
+	if user.City != "Cooperstown" {
+		t.Errorf("expected the town to be stored as given, got %s", user.City)
+	}
+
+	answers["city"] = "Rochestre"
+	err := f.Apply(answers, &user)
+	want := errors.FieldError{
+		Field:       "city",
+		Code:        CodeCity,
+		Message:     "Invalid City. Did you mean Rochester?",
+		Suggestions: []string{"Rochester"},
+	}
+	if fields := err.(errors.Error).Fields; len(fields) != 1 || !reflect.DeepEqual(fields[0], want) {
+		t.Fatalf("expected %+v, got %v", want, err)
+	}
+
+	answers["city"] = "Ithaca"
+	answers["state"] = "New Yrok"
+	err = f.Apply(answers, &user)
+	if fields := err.(errors.Error).Fields; len(fields) != 1 || fields[0].Code != CodeState || fields[0].Suggestions[0] != "New York" {
+		t.Fatalf("expected a state error with a suggestion, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package requests
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/thealamu/linkedinsignin/errors"
+)
+
+// Codes of the built in rules, as reported in errors.FieldError.
+const (
+	CodeRequired = "required"
+	CodeEnum     = "enum"
+	CodePattern  = "pattern"
+	CodeNoDigits = "no_digits"
+	CodeType     = "type"
+	CodePhone    = "phone"
+	CodeState    = "state"
+	CodeCity     = "city"
+)
+
+type (
+	// Rule checks a single string field. Message is shown when the check
+	// fails.
+	Rule struct {
+		Code    string
+		Message string
+		Check   func(value string) bool
+	}
+
+	// Rules maps the name of each field of a request to the rules it must
+	// pass, in the order they are checked.
+	Rules map[string][]Rule
+)
+
+func Required(message string) Rule {
+	return Rule{Code: CodeRequired, Message: message, Check: func(v string) bool {
+		return strings.TrimSpace(v) != ""
+	}}
+}
+
+// OneOf only accepts the values given, in any case.
+func OneOf(message string, values ...string) Rule {
+	return Rule{Code: CodeEnum, Message: message, Check: func(v string) bool {
+		for _, allowed := range values {
+			if strings.EqualFold(v, allowed) {
+				return true
+			}
+		}
+		return false
+	}}
+}
+
+func Matches(message string, re *regexp.Regexp) Rule {
+	return Rule{Code: CodePattern, Message: message, Check: re.MatchString}
+}
+
+func NoDigits(message string) Rule {
+	return Rule{Code: CodeNoDigits, Message: message, Check: func(v string) bool {
+		return !hasNumbers(v)
+	}}
+}
+
+// Custom is a rule reported under code.
+func Custom(code, message string, check func(string) bool) Rule {
+	return Rule{Code: code, Message: message, Check: check}
+}
+
+// checkField returns the error of the first rule value fails, if any.
+func checkField(name, value string, rules []Rule) *errors.FieldError {
+	for _, rule := range rules {
+		if !rule.Check(value) {
+			return &errors.FieldError{Field: name, Code: rule.Code, Message: rule.Message}
+		}
+	}
+	return nil
+}
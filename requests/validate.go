@@ -0,0 +1,147 @@
+package requests
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/thealamu/linkedinsignin/errors"
+	"github.com/thealamu/linkedinsignin/linkedin"
+	"github.com/thealamu/linkedinsignin/phone"
+	"github.com/thealamu/linkedinsignin/places"
+)
+
+// validator is a check a form schema can name in a question's validate
+// list. Its message gives way to the question's own.
+//
+// check may offer suggestions along with a failure. It is given the answer
+// to the question and, when related names another validator, the answer to
+// the question checked by that one. normalize, when set, rewrites answers
+// that passed every check before they are stored.
+type validator struct {
+	code      string
+	message   string
+	related   string
+	check     func(value, related string) (bool, []string)
+	normalize func(value, related string) string
+}
+
+var validators = map[string]validator{
+	"linkedin": {
+		code:    "linkedin_url",
+		message: "Invalid LinkedIn URL",
+		check: func(v, _ string) (bool, []string) {
+			_, err := linkedin.ParseProfileURL(v)
+			return err == nil, nil
+		},
+		normalize: func(v, _ string) string {
+			canonical, _ := linkedin.CanonicalProfileURL(v)
+			return canonical
+		},
+	},
+	"no_digits": fromRule(NoDigits("")),
+	"industries": fromRule(Custom(CodeNoDigits, "One of the Industries is invalid", func(v string) bool {
+		return validateIndustries(v) == nil
+	})),
+	"phone": {
+		code:    CodePhone,
+		message: "Invalid Phone Number",
+		check: func(v, _ string) (bool, []string) {
+			return phone.Valid(v), nil
+		},
+		normalize: func(v, _ string) string {
+			display, _ := phone.Normalize(v)
+			return display
+		},
+	},
+	"us_state": {
+		code: CodeState,
+		check: func(v, _ string) (bool, []string) {
+			if _, ok := places.US.State(v); ok {
+				return true, nil
+			}
+			return false, places.US.SuggestStates(v)
+		},
+		normalize: func(v, _ string) string {
+			state, _ := places.US.State(v)
+			return state.Code
+		},
+	},
+	// us_city only turns down near misses of the major cities of the state,
+	// since the gazetteer doesn't know every town
+	"us_city": {
+		code:    CodeCity,
+		related: "us_state",
+		check: func(v, state string) (bool, []string) {
+			suggestions := places.US.SuggestCities(state, v)
+			return len(suggestions) == 0, suggestions
+		},
+		normalize: func(v, state string) string {
+			if city, ok := places.US.City(state, v); ok {
+				return city
+			}
+			return strings.TrimSpace(v)
+		},
+	},
+}
+
+// fromRule makes a validator of a rule that needs nothing but the answer.
+func fromRule(rule Rule) validator {
+	return validator{
+		code:    rule.Code,
+		message: rule.Message,
+		check: func(v, _ string) (bool, []string) {
+			return rule.Check(v), nil
+		},
+	}
+}
+
+func isAlphaNum(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsNumber(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlpha(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasNumbers(s string) bool {
+	for _, r := range s {
+		if unicode.IsNumber(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNum(s string) bool {
+	for _, r := range s {
+		if !unicode.IsNumber(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func validateIndustries(s string) error {
+	industries := strings.Split(s, ",")
+	if len(industries) < 1 {
+		return errors.New("Missing Fields! Please add at least one Industry", 400)
+	}
+	for _, industry := range industries {
+		industry = strings.TrimSpace(industry)
+		if hasNumbers(industry) {
+			return errors.New("One of the Industries is invalid", 400)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// adminOnly lets a request through when it carries apiKey as a bearer token.
+// With an empty apiKey every request is refused, so admin endpoints stay
+// closed unless they are explicitly configured.
+func adminOnly(apiKey string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if apiKey == "" {
+				return c.JSON(http.StatusForbidden, map[string]interface{}{
+					"error": "Admin API is Disabled",
+				})
+			}
+
+			token := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(apiKey)) != 1 {
+				return c.JSON(http.StatusUnauthorized, map[string]interface{}{
+					"error": "Unauthorized",
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
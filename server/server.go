@@ -2,10 +2,12 @@ package server
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -15,10 +17,14 @@ import (
 	"github.com/thealamu/linkedinsignin/controllers"
 	"github.com/thealamu/linkedinsignin/email"
 	"github.com/thealamu/linkedinsignin/linkedin"
+	"github.com/thealamu/linkedinsignin/options"
+	"github.com/thealamu/linkedinsignin/places"
 	"github.com/thealamu/linkedinsignin/repository"
+	"github.com/thealamu/linkedinsignin/requests"
+	"github.com/thealamu/linkedinsignin/stats"
 )
 
-func registerRoutes(e *echo.Echo, cts *controllers.Container, rc *repository.Container, service linkedin.Service, emailer email.Emailer) {
+func registerRoutes(e *echo.Echo, env config.Environment, cts *controllers.Container, rc *repository.Container, service linkedin.Service, emailer email.Emailer, statsService stats.Service, catalog *options.Catalog, enrollment *requests.Form) {
 	e.Use(middleware.Logger())
 	// allow all origins
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
@@ -33,19 +39,53 @@ func registerRoutes(e *echo.Echo, cts *controllers.Container, rc *repository.Con
 	api.GET("/health", func(c echo.Context) error {
 		return c.String(http.StatusOK, "Backend! OK")
 	})
+	api.GET("/options", cts.OptionsController.GetOptions(catalog))
+	api.GET("/form", cts.OptionsController.GetForm(enrollment))
+	api.GET("/places/states", cts.PlacesController.GetStates(places.US))
+	api.GET("/places/cities", cts.PlacesController.CompleteCities(places.US))
+	admin := adminOnly(env[config.AdminAPIKey])
+	// config.New has already validated the page size
+	maxPageSize, _ := strconv.Atoi(env[config.UsersPageSizeMax])
+
+	enroll := controllers.Enrollment{
+		Users:   rc.UserRepository,
+		Cohorts: rc.CohortRepository,
+		Emailer: emailer,
+		Form:    enrollment,
+	}
+
 	{
-		// users := api.Group("/users")
+		users := api.Group("/users")
 
 		// users.POST("", cts.UserController.CreateUser(rc.UserRepository, service))
-		// users.PUT("/:email", cts.UserController.UpdateUser(rc.UserRepository, rc.UserRepository, emailer))
+		// users.PUT("/:email", cts.UserController.UpdateUser(enroll))
 		// users.GET("/:email", cts.UserController.GetUser(rc.UserRepository))
+		users.GET("", cts.UserController.ListUsers(rc.UserRepository, maxPageSize), admin)
+		users.POST("/import", cts.UserController.ImportUsers(rc.UserRepository, repository.EmailPolicyFrom(env), enrollment), admin)
+		users.DELETE("/:email", cts.UserController.DeleteUser(rc.UserRepository, rc.UserRepository, rc.CohortRepository), admin)
+		users.GET("/:email/history", cts.UserController.GetUserHistory(rc.UserRepository), admin)
+		users.GET("/:email/export", cts.UserController.ExportUser(rc.UserRepository), admin)
+	}
+	api.GET("/admin/users/export", cts.UserController.ExportUsers(rc.UserRepository), admin)
+	api.GET("/admin/stats", cts.StatsController.GetStats(statsService), admin)
+	// expvar counters, e.g. the user reads each firestore replica has served
+	api.GET("/admin/vars", echo.WrapHandler(expvar.Handler()), admin)
+	{
+		cohorts := api.Group("/admin/cohorts", admin)
+
+		cohorts.POST("", cts.CohortController.SaveCohort(rc.CohortRepository))
+		cohorts.GET("", cts.CohortController.ListCohorts(rc.CohortRepository))
+		cohorts.GET("/:id", cts.CohortController.GetCohort(rc.CohortRepository))
+		cohorts.GET("/:id/seats", cts.CohortController.GetSeats(rc.CohortRepository))
+		cohorts.POST("/:id/seats/reconcile", cts.CohortController.ReconcileSeats(rc.CohortRepository))
+		cohorts.POST("/:id/promote", cts.UserController.PromoteWaitlist(enroll))
 	}
 }
 
-func Start(logger zerolog.Logger, env config.Environment, cts *controllers.Container, rc *repository.Container, service linkedin.Service, emailer email.Emailer) error {
+func Start(logger zerolog.Logger, env config.Environment, cts *controllers.Container, rc *repository.Container, service linkedin.Service, emailer email.Emailer, statsService stats.Service, catalog *options.Catalog, enrollment *requests.Form) error {
 	e := echo.New()
 
-	registerRoutes(e, cts, rc, service, emailer)
+	registerRoutes(e, env, cts, rc, service, emailer, statsService, catalog, enrollment)
 
 	srv := &http.Server{
 		ReadTimeout:  10 * time.Second,
@@ -56,7 +96,9 @@ func Start(logger zerolog.Logger, env config.Environment, cts *controllers.Conta
 
 	// gracefully start server
 	go func() {
-		if err := e.StartServer(srv); err != nil {
+		// Shutdown makes StartServer return ErrServerClosed, which is how the
+		// server is meant to stop
+		if err := e.StartServer(srv); err != nil && err != http.ErrServerClosed {
 			logger.Fatal().Err(err).Msg("Failed to start server")
 		}
 	}()
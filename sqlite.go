@@ -0,0 +1,11 @@
+//go:build sqlite
+// +build sqlite
+
+package main
+
+// The sqlite3 driver needs cgo, so it is only linked into builds tagged
+// sqlite. Run the sql user store against a local file with
+//
+//	go build -tags sqlite
+//	USER_STORE=sql DATABASE_DRIVER=sqlite3 DATABASE_URL=users.db ./linkedinsignin
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,257 @@
+package stats
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/thealamu/linkedinsignin/config"
+	"github.com/thealamu/linkedinsignin/model"
+	"github.com/thealamu/linkedinsignin/repository"
+	"golang.org/x/sync/singleflight"
+)
+
+// pageSize is how many users are read at a time while aggregating.
+const pageSize = 200
+
+// aggregateTimeout bounds an aggregation. It runs on behalf of every caller
+// waiting for it, so it isn't cut short when the first of them goes away.
+const aggregateTimeout = 2 * time.Minute
+
+// seriesYears is how far back from the latest day the daily series goes. It
+// keeps a mistyped date from stretching the series over centuries.
+const seriesYears = 10
+
+// Unspecified counts the users who left a field empty.
+const Unspecified = "unspecified"
+
+type (
+	// Service aggregates the users of the repository for reporting.
+	Service interface {
+		Report(ctx context.Context) (*Report, error)
+	}
+
+	// Count is how many users signed in with a value and how many of them
+	// went on to enroll.
+	Count struct {
+		SignedIn       int     `json:"signed_in"`
+		Enrolled       int     `json:"enrolled"`
+		ConversionRate float64 `json:"conversion_rate"`
+	}
+
+	// Day is the activity of a single UTC day.
+	Day struct {
+		Date     string `json:"date"`
+		SignedIn int    `json:"signed_in"`
+		Enrolled int    `json:"enrolled"`
+	}
+
+	// Report holds the totals over all users, broken down by the fields we
+	// report on, along with a day by day series. Users enrolled before the
+	// time was recorded count towards the totals but not the series, as do
+	// days outside of it, see series.
+	Report struct {
+		GeneratedAt time.Time `json:"generated_at"`
+		Count
+		ByLearningTrack    map[string]*Count `json:"by_learning_track"`
+		ByState            map[string]*Count `json:"by_state"`
+		ByGender           map[string]*Count `json:"by_gender"`
+		ByAgeGroup         map[string]*Count `json:"by_age_group"`
+		ByEmploymentStatus map[string]*Count `json:"by_employment_status"`
+		ByReferral         map[string]*Count `json:"by_referral"`
+		Daily              []Day             `json:"daily"`
+	}
+
+	stats struct {
+		logger zerolog.Logger
+		users  repository.UserLister
+		ttl    time.Duration
+
+		group   singleflight.Group
+		mu      sync.Mutex
+		report  *Report
+		expires time.Time
+	}
+)
+
+func New(logger zerolog.Logger, env config.Environment, users repository.UserLister) Service {
+	// config.New has already validated the TTL
+	ttl, _ := time.ParseDuration(env[config.StatsCacheTTL])
+	return &stats{
+		logger: logger,
+		users:  users,
+		ttl:    ttl,
+	}
+}
+
+// Report returns the cached report while it is fresh, and aggregates a new
+// one otherwise. Callers arriving while it is being aggregated wait for it
+// rather than starting over.
+func (s *stats) Report(ctx context.Context) (*Report, error) {
+	s.mu.Lock()
+	report, expires := s.report, s.expires
+	s.mu.Unlock()
+	if report != nil && time.Now().Before(expires) {
+		return report, nil
+	}
+
+	result := s.group.DoChan("report", func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), aggregateTimeout)
+		defer cancel()
+
+		now := time.Now().UTC()
+		report, err := Aggregate(ctx, s.users, now)
+		if err != nil {
+			return nil, err
+		}
+		s.logger.Info().Msgf("aggregated stats over %d users", report.SignedIn)
+
+		s.mu.Lock()
+		s.report, s.expires = report, now.Add(s.ttl)
+		s.mu.Unlock()
+		return report, nil
+	})
+
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*Report), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Aggregate reads every user, a page at a time, and sums them up.
+func Aggregate(ctx context.Context, users repository.UserLister, now time.Time) (*Report, error) {
+	report := &Report{
+		GeneratedAt:        now,
+		ByLearningTrack:    make(map[string]*Count),
+		ByState:            make(map[string]*Count),
+		ByGender:           make(map[string]*Count),
+		ByAgeGroup:         make(map[string]*Count),
+		ByEmploymentStatus: make(map[string]*Count),
+		ByReferral:         make(map[string]*Count),
+	}
+	daily := make(map[string]*Day)
+
+	cursor := ""
+	for {
+		page, err := users.ListUsers(ctx, repository.UserFilter{}, cursor, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range page.Users {
+			report.add(user, daily)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	report.Count.rate()
+	for _, breakdown := range []map[string]*Count{
+		report.ByLearningTrack,
+		report.ByState,
+		report.ByGender,
+		report.ByAgeGroup,
+		report.ByEmploymentStatus,
+		report.ByReferral,
+	} {
+		for _, c := range breakdown {
+			c.rate()
+		}
+	}
+	report.Daily = series(daily, now)
+	return report, nil
+}
+
+func (r *Report) add(user model.User, daily map[string]*Day) {
+	r.Count.add(user)
+	tally(r.ByLearningTrack, user.LearningTrack, user)
+	tally(r.ByState, user.State, user)
+	tally(r.ByGender, user.Gender, user)
+	tally(r.ByAgeGroup, user.AgeGroup, user)
+	tally(r.ByEmploymentStatus, user.EmploymentStatus, user)
+	tally(r.ByReferral, user.Referral, user)
+
+	if !user.CreatedAt.IsZero() {
+		day(daily, user.CreatedAt).SignedIn++
+	}
+	if user.Enrolled && user.EnrolledAt != nil {
+		day(daily, *user.EnrolledAt).Enrolled++
+	}
+}
+
+func (c *Count) add(user model.User) {
+	c.SignedIn++
+	if user.Enrolled {
+		c.Enrolled++
+	}
+}
+
+func (c *Count) rate() {
+	if c.SignedIn > 0 {
+		c.ConversionRate = float64(c.Enrolled) / float64(c.SignedIn)
+	}
+}
+
+func tally(breakdown map[string]*Count, value string, user model.User) {
+	if value == "" {
+		value = Unspecified
+	}
+	c, ok := breakdown[value]
+	if !ok {
+		c = &Count{}
+		breakdown[value] = c
+	}
+	c.add(user)
+}
+
+func day(daily map[string]*Day, t time.Time) *Day {
+	date := t.UTC().Format("2006-01-02")
+	d, ok := daily[date]
+	if !ok {
+		d = &Day{Date: date}
+		daily[date] = d
+	}
+	return d
+}
+
+// series orders the days and fills the gaps between them with empty ones, so
+// the series can be charted as is. Days after now, and more than seriesYears
+// before the latest of the rest, are left out.
+func series(daily map[string]*Day, now time.Time) []Day {
+	days := []Day{}
+
+	// dates past year 9999 don't parse and are left out along with the rest
+	var dates []time.Time
+	for date := range daily {
+		if t, err := time.Parse("2006-01-02", date); err == nil && !t.After(now) {
+			dates = append(dates, t)
+		}
+	}
+	if len(dates) == 0 {
+		return days
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	first, last := dates[0], dates[len(dates)-1]
+	if earliest := last.AddDate(-seriesYears, 0, 0); first.Before(earliest) {
+		first = earliest
+	}
+	for t := first; !t.After(last); t = t.AddDate(0, 0, 1) {
+		date := t.Format("2006-01-02")
+		if d, ok := daily[date]; ok {
+			days = append(days, *d)
+		} else {
+			days = append(days, Day{Date: date})
+		}
+	}
+	return days
+}
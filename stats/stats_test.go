@@ -0,0 +1,140 @@
+package stats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/thealamu/linkedinsignin/config"
+	"github.com/thealamu/linkedinsignin/model"
+	"github.com/thealamu/linkedinsignin/repository"
+)
+
+func TestAggregate(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{})
+	for _, u := range []model.User{
+		{Email: "a@example.com", LearningTrack: "Frontend", State: "TX", Enrolled: true},
+		{Email: "b@example.com", LearningTrack: "Frontend", State: "TX"},
+		{Email: "c@example.com", LearningTrack: "Backend", Enrolled: true},
+		{Email: "d@example.com", LearningTrack: "Frontend"},
+	} {
+		if _, err := repo.CreateUser(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := Aggregate(ctx, repo, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.SignedIn != 4 || report.Enrolled != 2 || report.ConversionRate != 0.5 {
+		t.Fatalf("unexpected totals %+v", report.Count)
+	}
+	if c := report.ByLearningTrack["Frontend"]; c == nil || c.SignedIn != 3 || c.Enrolled != 1 {
+		t.Fatalf("unexpected Frontend count %+v", c)
+	}
+	if c := report.ByState[Unspecified]; c == nil || c.SignedIn != 2 {
+		t.Fatalf("unexpected unspecified state count %+v", c)
+	}
+	if len(report.Daily) != 1 || report.Daily[0].SignedIn != 4 || report.Daily[0].Enrolled != 2 {
+		t.Fatalf("unexpected series %+v", report.Daily)
+	}
+}
+
+func TestSeries(t *testing.T) {
+	now := time.Date(2021, 4, 3, 12, 0, 0, 0, time.UTC)
+	days := series(map[string]*Day{
+		"0201-03-30": {Date: "0201-03-30", SignedIn: 1},
+		"2021-03-30": {Date: "2021-03-30", SignedIn: 2},
+		"2021-04-02": {Date: "2021-04-02", Enrolled: 1},
+		"2201-04-02": {Date: "2201-04-02", Enrolled: 1},
+	}, now)
+	if n := len(days); n != 3654 {
+		t.Fatalf("expected the series to span %d years, got %d days", seriesYears, n)
+	}
+	days = days[len(days)-4:]
+
+	want := []string{"2021-03-30", "2021-03-31", "2021-04-01", "2021-04-02"}
+	if len(days) != len(want) {
+		t.Fatalf("expected %d days, got %+v", len(want), days)
+	}
+	for i, d := range days {
+		if d.Date != want[i] {
+			t.Fatalf("expected %s at %d, got %s", want[i], i, d.Date)
+		}
+	}
+	if days[0].SignedIn != 2 || days[1].SignedIn != 0 || days[3].Enrolled != 1 {
+		t.Fatalf("unexpected counts %+v", days)
+	}
+}
+
+func TestReportCache(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{})
+	service := New(zerolog.Nop(), config.Environment{config.StatsCacheTTL: "1h"}, repo)
+
+	first, err := service.Report(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateUser(ctx, model.User{Email: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	second, err := service.Report(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first || second.SignedIn != 0 {
+		t.Fatalf("expected the cached report, got %+v", second)
+	}
+
+	service = New(zerolog.Nop(), config.Environment{config.StatsCacheTTL: "0s"}, repo)
+	third, err := service.Report(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.SignedIn != 1 {
+		t.Fatalf("expected a fresh report, got %+v", third)
+	}
+}
+
+// blockingLister holds ListUsers until release is closed.
+type blockingLister struct {
+	repository.UserLister
+	release chan struct{}
+}
+
+func (b blockingLister) ListUsers(ctx context.Context, filter repository.UserFilter, cursor string, limit int) (*repository.UserPage, error) {
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return b.UserLister.ListUsers(ctx, filter, cursor, limit)
+}
+
+func TestReportOutlivesCaller(t *testing.T) {
+	repo := repository.NewMemoryUserRepository(zerolog.Nop(), model.EmailPolicy{})
+	users := blockingLister{UserLister: repo, release: make(chan struct{})}
+	service := New(zerolog.Nop(), config.Environment{config.StatsCacheTTL: "1h"}, users)
+
+	// the first caller gives up while the report is aggregated
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := service.Report(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the caller's deadline, got %v", err)
+	}
+
+	close(users.release)
+	report, err := service.Report(context.Background())
+	if err != nil {
+		t.Fatalf("expected the aggregation to carry on for the next caller, got %v", err)
+	}
+	if report.SignedIn != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}